@@ -135,6 +135,15 @@ run_unit_tests() {
         return 1
     fi
     
+    # Test retry and messaging helpers
+    $TEST_CMD ./shared/retry/... ./shared/messaging/...
+    if [ $? -eq 0 ]; then
+        print_success "Shared retry and messaging tests passed"
+    else
+        print_error "Shared retry and messaging tests failed"
+        return 1
+    fi
+    
     # Test user service
     $TEST_CMD ./services/user-service/internal/service/...
     if [ $? -eq 0 ]; then
@@ -144,6 +153,15 @@ run_unit_tests() {
         return 1
     fi
     
+    # Test in-memory repository
+    $TEST_CMD ./services/user-service/internal/infrastructure/repository/...
+    if [ $? -eq 0 ]; then
+        print_success "User repository tests passed"
+    else
+        print_error "User repository tests failed"
+        return 1
+    fi
+    
     # Test location history service
     $TEST_CMD ./services/location-history-service/...
     if [ $? -eq 0 ]; then
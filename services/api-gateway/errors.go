@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"go-clinet-locations/shared/contracts"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"net/http"
+)
+
+// validationErrors collects the invalid fields of a request, so the client learns about all of them at once
+type validationErrors []contracts.FieldError
+
+func (v *validationErrors) add(code, field, message string) {
+	*v = append(*v, contracts.FieldError{Field: field, Code: code, Message: message})
+}
+
+// writeError answers with the error envelope
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeAPIError(w, status, &contracts.APIError{Code: code, Message: message})
+}
+
+// writeValidationError answers 400, the code of the response is the code of the first invalid field
+func writeValidationError(w http.ResponseWriter, errs validationErrors) {
+	message := errs[0].Message
+	if len(errs) > 1 {
+		message = fmt.Sprintf("%d fields are invalid", len(errs))
+	}
+
+	writeAPIError(w, http.StatusBadRequest, &contracts.APIError{
+		Code:    errs[0].Code,
+		Message: message,
+		Details: errs,
+	})
+}
+
+// writeUpstreamError translates the gRPC status of a failed backend call to the HTTP response.
+// The messages of client errors come from the backend, the others are replaced so no internals leak.
+func writeUpstreamError(w http.ResponseWriter, err error, service, message string) {
+	log.Printf("%s: %v", message, err)
+
+	st := status.Convert(err)
+	switch st.Code() {
+	case codes.InvalidArgument:
+		writeError(w, http.StatusBadRequest, contracts.ErrCodeInvalidParameter, st.Message())
+	case codes.NotFound:
+		// every resource of the API belongs to a user
+		writeError(w, http.StatusNotFound, contracts.ErrCodeUserNotFound, st.Message())
+	case codes.AlreadyExists:
+		writeError(w, http.StatusConflict, contracts.ErrCodeAlreadyExists, st.Message())
+	case codes.Unavailable:
+		writeError(w, http.StatusServiceUnavailable, contracts.ErrCodeUpstreamUnavailable, service+" is unavailable")
+	case codes.DeadlineExceeded:
+		writeError(w, http.StatusGatewayTimeout, contracts.ErrCodeUpstreamTimeout, service+" did not respond in time")
+	default:
+		writeError(w, http.StatusInternalServerError, contracts.ErrCodeInternal, message)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, status int, apiErr *contracts.APIError) {
+	if err := writeJSON(w, status, contracts.APIResponse{Error: apiErr}); err != nil {
+		log.Printf("Failed to write error response: %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"go-clinet-locations/shared/contracts"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteUpstreamError(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		expectedStatus int
+		expectedCode   string
+	}{
+		{name: "invalid argument", err: status.Error(codes.InvalidArgument, "invalid page cursor"), expectedStatus: http.StatusBadRequest, expectedCode: contracts.ErrCodeInvalidParameter},
+		{name: "not found", err: status.Error(codes.NotFound, "user not found"), expectedStatus: http.StatusNotFound, expectedCode: contracts.ErrCodeUserNotFound},
+		{name: "already exists", err: status.Error(codes.AlreadyExists, "userName is taken"), expectedStatus: http.StatusConflict, expectedCode: contracts.ErrCodeAlreadyExists},
+		{name: "unavailable", err: status.Error(codes.Unavailable, "connection refused"), expectedStatus: http.StatusServiceUnavailable, expectedCode: contracts.ErrCodeUpstreamUnavailable},
+		{name: "deadline exceeded", err: status.Error(codes.DeadlineExceeded, "context deadline exceeded"), expectedStatus: http.StatusGatewayTimeout, expectedCode: contracts.ErrCodeUpstreamTimeout},
+		{name: "internal", err: status.Error(codes.Internal, "failed to decode user"), expectedStatus: http.StatusInternalServerError, expectedCode: contracts.ErrCodeInternal},
+		{name: "not a status", err: errors.New("boom"), expectedStatus: http.StatusInternalServerError, expectedCode: contracts.ErrCodeInternal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+
+			writeUpstreamError(w, tt.err, "user service", "failed to update a user")
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			apiErr := decodeAPIError(t, w)
+			if apiErr.Code != tt.expectedCode {
+				t.Errorf("expected code %s, got %s", tt.expectedCode, apiErr.Code)
+			}
+			if tt.expectedStatus == http.StatusInternalServerError && apiErr.Message != "failed to update a user" {
+				t.Errorf("expected the internal error to be hidden, got %q", apiErr.Message)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	pb_loction "go-clinet-locations/shared/proto/location"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// historyWriter writes an exported history as it arrives, so the document is never held in memory
+type historyWriter interface {
+	// begin writes everything that comes before the first record
+	begin() error
+	write(records []*pb_loction.LocationRecord) error
+	// end closes the document, it is not called when the export fails half way
+	end() error
+}
+
+type exportFormat struct {
+	contentType string
+	extension   string
+	newWriter   func(w io.Writer) historyWriter
+}
+
+var exportFormats = map[string]exportFormat{
+	"geojson": {
+		contentType: "application/geo+json",
+		extension:   "geojson",
+		newWriter:   func(w io.Writer) historyWriter { return &geoJSONWriter{w: w} },
+	},
+	"gpx": {
+		contentType: "application/gpx+xml",
+		extension:   "gpx",
+		newWriter:   func(w io.Writer) historyWriter { return &gpxWriter{w: w} },
+	},
+	"csv": {
+		contentType: "text/csv",
+		extension:   "csv",
+		newWriter:   func(w io.Writer) historyWriter { return &csvWriter{w: csv.NewWriter(w)} },
+	},
+}
+
+const defaultExportFormat = "geojson"
+
+// exportFormatNames lists the supported formats for the validation message
+func exportFormatNames() string {
+	names := make([]string, 0, len(exportFormats))
+	for name := range exportFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// geoJSONWriter writes a FeatureCollection with a Point feature per fix
+type geoJSONWriter struct {
+	w       io.Writer
+	written bool
+}
+
+type geoJSONFeature struct {
+	Type       string            `json:"type"`
+	Geometry   geoJSONPoint      `json:"geometry"`
+	Properties map[string]string `json:"properties"`
+}
+
+type geoJSONPoint struct {
+	Type string `json:"type"`
+	// GeoJSON puts the longitude first
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+func (g *geoJSONWriter) begin() error {
+	_, err := io.WriteString(g.w, `{"type":"FeatureCollection","features":[`)
+	return err
+}
+
+func (g *geoJSONWriter) write(records []*pb_loction.LocationRecord) error {
+	for _, record := range records {
+		feature, err := json.Marshal(geoJSONFeature{
+			Type: "Feature",
+			Geometry: geoJSONPoint{
+				Type:        "Point",
+				Coordinates: [2]float64{record.GetCoordinate().GetLongitude(), record.GetCoordinate().GetLatitude()},
+			},
+			Properties: map[string]string{"timestamp": record.GetTimestamp()},
+		})
+		if err != nil {
+			return err
+		}
+
+		if g.written {
+			if _, err := io.WriteString(g.w, ","); err != nil {
+				return err
+			}
+		}
+		if _, err := g.w.Write(feature); err != nil {
+			return err
+		}
+		g.written = true
+	}
+	return nil
+}
+
+func (g *geoJSONWriter) end() error {
+	_, err := io.WriteString(g.w, "]}\n")
+	return err
+}
+
+// gpxWriter writes a GPX 1.1 track with a single segment, the fixes are its points
+type gpxWriter struct {
+	w io.Writer
+}
+
+type gpxTrackPoint struct {
+	XMLName   xml.Name `xml:"trkpt"`
+	Latitude  float64  `xml:"lat,attr"`
+	Longitude float64  `xml:"lon,attr"`
+	Time      string   `xml:"time"`
+}
+
+func (g *gpxWriter) begin() error {
+	_, err := io.WriteString(g.w, xml.Header+
+		`<gpx version="1.1" creator="go-clinet-locations" xmlns="http://www.topografix.com/GPX/1/1"><trk><trkseg>`)
+	return err
+}
+
+func (g *gpxWriter) write(records []*pb_loction.LocationRecord) error {
+	encoder := xml.NewEncoder(g.w)
+	for _, record := range records {
+		err := encoder.Encode(gpxTrackPoint{
+			Latitude:  record.GetCoordinate().GetLatitude(),
+			Longitude: record.GetCoordinate().GetLongitude(),
+			Time:      record.GetTimestamp(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return encoder.Close()
+}
+
+func (g *gpxWriter) end() error {
+	_, err := io.WriteString(g.w, "</trkseg></trk></gpx>\n")
+	return err
+}
+
+// csvWriter writes a header row and a row per fix
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvWriter) begin() error {
+	if err := c.w.Write([]string{"timestamp", "latitude", "longitude"}); err != nil {
+		return err
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvWriter) write(records []*pb_loction.LocationRecord) error {
+	for _, record := range records {
+		err := c.w.Write([]string{
+			record.GetTimestamp(),
+			strconv.FormatFloat(record.GetCoordinate().GetLatitude(), 'f', -1, 64),
+			strconv.FormatFloat(record.GetCoordinate().GetLongitude(), 'f', -1, 64),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvWriter) end() error {
+	return nil
+}
+
+// exportFileName is the name the browser saves the export under
+func exportFileName(id string, format exportFormat) string {
+	return fmt.Sprintf("history-%s.%s", id, format.extension)
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	pb_loction "go-clinet-locations/shared/proto/location"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var exportChunks = []*pb_loction.ExportHistoryResponse{
+	{Records: []*pb_loction.LocationRecord{
+		{Coordinate: &pb_loction.Coordinate{Latitude: 51.1, Longitude: 17.03}, Timestamp: "2024-05-10T12:00:00Z"},
+		{Coordinate: &pb_loction.Coordinate{Latitude: 51.2, Longitude: 17.04}, Timestamp: "2024-05-10T12:01:00Z"},
+	}},
+	{Records: []*pb_loction.LocationRecord{
+		{Coordinate: &pb_loction.Coordinate{Latitude: 51.3, Longitude: 17.05}, Timestamp: "2024-05-10T12:02:00Z"},
+	}},
+}
+
+// writeExport runs the writer of the format over the chunks
+func writeExport(t *testing.T, formatName string, chunks []*pb_loction.ExportHistoryResponse) []byte {
+	var buf bytes.Buffer
+	writer := exportFormats[formatName].newWriter(&buf)
+
+	if err := writer.begin(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, chunk := range chunks {
+		if err := writer.write(chunk.GetRecords()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := writer.end(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestGeoJSONWriter(t *testing.T) {
+	tests := []struct {
+		name             string
+		chunks           []*pb_loction.ExportHistoryResponse
+		expectedFeatures int
+	}{
+		{name: "chunks", chunks: exportChunks, expectedFeatures: 3},
+		{name: "empty history", expectedFeatures: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var collection struct {
+				Type     string `json:"type"`
+				Features []struct {
+					Geometry struct {
+						Type        string     `json:"type"`
+						Coordinates [2]float64 `json:"coordinates"`
+					} `json:"geometry"`
+					Properties struct {
+						Timestamp string `json:"timestamp"`
+					} `json:"properties"`
+				} `json:"features"`
+			}
+			if err := json.Unmarshal(writeExport(t, "geojson", tt.chunks), &collection); err != nil {
+				t.Fatalf("expected valid JSON, got %v", err)
+			}
+
+			if collection.Type != "FeatureCollection" {
+				t.Errorf("expected a FeatureCollection, got %q", collection.Type)
+			}
+			if len(collection.Features) != tt.expectedFeatures {
+				t.Fatalf("expected %d features, got %d", tt.expectedFeatures, len(collection.Features))
+			}
+			if tt.expectedFeatures > 0 {
+				first := collection.Features[0]
+				if first.Geometry.Type != "Point" || first.Geometry.Coordinates != [2]float64{17.03, 51.1} {
+					t.Errorf("expected a point at [17.03 51.1], got %s %v", first.Geometry.Type, first.Geometry.Coordinates)
+				}
+				if first.Properties.Timestamp != "2024-05-10T12:00:00Z" {
+					t.Errorf("expected timestamp 2024-05-10T12:00:00Z, got %q", first.Properties.Timestamp)
+				}
+			}
+		})
+	}
+}
+
+func TestGPXWriter(t *testing.T) {
+	var gpx struct {
+		XMLName xml.Name `xml:"http://www.topografix.com/GPX/1/1 gpx"`
+		Version string   `xml:"version,attr"`
+		Points  []struct {
+			Latitude  float64 `xml:"lat,attr"`
+			Longitude float64 `xml:"lon,attr"`
+			Time      string  `xml:"time"`
+		} `xml:"trk>trkseg>trkpt"`
+	}
+	if err := xml.Unmarshal(writeExport(t, "gpx", exportChunks), &gpx); err != nil {
+		t.Fatalf("expected valid GPX, got %v", err)
+	}
+
+	if gpx.Version != "1.1" {
+		t.Errorf("expected GPX 1.1, got %q", gpx.Version)
+	}
+	if len(gpx.Points) != 3 {
+		t.Fatalf("expected 3 track points, got %d", len(gpx.Points))
+	}
+	last := gpx.Points[2]
+	if last.Latitude != 51.3 || last.Longitude != 17.05 || last.Time != "2024-05-10T12:02:00Z" {
+		t.Errorf("expected the last point at 51.3,17.05 at 2024-05-10T12:02:00Z, got %v,%v at %s", last.Latitude, last.Longitude, last.Time)
+	}
+}
+
+func TestCSVWriter(t *testing.T) {
+	rows, err := csv.NewReader(bytes.NewReader(writeExport(t, "csv", exportChunks))).ReadAll()
+	if err != nil {
+		t.Fatalf("expected valid CSV, got %v", err)
+	}
+
+	expected := [][]string{
+		{"timestamp", "latitude", "longitude"},
+		{"2024-05-10T12:00:00Z", "51.1", "17.03"},
+		{"2024-05-10T12:01:00Z", "51.2", "17.04"},
+		{"2024-05-10T12:02:00Z", "51.3", "17.05"},
+	}
+	if len(rows) != len(expected) {
+		t.Fatalf("expected %d rows, got %d", len(expected), len(rows))
+	}
+	for i := range expected {
+		if strings.Join(rows[i], ",") != strings.Join(expected[i], ",") {
+			t.Errorf("expected row %v, got %v", expected[i], rows[i])
+		}
+	}
+}
+
+// fakeExportStream replays the chunks and then ends with err, or with io.EOF when err is nil
+type fakeExportStream struct {
+	grpc.ClientStream
+	chunks []*pb_loction.ExportHistoryResponse
+	err    error
+}
+
+func (f *fakeExportStream) Recv() (*pb_loction.ExportHistoryResponse, error) {
+	if len(f.chunks) == 0 {
+		if f.err != nil {
+			return nil, f.err
+		}
+		return nil, io.EOF
+	}
+	chunk := f.chunks[0]
+	f.chunks = f.chunks[1:]
+	return chunk, nil
+}
+
+// fakeExportService answers ExportHistory with the stream and remembers the request
+type fakeExportService struct {
+	pb_loction.LocationServiceClient
+	stream  *fakeExportStream
+	request *pb_loction.ExportHistoryRequest
+}
+
+func (f *fakeExportService) ExportHistory(ctx context.Context, req *pb_loction.ExportHistoryRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[pb_loction.ExportHistoryResponse], error) {
+	f.request = req
+	return f.stream, nil
+}
+
+func TestHandleExportHistory(t *testing.T) {
+	tests := []struct {
+		name                string
+		user                string
+		query               string
+		stream              *fakeExportStream
+		expectedStatus      int
+		expectedContentType string
+		expectedUserId      string
+	}{
+		{
+			name:                "geojson by default",
+			user:                "65f1c0ffee0123456789abcd",
+			stream:              &fakeExportStream{chunks: exportChunks},
+			expectedStatus:      http.StatusOK,
+			expectedContentType: "application/geo+json",
+			expectedUserId:      "65f1c0ffee0123456789abcd",
+		},
+		{
+			name:                "csv by userName",
+			user:                "testuser123",
+			query:               "format=csv",
+			stream:              &fakeExportStream{chunks: exportChunks},
+			expectedStatus:      http.StatusOK,
+			expectedContentType: "text/csv",
+			expectedUserId:      "65f1c0ffee0123456789abcd",
+		},
+		{
+			name:           "unknown format",
+			user:           "65f1c0ffee0123456789abcd",
+			query:          "format=kml",
+			stream:         &fakeExportStream{},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "repeated startTime",
+			user:           "65f1c0ffee0123456789abcd",
+			query:          "startTime=2024-05-10T12:00:00Z&startTime=2024-05-11T12:00:00Z",
+			stream:         &fakeExportStream{},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "unknown userName",
+			user:           "nobody",
+			stream:         &fakeExportStream{},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "no history",
+			user:           "65f1c0ffee0123456789abcd",
+			stream:         &fakeExportStream{err: status.Error(codes.NotFound, "user not found")},
+			expectedStatus: http.StatusNotFound,
+			expectedUserId: "65f1c0ffee0123456789abcd",
+		},
+		{
+			name:           "invalid range",
+			user:           "65f1c0ffee0123456789abcd",
+			query:          "startTime=yesterday",
+			stream:         &fakeExportStream{err: status.Error(codes.InvalidArgument, "startDate must be an RFC 3339 timestamp")},
+			expectedStatus: http.StatusBadRequest,
+			expectedUserId: "65f1c0ffee0123456789abcd",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			locations := &fakeExportService{stream: tt.stream}
+			g := newGateway(&fakeUserService{ids: map[string]string{"testuser123": "65f1c0ffee0123456789abcd"}}, locations)
+
+			req := httptest.NewRequest("GET", "/user/"+tt.user+"/history/export?"+tt.query, nil)
+			req.SetPathValue("id", tt.user)
+			w := httptest.NewRecorder()
+
+			g.HandleExportHistory(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if locations.request.GetUserId() != tt.expectedUserId {
+				t.Errorf("expected the history of user %q, got %q", tt.expectedUserId, locations.request.GetUserId())
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			if contentType := w.Header().Get("Content-Type"); contentType != tt.expectedContentType {
+				t.Errorf("expected Content-Type %q, got %q", tt.expectedContentType, contentType)
+			}
+			if disposition := w.Header().Get("Content-Disposition"); !strings.HasPrefix(disposition, "attachment") {
+				t.Errorf("expected an attachment, got %q", disposition)
+			}
+			if !w.Flushed {
+				t.Errorf("expected the chunks to be flushed as they arrive")
+			}
+		})
+	}
+}
+
+func TestHandleExportHistory_FailureAfterFirstChunk(t *testing.T) {
+	locations := &fakeExportService{stream: &fakeExportStream{
+		chunks: exportChunks[:1],
+		err:    status.Error(codes.Unavailable, "connection lost"),
+	}}
+	g := newGateway(&fakeUserService{}, locations)
+
+	req := httptest.NewRequest("GET", "/user/65f1c0ffee0123456789abcd/history/export", nil)
+	req.SetPathValue("id", "65f1c0ffee0123456789abcd")
+	w := httptest.NewRecorder()
+
+	defer func() {
+		if recovered := recover(); recovered != http.ErrAbortHandler {
+			t.Errorf("expected the handler to abort, got %v", recovered)
+		}
+		if strings.HasSuffix(w.Body.String(), "]}\n") {
+			t.Errorf("expected a truncated document, got %s", w.Body.String())
+		}
+	}()
+
+	g.HandleExportHistory(w, req)
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	pb_loction "go-clinet-locations/shared/proto/location"
+	pb_user "go-clinet-locations/shared/proto/user"
+)
+
+// gateway serves the HTTP API with clients of the backend services that are created once in main
+// and shared by every request
+type gateway struct {
+	users     pb_user.UserServiceClient
+	locations pb_loction.LocationServiceClient
+}
+
+func newGateway(users pb_user.UserServiceClient, locations pb_loction.LocationServiceClient) *gateway {
+	return &gateway{
+		users:     users,
+		locations: locations,
+	}
+}
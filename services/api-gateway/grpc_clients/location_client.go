@@ -3,8 +3,6 @@ package grpc_clients
 import (
 	pb "go-clinet-locations/shared/proto/location"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
-	"os"
 )
 
 type locationServiceClient struct {
@@ -12,12 +10,9 @@ type locationServiceClient struct {
 	conn   *grpc.ClientConn
 }
 
-func NewLocationServiceClient() (*locationServiceClient, error) {
-	locationServiceURL := os.Getenv("LOCATION_SERVICE_URL")
-	if locationServiceURL == "" {
-		locationServiceURL = "location-history-service:9092"
-	}
-	conn, err := grpc.NewClient(locationServiceURL, grpc.WithTransportCredentials(insecure.NewCredentials()))
+// NewLocationServiceClient creates a client meant to live as long as the gateway, see NewUserServiceClient
+func NewLocationServiceClient(address string) (*locationServiceClient, error) {
+	conn, err := grpc.NewClient(dnsTarget(address), dialOptions()...)
 	if err != nil {
 		return nil, err
 	}
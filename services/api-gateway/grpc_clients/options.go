@@ -0,0 +1,35 @@
+package grpc_clients
+
+import (
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+	"strings"
+	"time"
+)
+
+// roundRobinConfig spreads the calls over every address the resolver returns instead of using the first one
+const roundRobinConfig = `{"loadBalancingConfig": [{"round_robin": {}}]}`
+
+// dialOptions are shared by the long-lived clients of the gateway. Keepalive pings detect a dead
+// backend between requests, so a call fails fast with Unavailable instead of hanging on a broken connection.
+// The backends must permit the pings with a KeepaliveEnforcementPolicy whose MinTime is below Time.
+func dialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultServiceConfig(roundRobinConfig),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                30 * time.Second,
+			Timeout:             10 * time.Second,
+			PermitWithoutStream: true,
+		}),
+	}
+}
+
+// dnsTarget resolves host:port through DNS, so every address of a headless service is balanced over
+func dnsTarget(address string) string {
+	if strings.Contains(address, ":///") {
+		return address
+	}
+	return "dns:///" + address
+}
@@ -3,8 +3,6 @@ package grpc_clients
 import (
 	pb "go-clinet-locations/shared/proto/user"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
-	"os"
 )
 
 type userServiceClient struct {
@@ -12,12 +10,10 @@ type userServiceClient struct {
 	conn   *grpc.ClientConn
 }
 
-func NewUserServiceClient() (*userServiceClient, error) {
-	userServiceURL := os.Getenv("USER_SERVICE_URL")
-	if userServiceURL == "" {
-		userServiceURL = "user-service:9093"
-	}
-	conn, err := grpc.NewClient(userServiceURL, grpc.WithTransportCredentials(insecure.NewCredentials()))
+// NewUserServiceClient creates a client meant to live as long as the gateway, it connects lazily
+// and reconnects on its own, so it only fails for a malformed address
+func NewUserServiceClient(address string) (*userServiceClient, error) {
+	conn, err := grpc.NewClient(dnsTarget(address), dialOptions()...)
 	if err != nil {
 		return nil, err
 	}
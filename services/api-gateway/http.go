@@ -1,101 +1,80 @@
 package main
 
 import (
+	"encoding/hex"
 	"encoding/json"
-	"go-clinet-locations/services/api-gateway/grpc_clients"
+	"fmt"
 	"go-clinet-locations/shared/contracts"
 	pb_loction "go-clinet-locations/shared/proto/location"
 	pb_user "go-clinet-locations/shared/proto/user"
 	"go-clinet-locations/shared/util"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
-	"strings"
+	"time"
 )
 
-func HandleCreateUser(w http.ResponseWriter, r *http.Request) {
+func (g *gateway) HandleCreateUser(w http.ResponseWriter, r *http.Request) {
 	var reqBody userLocationRequest
 
 	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
 		log.Println(err)
-		http.Error(w, "failed to parse JSON data", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, contracts.ErrCodeInvalidJSON, "failed to parse JSON data")
 		return
 	}
 	defer r.Body.Close()
 
 	// validation
 
+	var errs validationErrors
 	if err := util.ValidateUserName(reqBody.UserName); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+		errs.add(contracts.ErrCodeInvalidUsername, "userName", err.Error())
 	}
-
-	if reqBody.Coordinate.Longitude == 0 || reqBody.Coordinate.Latitude == 0 {
-		http.Error(w, "invalid location data", http.StatusBadRequest)
+	reqBody.validateLocation(&errs, time.Now())
+	if len(errs) > 0 {
+		writeValidationError(w, errs)
 		return
 	}
 
-	userService, err := grpc_clients.NewUserServiceClient()
-
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	defer userService.Close()
-
-	newUser, err := userService.Client.CreateUser(r.Context(), reqBody.toProto())
+	newUser, err := g.users.CreateUser(r.Context(), reqBody.toProto())
 	if err != nil {
-		log.Printf("Failed to create a user: %v", err)
-		http.Error(w, "Failed to create a user", http.StatusInternalServerError)
+		writeUpstreamError(w, err, "user service", "failed to create a user")
 		return
-
 	}
 
 	response := contracts.APIResponse{Data: newUser}
 
 	writeJSON(w, http.StatusOK, response)
 }
-func HandleUpdateUser(w http.ResponseWriter, r *http.Request) {
+func (g *gateway) HandleUpdateUser(w http.ResponseWriter, r *http.Request) {
 	var reqBody userLocationRequest
 
 	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
 		log.Println(err)
-		http.Error(w, "failed to parse JSON data", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, contracts.ErrCodeInvalidJSON, "failed to parse JSON data")
 		return
 	}
 	defer r.Body.Close()
 
 	// validation
 
+	var errs validationErrors
 	if reqBody.UserName == "" {
-		http.Error(w, "failed to parse JSON data", http.StatusBadRequest)
-		return
+		errs.add(contracts.ErrCodeInvalidUsername, "userName", "userName is required")
 	}
-
-	if reqBody.Coordinate.Longitude == 0 || reqBody.Coordinate.Latitude == 0 {
-		http.Error(w, "invalid location data", http.StatusBadRequest)
+	reqBody.validateLocation(&errs, time.Now())
+	if len(errs) > 0 {
+		writeValidationError(w, errs)
 		return
 	}
-	userService, err := grpc_clients.NewUserServiceClient()
 
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	defer userService.Close()
-
-	newUser, err := userService.Client.UpdateUser(r.Context(), reqBody.toProto())
+	newUser, err := g.users.UpdateUser(r.Context(), reqBody.toProto())
 
 	if err != nil {
-		log.Printf("Failed to update a user: %v", err)
-		// Check if it's a "user not found" error and return 400 Bad Request
-		if strings.Contains(err.Error(), "user not found") {
-			http.Error(w, "User not found", http.StatusBadRequest)
-			return
-		}
-		http.Error(w, "Failed to update a user", http.StatusInternalServerError)
+		writeUpstreamError(w, err, "user service", "failed to update a user")
 		return
-
 	}
 
 	response := contracts.APIResponse{Data: newUser}
@@ -104,140 +83,453 @@ func HandleUpdateUser(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func HandleSearchUser(w http.ResponseWriter, r *http.Request) {
+func (g *gateway) HandleSearchUser(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query()
 
 	log.Println("Handle Search User ")
 
-	// Coordinates validation
+	var errs validationErrors
+	latitude, longitude := parseCoordinate(q, &errs)
 
-	if len(q["lat"]) != 1 {
-		http.Error(w, "failed to retrieve coordinates", http.StatusBadRequest)
-		return
+	radius := 5.0
+	if len(q["r"]) > 0 {
+		if rad := q["r"][0]; rad != "" {
+			r, err := strconv.ParseFloat(rad, 64)
+			if err != nil {
+				errs.add(contracts.ErrCodeInvalidParameter, "r", "failed to parse radius")
+			}
+			radius = r
+		}
 	}
-	if len(q["lon"]) != 1 {
-		http.Error(w, "failed to retrieve coordinates", http.StatusBadRequest)
+
+	page := parseSearchPage(q, &errs)
+	if len(errs) > 0 {
+		writeValidationError(w, errs)
 		return
 	}
-	lat := q["lat"][0]
-	lon := q["lon"][0]
 
-	latitude, err := strconv.ParseFloat(lat, 64)
+	filteredUsers, err := g.users.SearchUsers(r.Context(), &pb_user.SearchUsersRequest{
+		Area: &pb_user.SearchUsersRequest_Circle{
+			Circle: &pb_user.Circle{
+				Center: &pb_user.Coordinate{
+					Latitude:  latitude,
+					Longitude: longitude,
+				},
+				Radius: radius,
+			},
+		},
+		PageSize:  int32(page.limit),
+		PageToken: page.cursor,
+		Sort:      page.sort,
+	})
+
 	if err != nil {
-		http.Error(w, "failed to parse latitude", http.StatusBadRequest)
+		writeUpstreamError(w, err, "user service", "failed to search users")
+		return
+	}
+
+	res := contracts.APIResponse{Data: filteredUsers}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+// HandleSearchUsersArea searches the users inside a GeoJSON polygon or bounding box sent in the body
+func (g *gateway) HandleSearchUsersArea(w http.ResponseWriter, r *http.Request) {
+	var reqBody searchAreaRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		log.Println(err)
+		writeError(w, http.StatusBadRequest, contracts.ErrCodeInvalidJSON, "failed to parse JSON data")
 		return
 	}
+	defer r.Body.Close()
 
-	longitude, err := strconv.ParseFloat(lon, 64)
+	var errs validationErrors
+	page := parseSearchPage(r.URL.Query(), &errs)
+	if len(errs) > 0 {
+		writeValidationError(w, errs)
+		return
+	}
 
+	searchRequest, err := reqBody.toProto(page)
 	if err != nil {
-		http.Error(w, "failed to parse longitude", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, contracts.ErrCodeInvalidArea, err.Error())
 		return
 	}
 
-	err = util.ValidateCords(latitude, longitude)
+	filteredUsers, err := g.users.SearchUsers(r.Context(), searchRequest)
+
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeUpstreamError(w, err, "user service", "failed to search users")
 		return
 	}
 
-	radius := 5.0
-	if len(q["r"]) > 0 {
-		if rad := q["r"][0]; rad != "" {
-			r, err := strconv.ParseFloat(rad, 64)
-			if err != nil {
-				http.Error(w, "failed to parse radius", http.StatusBadRequest)
+	res := contracts.APIResponse{Data: filteredUsers}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+// parseCoordinate reads the lat and lon query params shared by the search routes
+func parseCoordinate(q url.Values, errs *validationErrors) (float64, float64) {
+	if len(q["lat"]) != 1 || len(q["lon"]) != 1 {
+		errs.add(contracts.ErrCodeInvalidCoordinates, "lat,lon", "failed to retrieve coordinates")
+		return 0, 0
+	}
+
+	latitude, err := strconv.ParseFloat(q["lat"][0], 64)
+	if err != nil {
+		errs.add(contracts.ErrCodeInvalidCoordinates, "lat", "failed to parse latitude")
+		return 0, 0
+	}
+
+	longitude, err := strconv.ParseFloat(q["lon"][0], 64)
+	if err != nil {
+		errs.add(contracts.ErrCodeInvalidCoordinates, "lon", "failed to parse longitude")
+		return 0, 0
+	}
+
+	if err := util.ValidateCords(latitude, longitude); err != nil {
+		errs.add(contracts.ErrCodeInvalidCoordinates, "lat,lon", err.Error())
+	}
+
+	return latitude, longitude
+}
+
+// parseSearchPage reads the optional pagination and sort params shared by the search routes
+func parseSearchPage(q url.Values, errs *validationErrors) searchPage {
+	page := parsePage(q, errs)
+
+	// results are nearest first unless sorted by userName
+	page.sort = q.Get("sort")
+	if page.sort != "" && page.sort != "distance" && page.sort != "userName" {
+		errs.add(contracts.ErrCodeInvalidParameter, "sort", "sort must be either distance or userName")
+	}
+
+	return page
+}
+
+// parsePage reads the optional limit and cursor params, the user service applies the default page size
+func parsePage(q url.Values, errs *validationErrors) searchPage {
+	var page searchPage
+
+	if len(q["limit"]) > 0 {
+		if l := q["limit"][0]; l != "" {
+			limit, err := strconv.Atoi(l)
+			if err != nil || limit < 1 || limit > maxSearchLimit {
+				errs.add(contracts.ErrCodeInvalidParameter, "limit", fmt.Sprintf("limit must be a number between 1 and %d", maxSearchLimit))
 			}
-			radius = r
+			page.limit = limit
 		}
 	}
 
-	userService, err := grpc_clients.NewUserServiceClient()
+	if len(q["cursor"]) > 1 {
+		errs.add(contracts.ErrCodeInvalidParameter, "cursor", "something wrong with cursor param")
+	}
+	page.cursor = q.Get("cursor")
 
-	if err != nil {
-		log.Fatal(err)
+	return page
+}
+
+func (g *gateway) HandleNearestUsers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	var errs validationErrors
+	latitude, longitude := parseCoordinate(q, &errs)
+
+	// k is optional, the user service applies the default
+	var k int
+	if len(q["k"]) > 0 {
+		if value := q["k"][0]; value != "" {
+			var err error
+			k, err = strconv.Atoi(value)
+			if err != nil || k < 1 || k > maxNearestUsers {
+				errs.add(contracts.ErrCodeInvalidParameter, "k", fmt.Sprintf("k must be a number between 1 and %d", maxNearestUsers))
+			}
+		}
 	}
 
-	defer userService.Close()
+	if len(errs) > 0 {
+		writeValidationError(w, errs)
+		return
+	}
 
-	filteredUsers, err := userService.Client.SearchUsers(r.Context(), &pb_user.SearchUsersRequest{
+	nearestUsers, err := g.users.NearestUsers(r.Context(), &pb_user.NearestUsersRequest{
 		Coordinate: &pb_user.Coordinate{
 			Latitude:  latitude,
 			Longitude: longitude,
 		},
-		Radius: float32(radius),
+		K: int32(k),
 	})
 
 	if err != nil {
-		log.Printf("Failed to search users: %v", err)
-		http.Error(w, "Failed to search users", http.StatusInternalServerError)
+		writeUpstreamError(w, err, "user service", "failed to find nearest users")
 		return
 	}
 
-	res := contracts.APIResponse{Data: filteredUsers}
+	res := contracts.APIResponse{Data: nearestUsers}
 
 	writeJSON(w, http.StatusOK, res)
 }
 
-func HandleCalculateDistance(w http.ResponseWriter, r *http.Request) {
+func (g *gateway) HandleCalculateDistance(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query()
 	userId := q["userId"]
-	startTime := q["startTime"]
-	endTime := q["endTime"]
+	userName := q["userName"]
 
 	// validation
 
-	if len(userId) != 1 {
-		http.Error(w, "something wrong with userId param", http.StatusBadRequest)
+	// the user is identified by exactly one of userId and userName
+	var errs validationErrors
+	switch {
+	case len(userId) == 0 && len(userName) == 0:
+		errs.add(contracts.ErrCodeInvalidParameter, "userId,userName", "userId or userName is required")
+	case len(userId) > 0 && len(userName) > 0:
+		errs.add(contracts.ErrCodeInvalidParameter, "userId,userName", "only one of userId and userName can be set")
+	case len(userId) > 1:
+		errs.add(contracts.ErrCodeInvalidParameter, "userId", "something wrong with userId param")
+	case len(userId) == 1 && userId[0] == "":
+		errs.add(contracts.ErrCodeInvalidParameter, "userId", "userId is missing")
+	case len(userName) > 1:
+		errs.add(contracts.ErrCodeInvalidParameter, "userName", "something wrong with userName param")
+	case len(userName) == 1:
+		if err := util.ValidateUserName(userName[0]); err != nil {
+			errs.add(contracts.ErrCodeInvalidUsername, "userName", err.Error())
+		}
+	}
+
+	// start and end time are optional
+	startTimeParam := optionalParam(q, "startTime", &errs)
+	endTimeParam := optionalParam(q, "endTime", &errs)
+
+	if len(errs) > 0 {
+		writeValidationError(w, errs)
 		return
 	}
 
-	if userId[0] == "" {
-		http.Error(w, "userId is missing", http.StatusBadRequest)
+	// the location service only knows the IDs, so a userName is resolved by the user service first
+	var id string
+	if len(userId) == 1 {
+		id = userId[0]
+	} else {
+		user, err := g.users.GetUser(r.Context(), &pb_user.GetUserRequest{UserName: userName[0]})
+		if err != nil {
+			writeUpstreamError(w, err, "user service", "failed to get a user")
+			return
+		}
+		id = user.GetUser().GetID()
 	}
 
-	// start and end time are optional
-	var startTimeParam string
-	var endTimeParam string
-	if len(startTime) > 1 {
-		http.Error(w, "something wrong with startTime param", http.StatusBadRequest)
+	distance, err := g.locations.CalculateDistance(r.Context(), &pb_loction.CalculateDistanceRequest{
+		UserId:    id,
+		StartDate: startTimeParam,
+		EndDate:   endTimeParam,
+	})
+	if err != nil {
+		writeUpstreamError(w, err, "location service", "failed to calculate distance")
+		return
+	}
+
+	res := contracts.APIResponse{Data: distance}
+
+	writeJSON(w, http.StatusOK, res)
+
+}
+
+func (g *gateway) HandleGetUser(w http.ResponseWriter, r *http.Request) {
+	id, userName, errs := parseUserKey(r.PathValue("userName"))
+	if len(errs) > 0 {
+		writeValidationError(w, errs)
+		return
+	}
+
+	user, err := g.users.GetUser(r.Context(), &pb_user.GetUserRequest{ID: id, UserName: userName})
+	if err != nil {
+		writeUpstreamError(w, err, "user service", "failed to get a user")
+		return
+	}
+
+	res := contracts.APIResponse{Data: user}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (g *gateway) HandleListUsers(w http.ResponseWriter, r *http.Request) {
+	var errs validationErrors
+	page := parsePage(r.URL.Query(), &errs)
+	if len(errs) > 0 {
+		writeValidationError(w, errs)
 		return
 	}
-	if len(startTime) == 1 {
-		startTimeParam = startTime[0]
+
+	users, err := g.users.ListUsers(r.Context(), &pb_user.ListUsersRequest{
+		PageSize:  int32(page.limit),
+		PageToken: page.cursor,
+	})
+	if err != nil {
+		writeUpstreamError(w, err, "user service", "failed to list users")
+		return
 	}
 
-	if len(endTime) > 1 {
-		http.Error(w, "something wrong with endTime param", http.StatusBadRequest)
+	res := contracts.APIResponse{Data: users}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (g *gateway) HandleDeleteUser(w http.ResponseWriter, r *http.Request) {
+	id, userName, errs := parseUserKey(r.PathValue("userName"))
+	if len(errs) > 0 {
+		writeValidationError(w, errs)
 		return
 	}
 
-	if len(endTime) == 1 {
-		endTimeParam = endTime[0]
+	deletedUser, err := g.users.DeleteUser(r.Context(), &pb_user.DeleteUserRequest{ID: id, UserName: userName})
+	if err != nil {
+		writeUpstreamError(w, err, "user service", "failed to delete a user")
+		return
 	}
 
-	userService, err := grpc_clients.NewLocationServiceClient()
+	res := contracts.APIResponse{Data: deletedUser}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+// HandleErasureReport confirms what was erased of a deleted user. The userName is gone with the user,
+// so the report is looked up by the ID returned on delete.
+func (g *gateway) HandleErasureReport(w http.ResponseWriter, r *http.Request) {
+	id, _, _ := parseUserKey(r.PathValue("id"))
+	if id == "" {
+		writeValidationError(w, validationErrors{{
+			Field:   "id",
+			Code:    contracts.ErrCodeInvalidParameter,
+			Message: "id must be the 24 character hex ID of the user",
+		}})
+		return
+	}
 
+	report, err := g.locations.GetErasureReport(r.Context(), &pb_loction.ErasureReportRequest{UserId: id})
 	if err != nil {
-		log.Fatal(err)
+		writeUpstreamError(w, err, "location service", "failed to get the erasure report")
+		return
+	}
+
+	res := contracts.APIResponse{Data: report}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+// HandleExportHistory streams the history of the user as a file. The first chunk is awaited before
+// answering, so a rejected request still gets the error envelope, once the body has started a failure
+// can only be reported by cutting the response short.
+func (g *gateway) HandleExportHistory(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	id, userName, errs := parseUserKey(r.PathValue("id"))
+
+	formatName := optionalParam(q, "format", &errs)
+	if formatName == "" {
+		formatName = defaultExportFormat
+	}
+	format, ok := exportFormats[formatName]
+	if !ok {
+		errs.add(contracts.ErrCodeInvalidParameter, "format", "format must be one of "+exportFormatNames())
 	}
 
-	defer userService.Close()
+	startTimeParam := optionalParam(q, "startTime", &errs)
+	endTimeParam := optionalParam(q, "endTime", &errs)
 
-	distance, err := userService.Client.CalculateDistance(r.Context(), &pb_loction.CalculateDistanceRequest{
-		UserId:    userId[0],
+	if len(errs) > 0 {
+		writeValidationError(w, errs)
+		return
+	}
+
+	if id == "" {
+		user, err := g.users.GetUser(r.Context(), &pb_user.GetUserRequest{UserName: userName})
+		if err != nil {
+			writeUpstreamError(w, err, "user service", "failed to get a user")
+			return
+		}
+		id = user.GetUser().GetID()
+	}
+
+	stream, err := g.locations.ExportHistory(r.Context(), &pb_loction.ExportHistoryRequest{
+		UserId:    id,
 		StartDate: startTimeParam,
 		EndDate:   endTimeParam,
 	})
 	if err != nil {
-		log.Printf("Failed to calculate distance: %v", err)
-		http.Error(w, "Failed to calculate distance", http.StatusInternalServerError)
+		writeUpstreamError(w, err, "location service", "failed to export history")
 		return
+	}
 
+	chunk, err := stream.Recv()
+	if err != nil && err != io.EOF {
+		writeUpstreamError(w, err, "location service", "failed to export history")
+		return
 	}
 
-	res := contracts.APIResponse{Data: distance}
+	w.Header().Set("Content-Type", format.contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", exportFileName(id, format)))
+	w.WriteHeader(http.StatusOK)
 
-	writeJSON(w, http.StatusOK, res)
+	writer := format.newWriter(w)
+	controller := http.NewResponseController(w)
+	if err := writer.begin(); err != nil {
+		abortExport(id, err)
+	}
+
+	for err != io.EOF {
+		if err := writer.write(chunk.GetRecords()); err != nil {
+			abortExport(id, err)
+		}
+		// the chunk is pushed to the client right away instead of waiting for the buffer to fill up
+		if err := controller.Flush(); err != nil {
+			abortExport(id, err)
+		}
+
+		chunk, err = stream.Recv()
+		if err != nil && err != io.EOF {
+			abortExport(id, err)
+		}
+	}
+
+	if err := writer.end(); err != nil {
+		abortExport(id, err)
+	}
+}
+
+// abortExport drops the connection of an export that failed after the status was sent,
+// so the client does not mistake the truncated file for the whole history
+func abortExport(id string, err error) {
+	log.Printf("export of the history of %s failed: %v", id, err)
+	panic(http.ErrAbortHandler)
+}
+
+// optionalParam returns the value of a query parameter that can be left out, but not repeated
+func optionalParam(q url.Values, name string, errs *validationErrors) string {
+	values := q[name]
+	if len(values) > 1 {
+		errs.add(contracts.ErrCodeInvalidParameter, name, fmt.Sprintf("something wrong with %s param", name))
+	}
+	if len(values) == 1 {
+		return values[0]
+	}
+	return ""
+}
+
+// parseUserKey reads the user segment of the path. A userName is at most 16 characters long,
+// so a segment of 24 hex characters can only be an ID.
+func parseUserKey(value string) (string, string, validationErrors) {
+	var errs validationErrors
+
+	if len(value) == objectIDLength {
+		if _, err := hex.DecodeString(value); err == nil {
+			return value, "", nil
+		}
+	}
+
+	if err := util.ValidateUserName(value); err != nil {
+		errs.add(contracts.ErrCodeInvalidUsername, "userName", err.Error())
+	}
 
+	return "", value, errs
 }
@@ -2,14 +2,286 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"go-clinet-locations/services/api-gateway/grpc_clients"
+	"go-clinet-locations/shared/contracts"
+	pb_loction "go-clinet-locations/shared/proto/location"
+	pb_user "go-clinet-locations/shared/proto/user"
 	"go-clinet-locations/shared/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
+// newUnavailableGateway returns a gateway whose backends refuse every connection,
+// so a request passing the validation ends with 503
+func newUnavailableGateway(t *testing.T) *gateway {
+	userService, err := grpc_clients.NewUserServiceClient("127.0.0.1:1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(userService.Close)
+
+	locationService, err := grpc_clients.NewLocationServiceClient("127.0.0.1:1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(locationService.Close)
+
+	return newGateway(userService.Client, locationService.Client)
+}
+
+func TestGateway_BackendUnavailable(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+	}{
+		{name: "create user", method: "POST", target: "/user/create", body: `{"userName":"testuser123","coordinate":{"latitude":51.1,"longitude":17.0}}`},
+		{name: "search users", method: "GET", target: "/user/search?lat=51.1&lon=17.0"},
+		{name: "nearest users", method: "GET", target: "/user/nearest?lat=51.1&lon=17.0"},
+		{name: "calculate distance", method: "GET", target: "/user/distance?userId=abc"},
+		{name: "calculate distance by userName", method: "GET", target: "/user/distance?userName=testuser123"},
+		{name: "list users", method: "GET", target: "/users?limit=10"},
+	}
+
+	g := newUnavailableGateway(t)
+	handlers := map[string]http.HandlerFunc{
+		"/user/create":   g.HandleCreateUser,
+		"/user/search":   g.HandleSearchUser,
+		"/user/nearest":  g.HandleNearestUsers,
+		"/user/distance": g.HandleCalculateDistance,
+		"/users":         g.HandleListUsers,
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, bytes.NewBufferString(tt.body))
+			w := httptest.NewRecorder()
+
+			handlers[req.URL.Path](w, req)
+
+			if w.Code != http.StatusServiceUnavailable {
+				t.Errorf("expected status %d, got %d", http.StatusServiceUnavailable, w.Code)
+			}
+			if apiErr := decodeAPIError(t, w); apiErr.Code != contracts.ErrCodeUpstreamUnavailable {
+				t.Errorf("expected code %s, got %s", contracts.ErrCodeUpstreamUnavailable, apiErr.Code)
+			}
+		})
+	}
+}
+
+// decodeAPIError reads the error envelope of the response
+func decodeAPIError(t *testing.T, w *httptest.ResponseRecorder) *contracts.APIError {
+	t.Helper()
+
+	if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Fatalf("expected a JSON response, got %q", contentType)
+	}
+
+	var response contracts.APIResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if response.Error == nil {
+		t.Fatalf("expected an error in the response")
+	}
+
+	return response.Error
+}
+
+func TestGateway_ErrorEnvelope(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		target         string
+		body           string
+		expectedCode   string
+		expectedFields []string
+	}{
+		{
+			name:         "invalid JSON",
+			method:       "POST",
+			target:       "/user/create",
+			body:         "invalid json",
+			expectedCode: contracts.ErrCodeInvalidJSON,
+		},
+		{
+			name:           "every invalid field is reported",
+			method:         "POST",
+			target:         "/user/create",
+			body:           `{"userName":"ab","coordinate":{"latitude":0,"longitude":0},"recordedAt":"yesterday"}`,
+			expectedCode:   contracts.ErrCodeInvalidUsername,
+			expectedFields: []string{"userName", "coordinate", "recordedAt"},
+		},
+		{
+			name:           "missing userName on update",
+			method:         "PATCH",
+			target:         "/user/update",
+			body:           `{"coordinate":{"latitude":51.1,"longitude":17.0}}`,
+			expectedCode:   contracts.ErrCodeInvalidUsername,
+			expectedFields: []string{"userName"},
+		},
+		{
+			name:           "latitude out of range",
+			method:         "GET",
+			target:         "/user/search?lat=91&lon=17.0",
+			expectedCode:   contracts.ErrCodeInvalidCoordinates,
+			expectedFields: []string{"lat,lon"},
+		},
+		{
+			name:           "invalid radius and sort",
+			method:         "GET",
+			target:         "/user/search?lat=51.1&lon=17.0&r=far&sort=age",
+			expectedCode:   contracts.ErrCodeInvalidParameter,
+			expectedFields: []string{"r", "sort"},
+		},
+		{
+			name:         "bbox with three numbers",
+			method:       "POST",
+			target:       "/user/search/area",
+			body:         `{"bbox":[16.9,51.05,17.1]}`,
+			expectedCode: contracts.ErrCodeInvalidArea,
+		},
+		{
+			name:           "missing userId",
+			method:         "GET",
+			target:         "/user/distance",
+			expectedCode:   contracts.ErrCodeInvalidParameter,
+			expectedFields: []string{"userId,userName"},
+		},
+	}
+
+	g := newUnavailableGateway(t)
+	handlers := map[string]http.HandlerFunc{
+		"/user/create":      g.HandleCreateUser,
+		"/user/update":      g.HandleUpdateUser,
+		"/user/search":      g.HandleSearchUser,
+		"/user/search/area": g.HandleSearchUsersArea,
+		"/user/distance":    g.HandleCalculateDistance,
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, bytes.NewBufferString(tt.body))
+			w := httptest.NewRecorder()
+
+			handlers[req.URL.Path](w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected status %d, got %d", http.StatusBadRequest, w.Code)
+			}
+
+			apiErr := decodeAPIError(t, w)
+			if apiErr.Code != tt.expectedCode {
+				t.Errorf("expected code %s, got %s", tt.expectedCode, apiErr.Code)
+			}
+			if len(apiErr.Details) != len(tt.expectedFields) {
+				t.Fatalf("expected %d details, got %d", len(tt.expectedFields), len(apiErr.Details))
+			}
+			for i, field := range tt.expectedFields {
+				if apiErr.Details[i].Field != field {
+					t.Errorf("expected detail %d for %s, got %s", i, field, apiErr.Details[i].Field)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleUserByKey_Validation(t *testing.T) {
+	tests := []struct {
+		name           string
+		segment        string
+		expectedStatus int
+	}{
+		{name: "userName too short", segment: "ab", expectedStatus: http.StatusBadRequest},
+		{name: "userName with special characters", segment: "test@user", expectedStatus: http.StatusBadRequest},
+		{name: "valid userName", segment: "testuser123", expectedStatus: http.StatusServiceUnavailable},
+		{name: "ID", segment: "65f1c0ffee0123456789abcd", expectedStatus: http.StatusServiceUnavailable},
+	}
+
+	g := newUnavailableGateway(t)
+	handlers := map[string]http.HandlerFunc{
+		"GET":    g.HandleGetUser,
+		"DELETE": g.HandleDeleteUser,
+	}
+
+	for _, tt := range tests {
+		for method, handler := range handlers {
+			t.Run(method+" "+tt.name, func(t *testing.T) {
+				req := httptest.NewRequest(method, "/user/"+tt.segment, nil)
+				req.SetPathValue("userName", tt.segment)
+				w := httptest.NewRecorder()
+
+				handler(w, req)
+
+				if w.Code != tt.expectedStatus {
+					t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+				}
+			})
+		}
+	}
+}
+
+func TestHandleErasureReport_Validation(t *testing.T) {
+	tests := []struct {
+		name           string
+		id             string
+		expectedStatus int
+	}{
+		{name: "userName instead of ID", id: "testuser123", expectedStatus: http.StatusBadRequest},
+		{name: "ID", id: "65f1c0ffee0123456789abcd", expectedStatus: http.StatusServiceUnavailable},
+	}
+
+	g := newUnavailableGateway(t)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/user/"+tt.id+"/erasure", nil)
+			req.SetPathValue("id", tt.id)
+			w := httptest.NewRecorder()
+
+			g.HandleErasureReport(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestParseUserKey(t *testing.T) {
+	tests := []struct {
+		value            string
+		expectedID       string
+		expectedUserName string
+	}{
+		{value: "testuser123", expectedUserName: "testuser123"},
+		{value: "65f1c0ffee0123456789abcd", expectedID: "65f1c0ffee0123456789abcd"},
+		// 24 characters that are not hex can not be an ID nor a userName
+		{value: "notanobjectidnotanobject", expectedUserName: "notanobjectidnotanobject"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			id, userName, _ := parseUserKey(tt.value)
+
+			if id != tt.expectedID || userName != tt.expectedUserName {
+				t.Errorf("expected ID %q and userName %q, got %q and %q", tt.expectedID, tt.expectedUserName, id, userName)
+			}
+		})
+	}
+}
+
 func TestHandleCreateUser_Validation(t *testing.T) {
+	g := newUnavailableGateway(t)
+
 	tests := []struct {
 		name           string
 		requestBody    userLocationRequest
@@ -81,7 +353,7 @@ func TestHandleCreateUser_Validation(t *testing.T) {
 
 			// These tests focus on validation logic only
 			// They will fail at gRPC client creation, which is expected
-			HandleCreateUser(w, req)
+			g.HandleCreateUser(w, req)
 
 			// For validation tests, we expect them to fail at gRPC level
 			// but we can still test the validation logic
@@ -97,6 +369,8 @@ func TestHandleCreateUser_Validation(t *testing.T) {
 }
 
 func TestHandleUpdateUser_Validation(t *testing.T) {
+	g := newUnavailableGateway(t)
+
 	tests := []struct {
 		name           string
 		requestBody    userLocationRequest
@@ -139,7 +413,7 @@ func TestHandleUpdateUser_Validation(t *testing.T) {
 			w := httptest.NewRecorder()
 
 			// These tests focus on validation logic only
-			HandleUpdateUser(w, req)
+			g.HandleUpdateUser(w, req)
 
 			// For validation tests, we expect them to fail at gRPC level
 			// but we can still test the validation logic
@@ -154,7 +428,61 @@ func TestHandleUpdateUser_Validation(t *testing.T) {
 	}
 }
 
+func TestHandleUpdateUser_RecordedAt(t *testing.T) {
+	g := newUnavailableGateway(t)
+
+	tests := []struct {
+		name           string
+		requestBody    userLocationRequest
+		expectedStatus int
+	}{
+		{
+			name: "recordedAt in the future",
+			requestBody: userLocationRequest{
+				UserName: "testuser123",
+				Coordinate: types.Coordinate{
+					Latitude:  52.0,
+					Longitude: 17.0,
+				},
+				RecordedAt: time.Now().Add(time.Hour).Format(time.RFC3339),
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "recordedAt not RFC 3339",
+			requestBody: userLocationRequest{
+				UserName: "testuser123",
+				Coordinate: types.Coordinate{
+					Latitude:  52.0,
+					Longitude: 17.0,
+				},
+				RecordedAt: "10/05/2024 12:00",
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body bytes.Buffer
+			json.NewEncoder(&body).Encode(tt.requestBody)
+
+			req := httptest.NewRequest("PATCH", "/user/update", &body)
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			g.HandleUpdateUser(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
+
 func TestHandleSearchUser_Validation(t *testing.T) {
+	g := newUnavailableGateway(t)
+
 	tests := []struct {
 		name           string
 		queryParams    map[string]string
@@ -223,6 +551,36 @@ func TestHandleSearchUser_Validation(t *testing.T) {
 			expectedStatus: http.StatusBadRequest,
 			expectError:    true,
 		},
+		{
+			name: "invalid limit format",
+			queryParams: map[string]string{
+				"lat":   "51.11822470712269",
+				"lon":   "16.990711729269563",
+				"limit": "invalid",
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectError:    true,
+		},
+		{
+			name: "invalid sort",
+			queryParams: map[string]string{
+				"lat":  "51.11822470712269",
+				"lon":  "16.990711729269563",
+				"sort": "age",
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectError:    true,
+		},
+		{
+			name: "limit out of range",
+			queryParams: map[string]string{
+				"lat":   "51.11822470712269",
+				"lon":   "16.990711729269563",
+				"limit": "1000",
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectError:    true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -236,7 +594,7 @@ func TestHandleSearchUser_Validation(t *testing.T) {
 			w := httptest.NewRecorder()
 
 			// These tests focus on validation logic only
-			HandleSearchUser(w, req)
+			g.HandleSearchUser(w, req)
 
 			// For validation tests, we expect them to fail at gRPC level
 			// but we can still test the validation logic
@@ -251,7 +609,215 @@ func TestHandleSearchUser_Validation(t *testing.T) {
 	}
 }
 
+func TestHandleNearestUsers_Validation(t *testing.T) {
+	g := newUnavailableGateway(t)
+
+	tests := []struct {
+		name           string
+		queryParams    map[string]string
+		expectedStatus int
+	}{
+		{
+			name: "missing coordinates",
+			queryParams: map[string]string{
+				"k": "5",
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "invalid coordinates - latitude out of range",
+			queryParams: map[string]string{
+				"lat": "91.0",
+				"lon": "16.990711729269563",
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "invalid k format",
+			queryParams: map[string]string{
+				"lat": "51.11822470",
+				"lon": "16.99071172",
+				"k":   "many",
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "k out of range",
+			queryParams: map[string]string{
+				"lat": "51.11822470",
+				"lon": "16.99071172",
+				"k":   "0",
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/user/nearest", nil)
+			q := req.URL.Query()
+			for key, value := range tt.queryParams {
+				q.Add(key, value)
+			}
+			req.URL.RawQuery = q.Encode()
+			w := httptest.NewRecorder()
+
+			g.HandleNearestUsers(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestHandleSearchUsersArea_Validation(t *testing.T) {
+	g := newUnavailableGateway(t)
+
+	tests := []struct {
+		name           string
+		body           string
+		query          string
+		expectedStatus int
+	}{
+		{
+			name:           "invalid JSON",
+			body:           `{"type":`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "unsupported geometry",
+			body:           `{"type":"Point","coordinates":[17.03,51.11]}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "polygon with a hole",
+			body:           `{"type":"Polygon","coordinates":[[[17,51],[17.1,51],[17.1,51.1],[17,51]],[[17.01,51.01],[17.02,51.01],[17.02,51.02],[17.01,51.01]]]}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "polygon ring not closed",
+			body:           `{"type":"Polygon","coordinates":[[[17,51],[17.1,51],[17.1,51.1],[17,51.1]]]}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "polygon ring too short",
+			body:           `{"type":"Polygon","coordinates":[[[17,51],[17.1,51],[17,51]]]}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "polygon position out of range",
+			body:           `{"type":"Polygon","coordinates":[[[17,51],[17.1,91],[17.1,51.1],[17,51]]]}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "feature without polygon geometry",
+			body:           `{"type":"Feature","geometry":{"type":"LineString","coordinates":[[17,51],[17.1,51]]}}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "bbox with three numbers",
+			body:           `{"bbox":[16.9,51.05,17.1]}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "bbox with south above north",
+			body:           `{"bbox":[16.9,51.15,17.1,51.05]}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "invalid sort",
+			body:           `{"bbox":[16.9,51.05,17.1,51.15]}`,
+			query:          "sort=age",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/user/search/area?"+tt.query, bytes.NewBufferString(tt.body))
+			w := httptest.NewRecorder()
+
+			g.HandleSearchUsersArea(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestSearchAreaRequest_ToProto(t *testing.T) {
+	tests := []struct {
+		name             string
+		body             string
+		expectedVertices int
+		expectBBox       bool
+	}{
+		{
+			name:             "polygon",
+			body:             `{"type":"Polygon","coordinates":[[[17,51],[17.1,51],[17.1,51.1],[17,51]]]}`,
+			expectedVertices: 4,
+		},
+		{
+			name:             "feature with polygon geometry",
+			body:             `{"type":"Feature","properties":{"name":"Krzyki"},"geometry":{"type":"Polygon","coordinates":[[[17,51],[17.1,51],[17.1,51.1],[17,51.1],[17,51]]]}}`,
+			expectedVertices: 5,
+		},
+		{
+			name:       "bbox",
+			body:       `{"bbox":[16.9,51.05,17.1,51.15]}`,
+			expectBBox: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var area searchAreaRequest
+			if err := json.Unmarshal([]byte(tt.body), &area); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			proto, err := area.toProto(searchPage{limit: 10, sort: "userName"})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if proto.PageSize != 10 || proto.Sort != "userName" {
+				t.Errorf("expected page size 10 sorted by userName, got %d sorted by %s", proto.PageSize, proto.Sort)
+			}
+
+			if tt.expectBBox {
+				bbox := proto.GetBbox()
+				if bbox == nil {
+					t.Fatalf("expected a bbox area")
+				}
+				if bbox.SouthWest.Longitude != 16.9 || bbox.SouthWest.Latitude != 51.05 {
+					t.Errorf("expected south west 51.05,16.9, got %v,%v", bbox.SouthWest.Latitude, bbox.SouthWest.Longitude)
+				}
+				if bbox.NorthEast.Longitude != 17.1 || bbox.NorthEast.Latitude != 51.15 {
+					t.Errorf("expected north east 51.15,17.1, got %v,%v", bbox.NorthEast.Latitude, bbox.NorthEast.Longitude)
+				}
+				return
+			}
+
+			polygon := proto.GetPolygon()
+			if polygon == nil {
+				t.Fatalf("expected a polygon area")
+			}
+			if len(polygon.Vertices) != tt.expectedVertices {
+				t.Errorf("expected %d vertices, got %d", tt.expectedVertices, len(polygon.Vertices))
+			}
+			if polygon.Vertices[1].Longitude != 17.1 || polygon.Vertices[1].Latitude != 51 {
+				t.Errorf("expected the positions as [longitude, latitude], got %v,%v", polygon.Vertices[1].Latitude, polygon.Vertices[1].Longitude)
+			}
+		})
+	}
+}
+
 func TestHandleCalculateDistance_Validation(t *testing.T) {
+	g := newUnavailableGateway(t)
+
 	tests := []struct {
 		name           string
 		queryParams    map[string]string
@@ -275,6 +841,23 @@ func TestHandleCalculateDistance_Validation(t *testing.T) {
 			expectedStatus: http.StatusBadRequest,
 			expectError:    true,
 		},
+		{
+			name: "both userId and userName",
+			queryParams: map[string]string{
+				"userId":   "65f1c0ffee0123456789abcd",
+				"userName": "testuser123",
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectError:    true,
+		},
+		{
+			name: "invalid userName",
+			queryParams: map[string]string{
+				"userName": "ab",
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectError:    true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -288,7 +871,7 @@ func TestHandleCalculateDistance_Validation(t *testing.T) {
 			w := httptest.NewRecorder()
 
 			// These tests focus on validation logic only
-			HandleCalculateDistance(w, req)
+			g.HandleCalculateDistance(w, req)
 
 			// For validation tests, we expect them to fail at gRPC level
 			// but we can still test the validation logic
@@ -303,6 +886,63 @@ func TestHandleCalculateDistance_Validation(t *testing.T) {
 	}
 }
 
+// fakeUserService answers GetUser from a map of userName to ID, the other calls are not used
+type fakeUserService struct {
+	pb_user.UserServiceClient
+	ids map[string]string
+}
+
+func (f *fakeUserService) GetUser(ctx context.Context, req *pb_user.GetUserRequest, opts ...grpc.CallOption) (*pb_user.GetUserResponse, error) {
+	id, ok := f.ids[req.GetUserName()]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+	return &pb_user.GetUserResponse{User: &pb_user.User{ID: id, UserName: req.GetUserName()}}, nil
+}
+
+// fakeLocationService remembers the user of the last distance request
+type fakeLocationService struct {
+	pb_loction.LocationServiceClient
+	userId string
+}
+
+func (f *fakeLocationService) CalculateDistance(ctx context.Context, req *pb_loction.CalculateDistanceRequest, opts ...grpc.CallOption) (*pb_loction.CalculateDistanceResponse, error) {
+	f.userId = req.GetUserId()
+	return &pb_loction.CalculateDistanceResponse{}, nil
+}
+
+func TestHandleCalculateDistance_ResolvesUserName(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedUserId string
+	}{
+		{name: "by userName", query: "userName=testuser123", expectedStatus: http.StatusOK, expectedUserId: "65f1c0ffee0123456789abcd"},
+		{name: "by userId", query: "userId=65f1c0ffee0123456789abcd", expectedStatus: http.StatusOK, expectedUserId: "65f1c0ffee0123456789abcd"},
+		{name: "unknown userName", query: "userName=nobody", expectedStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			locations := &fakeLocationService{}
+			g := newGateway(&fakeUserService{ids: map[string]string{"testuser123": "65f1c0ffee0123456789abcd"}}, locations)
+
+			req := httptest.NewRequest("GET", "/user/distance?"+tt.query, nil)
+			w := httptest.NewRecorder()
+
+			g.HandleCalculateDistance(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if locations.userId != tt.expectedUserId {
+				t.Errorf("expected the distance of user %q, got %q", tt.expectedUserId, locations.userId)
+			}
+		})
+	}
+}
+
 func TestUserLocationRequest_ToProto(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -326,6 +966,17 @@ func TestUserLocationRequest_ToProto(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "request with recordedAt",
+			request: userLocationRequest{
+				UserName: "testuser",
+				Coordinate: types.Coordinate{
+					Latitude:  51.11822470712269,
+					Longitude: 16.990711729269563,
+				},
+				RecordedAt: "2024-05-10T11:55:00Z",
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -345,6 +996,10 @@ func TestUserLocationRequest_ToProto(t *testing.T) {
 				t.Errorf("expected longitude %.6f, got %.6f",
 					tt.request.Coordinate.Longitude, proto.Coordinate.Longitude)
 			}
+
+			if proto.RecordedAt != tt.request.RecordedAt {
+				t.Errorf("expected recordedAt %s, got %s", tt.request.RecordedAt, proto.RecordedAt)
+			}
 		})
 	}
 }
@@ -7,6 +7,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"go-clinet-locations/services/api-gateway/grpc_clients"
 	"go-clinet-locations/shared/contracts"
 	"go-clinet-locations/shared/types"
 	"net/http"
@@ -18,7 +19,26 @@ import (
 // Integration tests require the full system to be running
 // Run with: go test -tags=integration
 
+// newIntegrationGateway connects to the backends of the running system
+func newIntegrationGateway(t *testing.T) *gateway {
+	userService, err := grpc_clients.NewUserServiceClient(userServiceURL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(userService.Close)
+
+	locationService, err := grpc_clients.NewLocationServiceClient(locationServiceURL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(locationService.Close)
+
+	return newGateway(userService.Client, locationService.Client)
+}
+
 func TestIntegration_CreateUserFlow(t *testing.T) {
+	g := newIntegrationGateway(t)
+
 	// This test requires the full system to be running
 	// including user-service and location-history-service
 
@@ -49,7 +69,7 @@ func TestIntegration_CreateUserFlow(t *testing.T) {
 			req.Header.Set("Content-Type", "application/json")
 			w := httptest.NewRecorder()
 
-			HandleCreateUser(w, req)
+			g.HandleCreateUser(w, req)
 
 			if w.Code != tt.expectedStatus {
 				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
@@ -69,6 +89,8 @@ func TestIntegration_CreateUserFlow(t *testing.T) {
 }
 
 func TestIntegration_UpdateUserFlow(t *testing.T) {
+	g := newIntegrationGateway(t)
+
 	tests := []struct {
 		name           string
 		requestBody    userLocationRequest
@@ -96,7 +118,7 @@ func TestIntegration_UpdateUserFlow(t *testing.T) {
 			req.Header.Set("Content-Type", "application/json")
 			w := httptest.NewRecorder()
 
-			HandleUpdateUser(w, req)
+			g.HandleUpdateUser(w, req)
 
 			if w.Code != tt.expectedStatus {
 				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
@@ -106,6 +128,8 @@ func TestIntegration_UpdateUserFlow(t *testing.T) {
 }
 
 func TestIntegration_SearchUserFlow(t *testing.T) {
+	g := newIntegrationGateway(t)
+
 	tests := []struct {
 		name           string
 		queryParams    map[string]string
@@ -132,7 +156,7 @@ func TestIntegration_SearchUserFlow(t *testing.T) {
 			req.URL.RawQuery = q.Encode()
 			w := httptest.NewRecorder()
 
-			HandleSearchUser(w, req)
+			g.HandleSearchUser(w, req)
 
 			if w.Code != tt.expectedStatus {
 				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
@@ -149,6 +173,8 @@ func TestIntegration_SearchUserFlow(t *testing.T) {
 }
 
 func TestIntegration_CalculateDistanceFlow(t *testing.T) {
+	g := newIntegrationGateway(t)
+
 	tests := []struct {
 		name           string
 		queryParams    map[string]string
@@ -182,7 +208,7 @@ func TestIntegration_CalculateDistanceFlow(t *testing.T) {
 			req.URL.RawQuery = q.Encode()
 			w := httptest.NewRecorder()
 
-			HandleCalculateDistance(w, req)
+			g.HandleCalculateDistance(w, req)
 
 			if w.Code != tt.expectedStatus {
 				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
@@ -199,6 +225,8 @@ func TestIntegration_CalculateDistanceFlow(t *testing.T) {
 }
 
 func TestIntegration_EndToEndFlow(t *testing.T) {
+	g := newIntegrationGateway(t)
+
 	// This test performs a complete end-to-end flow:
 	// 1. Create a user
 	// 2. Update the user's location
@@ -227,7 +255,7 @@ func TestIntegration_EndToEndFlow(t *testing.T) {
 		req.Header.Set("Content-Type", "application/json")
 		w := httptest.NewRecorder()
 
-		HandleCreateUser(w, req)
+		g.HandleCreateUser(w, req)
 
 		if w.Code != http.StatusOK {
 			t.Errorf("create user failed with status %d", w.Code)
@@ -251,7 +279,7 @@ func TestIntegration_EndToEndFlow(t *testing.T) {
 		req.Header.Set("Content-Type", "application/json")
 		w := httptest.NewRecorder()
 
-		HandleUpdateUser(w, req)
+		g.HandleUpdateUser(w, req)
 
 		if w.Code != http.StatusOK {
 			t.Errorf("update user failed with status %d", w.Code)
@@ -268,7 +296,7 @@ func TestIntegration_EndToEndFlow(t *testing.T) {
 		req.URL.RawQuery = q.Encode()
 		w := httptest.NewRecorder()
 
-		HandleSearchUser(w, req)
+		g.HandleSearchUser(w, req)
 
 		if w.Code != http.StatusOK {
 			t.Errorf("search users failed with status %d", w.Code)
@@ -283,7 +311,7 @@ func TestIntegration_EndToEndFlow(t *testing.T) {
 		req.URL.RawQuery = q.Encode()
 		w := httptest.NewRecorder()
 
-		HandleCalculateDistance(w, req)
+		g.HandleCalculateDistance(w, req)
 
 		if w.Code != http.StatusOK {
 			t.Errorf("calculate distance failed with status %d", w.Code)
@@ -292,6 +320,8 @@ func TestIntegration_EndToEndFlow(t *testing.T) {
 }
 
 func TestIntegration_ConcurrentRequests(t *testing.T) {
+	g := newIntegrationGateway(t)
+
 	// Test concurrent requests to ensure thread safety
 	concurrency := 10
 	done := make(chan bool, concurrency)
@@ -313,7 +343,7 @@ func TestIntegration_ConcurrentRequests(t *testing.T) {
 			req.Header.Set("Content-Type", "application/json")
 			w := httptest.NewRecorder()
 
-			HandleCreateUser(w, req)
+			g.HandleCreateUser(w, req)
 
 			if w.Code != http.StatusOK {
 				t.Errorf("concurrent request %d failed with status %d", index, w.Code)
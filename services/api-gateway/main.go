@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"go-clinet-locations/services/api-gateway/grpc_clients"
 	"go-clinet-locations/shared/env"
 	"log"
 	"net/http"
@@ -14,17 +15,44 @@ import (
 
 var (
 	httpAddr = env.GetString("HTTP_ADDR", ":8004")
+	// recordedAtMaxSkew is how far in the future a client clock may be when sending recordedAt
+	recordedAtMaxSkew = env.GetDuration("RECORDED_AT_MAX_SKEW", time.Minute)
+	// the addresses are resolved through DNS and the calls are balanced over every returned address
+	userServiceURL     = env.GetString("USER_SERVICE_URL", "user-service:9093")
+	locationServiceURL = env.GetString("LOCATION_SERVICE_URL", "location-history-service:9092")
 )
 
 func main() {
 	fmt.Printf("Starting API Gateway ")
 
+	userService, err := grpc_clients.NewUserServiceClient(userServiceURL)
+	if err != nil {
+		log.Fatalf("Invalid user service address %q: %v", userServiceURL, err)
+	}
+	defer userService.Close()
+
+	locationService, err := grpc_clients.NewLocationServiceClient(locationServiceURL)
+	if err != nil {
+		log.Fatalf("Invalid location service address %q: %v", locationServiceURL, err)
+	}
+	defer locationService.Close()
+
+	g := newGateway(userService.Client, locationService.Client)
+
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("POST /user/create", enableCORS(HandleCreateUser))
-	mux.HandleFunc("PATCH /user/update", enableCORS(HandleUpdateUser))
-	mux.HandleFunc("GET /user/search", enableCORS(HandleSearchUser))
-	mux.HandleFunc("GET /user/distance", enableCORS(HandleCalculateDistance))
+	mux.HandleFunc("POST /user/create", enableCORS(g.HandleCreateUser))
+	mux.HandleFunc("PATCH /user/update", enableCORS(g.HandleUpdateUser))
+	mux.HandleFunc("GET /user/search", enableCORS(g.HandleSearchUser))
+	mux.HandleFunc("POST /user/search/area", enableCORS(g.HandleSearchUsersArea))
+	mux.HandleFunc("GET /user/nearest", enableCORS(g.HandleNearestUsers))
+	mux.HandleFunc("GET /user/distance", enableCORS(g.HandleCalculateDistance))
+	// the fixed routes above take precedence, a user named like one of them is reached by its ID
+	mux.HandleFunc("GET /user/{userName}", enableCORS(g.HandleGetUser))
+	mux.HandleFunc("DELETE /user/{userName}", enableCORS(g.HandleDeleteUser))
+	mux.HandleFunc("GET /users", enableCORS(g.HandleListUsers))
+	mux.HandleFunc("GET /user/{id}/erasure", enableCORS(g.HandleErasureReport))
+	mux.HandleFunc("GET /user/{id}/history/export", enableCORS(g.HandleExportHistory))
 
 	server := &http.Server{
 		Addr:    httpAddr,
@@ -1,13 +1,28 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"go-clinet-locations/shared/contracts"
 	pb "go-clinet-locations/shared/proto/user"
 	"go-clinet-locations/shared/types"
+	"go-clinet-locations/shared/util"
+	"time"
+)
+
+// maxSearchLimit and maxNearestUsers mirror the limits of the user service
+const (
+	maxSearchLimit  = 100
+	maxNearestUsers = 100
+	// objectIDLength is the length of the hex ID of a user
+	objectIDLength = 24
 )
 
 type userLocationRequest struct {
 	UserName   string           `json:"userName"`
 	Coordinate types.Coordinate `json:"coordinate"`
+	// RecordedAt is the optional RFC 3339 time the device took the fix
+	RecordedAt string `json:"recordedAt,omitempty"`
 }
 
 func (userLocation *userLocationRequest) toProto() *pb.UpdateUserRequest {
@@ -17,11 +32,132 @@ func (userLocation *userLocationRequest) toProto() *pb.UpdateUserRequest {
 			Latitude:  userLocation.Coordinate.Latitude,
 			Longitude: userLocation.Coordinate.Longitude,
 		},
+		RecordedAt: userLocation.RecordedAt,
 	}
 
 }
 
+// validateLocation checks the coordinate and the optional recordedAt shared by create and update
+func (userLocation *userLocationRequest) validateLocation(errs *validationErrors, now time.Time) {
+	if userLocation.Coordinate.Longitude == 0 || userLocation.Coordinate.Latitude == 0 {
+		errs.add(contracts.ErrCodeInvalidCoordinates, "coordinate", "invalid location data")
+	}
+
+	if userLocation.RecordedAt != "" {
+		if _, err := util.ParseRecordedAt(userLocation.RecordedAt, now, recordedAtMaxSkew); err != nil {
+			errs.add(contracts.ErrCodeInvalidRecordedAt, "recordedAt", err.Error())
+		}
+	}
+}
+
 type calculateDistanceRequest struct {
 	UserId    string `json:"userId"`
 	DateRange string `json:"dateRange"`
 }
+
+type searchPage struct {
+	limit  int
+	cursor string
+	sort   string
+}
+
+// searchAreaRequest is a GeoJSON Polygon, a Feature with a Polygon geometry
+// or a bare {"bbox": [west, south, east, north]} object. Positions are [longitude, latitude].
+type searchAreaRequest struct {
+	Type        string             `json:"type"`
+	Coordinates [][][]float64      `json:"coordinates,omitempty"`
+	Geometry    *searchAreaRequest `json:"geometry,omitempty"`
+	BBox        []float64          `json:"bbox,omitempty"`
+}
+
+func (area *searchAreaRequest) toProto(page searchPage) (*pb.SearchUsersRequest, error) {
+	req := &pb.SearchUsersRequest{
+		PageSize:  int32(page.limit),
+		PageToken: page.cursor,
+		Sort:      page.sort,
+	}
+
+	switch {
+	case area.Type == "Feature":
+		if area.Geometry == nil || area.Geometry.Type != "Polygon" {
+			return nil, errors.New("feature geometry must be a Polygon")
+		}
+		return area.Geometry.toProto(page)
+	case area.Type == "Polygon":
+		polygon, err := area.polygonToProto()
+		if err != nil {
+			return nil, err
+		}
+		req.Area = &pb.SearchUsersRequest_Polygon{Polygon: polygon}
+	case area.Type == "" && area.BBox != nil:
+		bbox, err := area.bboxToProto()
+		if err != nil {
+			return nil, err
+		}
+		req.Area = &pb.SearchUsersRequest_Bbox{Bbox: bbox}
+	default:
+		return nil, errors.New("area must be a GeoJSON Polygon, a Feature with a Polygon geometry or a bbox")
+	}
+
+	return req, nil
+}
+
+func (area *searchAreaRequest) polygonToProto() (*pb.Polygon, error) {
+	if len(area.Coordinates) != 1 {
+		return nil, errors.New("polygon must have exactly one ring, holes are not supported")
+	}
+
+	ring := area.Coordinates[0]
+	if len(ring) < 4 {
+		return nil, errors.New("polygon ring must have at least 4 positions")
+	}
+
+	vertices := make([]*pb.Coordinate, 0, len(ring))
+	for _, position := range ring {
+		coordinate, err := positionToProto(position)
+		if err != nil {
+			return nil, err
+		}
+		vertices = append(vertices, coordinate)
+	}
+
+	first, last := vertices[0], vertices[len(vertices)-1]
+	if first.Latitude != last.Latitude || first.Longitude != last.Longitude {
+		return nil, errors.New("polygon ring must be closed")
+	}
+
+	return &pb.Polygon{Vertices: vertices}, nil
+}
+
+func (area *searchAreaRequest) bboxToProto() (*pb.BoundingBox, error) {
+	if len(area.BBox) != 4 {
+		return nil, errors.New("bbox must be [west, south, east, north]")
+	}
+
+	southWest, err := positionToProto(area.BBox[0:2])
+	if err != nil {
+		return nil, err
+	}
+	northEast, err := positionToProto(area.BBox[2:4])
+	if err != nil {
+		return nil, err
+	}
+	if southWest.Latitude > northEast.Latitude {
+		return nil, errors.New("bbox south must not be greater than north")
+	}
+
+	return &pb.BoundingBox{SouthWest: southWest, NorthEast: northEast}, nil
+}
+
+// positionToProto converts a GeoJSON [longitude, latitude] position, an optional altitude is ignored
+func positionToProto(position []float64) (*pb.Coordinate, error) {
+	if len(position) < 2 || len(position) > 3 {
+		return nil, fmt.Errorf("invalid position %v", position)
+	}
+
+	if err := util.ValidateCords(position[1], position[0]); err != nil {
+		return nil, err
+	}
+
+	return &pb.Coordinate{Latitude: position[1], Longitude: position[0]}, nil
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"context"
+	"errors"
+	"go-clinet-locations/shared/db"
 	pb "go-clinet-locations/shared/proto/location"
+	"go.mongodb.org/mongo-driver/mongo"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -10,6 +13,9 @@ import (
 	"time"
 )
 
+// exportChunkSize is the number of fixes sent in one message of the export stream
+const exportChunkSize = 500
+
 type grpcHandler struct {
 	service LocationsService
 	pb.UnimplementedLocationServiceServer
@@ -44,22 +50,116 @@ func (h *grpcHandler) CalculateDistance(ctx context.Context, req *pb.CalculateDi
 		endDate = now.Format(isoLayout)
 	}
 
+	if req.GetUserId() == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "userId is required")
+	}
+
 	startDateParam, err := time.Parse(isoLayout, startDate)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to parse startDate: %v", err)
+		return nil, status.Errorf(codes.InvalidArgument, "startDate must be an RFC 3339 timestamp")
 	}
 
 	endDateParam, err := time.Parse(isoLayout, endDate)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to parse endDate: %v", err)
+		return nil, status.Errorf(codes.InvalidArgument, "endDate must be an RFC 3339 timestamp")
+	}
+	if endDateParam.Before(startDateParam) {
+		return nil, status.Errorf(codes.InvalidArgument, "endDate must not be before startDate")
 	}
 	log.Println(startDate, endDate)
 
 	distance, err := h.service.CalculateDistance(ctx, req.GetUserId(), startDateParam, endDateParam)
 	if err != nil {
-
-		return nil, status.Errorf(codes.Internal, "faild to calculate Distance: %v", err)
+		return nil, statusFromError(ctx, err, "failed to calculate distance")
 	}
 
 	return distance.ToProto(), nil
 }
+
+func (h *grpcHandler) GetErasureReport(ctx context.Context, req *pb.ErasureReportRequest) (*pb.ErasureReportResponse, error) {
+	if req.GetUserId() == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "userId is required")
+	}
+
+	erasure, err := h.service.ErasureReport(ctx, req.GetUserId())
+	if err != nil {
+		return nil, statusFromError(ctx, err, "failed to get erasure report")
+	}
+
+	return erasure.ToProto(), nil
+}
+
+// ExportHistory sends the fixes in chunks while they are read, so the history is never held in memory as a whole
+func (h *grpcHandler) ExportHistory(req *pb.ExportHistoryRequest, stream grpc.ServerStreamingServer[pb.ExportHistoryResponse]) error {
+	ctx := stream.Context()
+
+	if req.GetUserId() == "" {
+		return status.Errorf(codes.InvalidArgument, "userId is required")
+	}
+
+	// an empty bound leaves the range open on that side
+	startDate := time.Time{}
+	if req.GetStartDate() != "" {
+		var err error
+		if startDate, err = time.Parse(time.RFC3339, req.GetStartDate()); err != nil {
+			return status.Errorf(codes.InvalidArgument, "startDate must be an RFC 3339 timestamp")
+		}
+	}
+	endDate := time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC)
+	if req.GetEndDate() != "" {
+		var err error
+		if endDate, err = time.Parse(time.RFC3339, req.GetEndDate()); err != nil {
+			return status.Errorf(codes.InvalidArgument, "endDate must be an RFC 3339 timestamp")
+		}
+	}
+	if endDate.Before(startDate) {
+		return status.Errorf(codes.InvalidArgument, "endDate must not be before startDate")
+	}
+
+	var chunk []*pb.LocationRecord
+	send := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		err := stream.Send(&pb.ExportHistoryResponse{Records: chunk})
+		chunk = nil
+		return err
+	}
+
+	err := h.service.ExportHistory(ctx, req.GetUserId(), startDate, endDate, func(record *LocationRecord) error {
+		chunk = append(chunk, record.ToProto())
+		if len(chunk) < exportChunkSize {
+			return nil
+		}
+		return send()
+	})
+	if err == nil {
+		err = send()
+	}
+	if err != nil {
+		return statusFromError(ctx, err, "failed to export history")
+	}
+
+	return nil
+}
+
+// statusFromError returns the gRPC status of a failed call, anything but a bad argument,
+// an unknown user or an abandoned call is reported as internal
+func statusFromError(ctx context.Context, err error, message string) error {
+	switch {
+	case errors.Is(err, ErrInvalidUserID):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, ErrNoHistory):
+		return status.Error(codes.NotFound, "user not found")
+	case errors.Is(err, ErrNotErased):
+		return status.Error(codes.NotFound, err.Error())
+	case ctx.Err() != nil:
+		return status.FromContextError(ctx.Err()).Err()
+	case db.IsUnavailable(err):
+		return status.Errorf(codes.Unavailable, "%s: %v", message, err)
+	case mongo.IsTimeout(err):
+		return status.Errorf(codes.DeadlineExceeded, "%s: %v", message, err)
+	default:
+		return status.Errorf(codes.Internal, "%s: %v", message, err)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	pb "go-clinet-locations/shared/proto/location"
+	"go-clinet-locations/shared/types"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+func TestGrpcHandler_CalculateDistanceCodes(t *testing.T) {
+	tests := []struct {
+		name         string
+		request      *pb.CalculateDistanceRequest
+		expectedCode codes.Code
+	}{
+		{
+			name:         "default range",
+			request:      &pb.CalculateDistanceRequest{UserId: "user1"},
+			expectedCode: codes.OK,
+		},
+		{
+			name:         "unknown user",
+			request:      &pb.CalculateDistanceRequest{UserId: "nobody"},
+			expectedCode: codes.NotFound,
+		},
+		{
+			name:         "missing userId",
+			request:      &pb.CalculateDistanceRequest{},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "startDate not RFC 3339",
+			request:      &pb.CalculateDistanceRequest{UserId: "user1", StartDate: "yesterday"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "endDate not RFC 3339",
+			request:      &pb.CalculateDistanceRequest{UserId: "user1", StartDate: "2024-05-10T00:00:00Z", EndDate: "today"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "endDate before startDate",
+			request:      &pb.CalculateDistanceRequest{UserId: "user1", StartDate: "2024-05-10T00:00:00Z", EndDate: "2024-05-09T00:00:00Z"},
+			expectedCode: codes.InvalidArgument,
+		},
+	}
+
+	handler := &grpcHandler{service: NewService()}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := handler.CalculateDistance(context.Background(), tt.request)
+
+			if code := status.Code(err); code != tt.expectedCode {
+				t.Errorf("expected code %v, got %v (%v)", tt.expectedCode, code, err)
+			}
+		})
+	}
+}
+
+func TestGrpcHandler_GetErasureReport(t *testing.T) {
+	ctx := context.Background()
+	service := NewService()
+	if _, err := service.EraseUser(ctx, "user1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	handler := &grpcHandler{service: service}
+
+	report, err := handler.GetErasureReport(ctx, &pb.ErasureReportRequest{UserId: "user1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if report.RemovedLocations != 3 {
+		t.Errorf("expected 3 removed locations, got %d", report.RemovedLocations)
+	}
+
+	if _, err := handler.GetErasureReport(ctx, &pb.ErasureReportRequest{UserId: "user2"}); status.Code(err) != codes.NotFound {
+		t.Errorf("expected code %v for a user that was not erased, got %v", codes.NotFound, status.Code(err))
+	}
+}
+
+// fakeExportStream collects the messages sent by ExportHistory
+type fakeExportStream struct {
+	grpc.ServerStream
+	responses []*pb.ExportHistoryResponse
+}
+
+func (f *fakeExportStream) Context() context.Context {
+	return context.Background()
+}
+
+func (f *fakeExportStream) Send(response *pb.ExportHistoryResponse) error {
+	f.responses = append(f.responses, response)
+	return nil
+}
+
+func TestGrpcHandler_ExportHistory(t *testing.T) {
+	ctx := context.Background()
+	service := NewService()
+	start := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < exportChunkSize+10; i++ {
+		coordinate := &types.Coordinate{Latitude: 51.1, Longitude: 17.0 + float64(i)*0.0001}
+		if _, err := service.RegisterLocation(ctx, "mover", coordinate, start.Add(time.Duration(i)*time.Second)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	handler := &grpcHandler{service: service}
+
+	tests := []struct {
+		name            string
+		request         *pb.ExportHistoryRequest
+		expectedCode    codes.Code
+		expectedRecords int
+		expectedChunks  int
+	}{
+		{
+			name:            "whole history",
+			request:         &pb.ExportHistoryRequest{UserId: "mover"},
+			expectedRecords: exportChunkSize + 10,
+			expectedChunks:  2,
+		},
+		{
+			name:            "range",
+			request:         &pb.ExportHistoryRequest{UserId: "mover", StartDate: "2024-05-10T12:00:05Z", EndDate: "2024-05-10T12:00:15Z"},
+			expectedRecords: 10,
+			expectedChunks:  1,
+		},
+		{
+			name:         "unknown user",
+			request:      &pb.ExportHistoryRequest{UserId: "nobody"},
+			expectedCode: codes.NotFound,
+		},
+		{
+			name:         "startDate not RFC 3339",
+			request:      &pb.ExportHistoryRequest{UserId: "mover", StartDate: "yesterday"},
+			expectedCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := &fakeExportStream{}
+
+			err := handler.ExportHistory(tt.request, stream)
+
+			if code := status.Code(err); code != tt.expectedCode {
+				t.Fatalf("expected code %v, got %v (%v)", tt.expectedCode, code, err)
+			}
+			if len(stream.responses) != tt.expectedChunks {
+				t.Errorf("expected %d chunks, got %d", tt.expectedChunks, len(stream.responses))
+			}
+
+			var records []*pb.LocationRecord
+			for _, response := range stream.responses {
+				records = append(records, response.GetRecords()...)
+			}
+			if len(records) != tt.expectedRecords {
+				t.Fatalf("expected %d records, got %d", tt.expectedRecords, len(records))
+			}
+			for i := 1; i < len(records); i++ {
+				if records[i].Timestamp < records[i-1].Timestamp {
+					t.Errorf("expected the records oldest first, got %s after %s", records[i].Timestamp, records[i-1].Timestamp)
+				}
+			}
+			if len(records) > 0 {
+				if _, err := time.Parse(time.RFC3339, records[0].Timestamp); err != nil {
+					t.Errorf("expected an RFC 3339 timestamp, got %s", records[0].Timestamp)
+				}
+			}
+		})
+	}
+}
+
+func TestStatusFromError(t *testing.T) {
+	expired, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name         string
+		ctx          context.Context
+		err          error
+		expectedCode codes.Code
+	}{
+		{name: "invalid user id", err: fmt.Errorf("%w: bad hex", ErrInvalidUserID), expectedCode: codes.InvalidArgument},
+		{name: "no history", err: fmt.Errorf("%w: user1", ErrNoHistory), expectedCode: codes.NotFound},
+		{
+			name:         "mongo unreachable",
+			err:          fmt.Errorf("failed to register location: %w", topology.ServerSelectionError{Wrapped: topology.ErrServerSelectionTimeout}),
+			expectedCode: codes.Unavailable,
+		},
+		{
+			name:         "connection dropped",
+			err:          fmt.Errorf("failed to retrieve user history: %w", mongo.CommandError{Labels: []string{"NetworkError"}}),
+			expectedCode: codes.Unavailable,
+		},
+		{
+			name:         "query timed out",
+			err:          fmt.Errorf("failed to retrieve user history: %w", mongo.CommandError{Code: 50, Name: "MaxTimeMSExpired"}),
+			expectedCode: codes.DeadlineExceeded,
+		},
+		{name: "client gone", ctx: expired, err: errors.New("operation interrupted"), expectedCode: codes.Canceled},
+		{name: "unexpected", err: errors.New("boom"), expectedCode: codes.Internal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := tt.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			if code := status.Code(statusFromError(ctx, tt.err, "failed")); code != tt.expectedCode {
+				t.Errorf("expected code %v, got %v", tt.expectedCode, code)
+			}
+		})
+	}
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"errors"
+	pb "go-clinet-locations/shared/proto/location"
 	"go-clinet-locations/shared/types"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"time"
@@ -9,11 +11,79 @@ import (
 
 type LocationRecord struct {
 	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	UserID     primitive.ObjectID `bson:"userId,omitempty"`
 	Coordinate *types.Coordinate  `bson:"coordinate"`
 	Timestamp  time.Time          `bson:"timestamp"`
 }
 
+var (
+	// ErrInvalidUserID is returned when the user id is not a valid object id
+	ErrInvalidUserID = errors.New("invalid user ID format")
+	// ErrNoHistory is returned when no location of the user was ever registered, so the user is unknown
+	ErrNoHistory = errors.New("no location history for the user")
+	// ErrUserErased is returned when a location of a user whose history was erased arrives late
+	ErrUserErased = errors.New("history of the user was erased")
+	// ErrNotErased is returned when the report of a user that was not erased is requested
+	ErrNotErased = errors.New("history of the user was not erased")
+	// ErrEventInProgress is returned when another worker claimed the event and has not finished it yet
+	ErrEventInProgress = errors.New("event is being processed")
+)
+
+// eventClaimLease is how long a claimed event is left to its worker. It is far longer than applying an event takes
+// and shorter than the retries of a message, so the claim of a crashed worker is taken over before the message is parked.
+const eventClaimLease = 10 * time.Second
+
+// ErasureRecord is the tombstone left when the history of a deleted user is erased
+type ErasureRecord struct {
+	UserID   string    `bson:"_id"`
+	ErasedAt time.Time `bson:"erasedAt"`
+	// RemovedLocations counts the stored fixes, DroppedLocations the fixes that arrived afterwards.
+	// The tombstone keeps no fix, a redelivered fix is counted once because its event is applied once, see ProcessedEvents.
+	RemovedLocations int64 `bson:"removedLocations"`
+	DroppedLocations int64 `bson:"droppedLocations"`
+}
+
 type LocationsService interface {
-	RegisterLocation(ctx context.Context, userId string, coords *types.Coordinate, timestamp time.Time) ([]*LocationRecord, error)
+	RegisterLocation(ctx context.Context, userId string, coords *types.Coordinate, timestamp time.Time) (*LocationRecord, error)
 	CalculateDistance(ctx context.Context, userId string, startDate time.Time, endDate time.Time) (*DistanceRecord, error)
+	// ExportHistory calls fn with every fix taken from startDate inclusive to endDate exclusive, oldest first,
+	// without loading the whole history at once. It returns ErrNoHistory for a user without any fix.
+	ExportHistory(ctx context.Context, userId string, startDate time.Time, endDate time.Time, fn func(*LocationRecord) error) error
+	// EraseUser removes the history of the user and leaves a tombstone, so RegisterLocation drops
+	// the locations still queued for the user with ErrUserErased. Running it again is harmless.
+	EraseUser(ctx context.Context, userId string) (*ErasureRecord, error)
+	// ErasureReport returns the tombstone of the user or ErrNotErased
+	ErasureReport(ctx context.Context, userId string) (*ErasureRecord, error)
+}
+
+// ProcessedEvents claims every event before it is applied, so an event that is redelivered
+// or handed to two workers at once is applied only once
+type ProcessedEvents interface {
+	// ClaimEvent returns true when the caller is to apply the event, false when it was applied already
+	// and ErrEventInProgress while another worker holds it. A claim older than eventClaimLease is taken over.
+	ClaimEvent(ctx context.Context, eventID string) (bool, error)
+	// MarkProcessed finishes the claim of an applied event
+	MarkProcessed(ctx context.Context, eventID string) error
+	// ReleaseEvent drops the claim of an event that failed, so its retry can claim it again
+	ReleaseEvent(ctx context.Context, eventID string) error
+}
+
+func (e *ErasureRecord) ToProto() *pb.ErasureReportResponse {
+	return &pb.ErasureReportResponse{
+		UserId:           e.UserID,
+		ErasedAt:         e.ErasedAt.Format(time.RFC3339),
+		RemovedLocations: e.RemovedLocations,
+		DroppedLocations: e.DroppedLocations,
+	}
+}
+
+// ToProto returns the record with an RFC 3339 timestamp, as exported
+func (r *LocationRecord) ToProto() *pb.LocationRecord {
+	return &pb.LocationRecord{
+		Coordinate: &pb.Coordinate{
+			Latitude:  r.Coordinate.Latitude,
+			Longitude: r.Coordinate.Longitude,
+		},
+		Timestamp: r.Timestamp.UTC().Format(time.RFC3339Nano),
+	}
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"go-clinet-locations/shared/db"
 	"go-clinet-locations/shared/types"
@@ -11,127 +12,405 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"log"
-	"sync"
 	"time"
 )
 
+const (
+	// migrationBatchSize is the number of fixes inserted at once when a legacy history is migrated
+	migrationBatchSize = 1000
+	duplicateKeyCode   = 11000
+	// processedEventTTL is how long a processed event id is remembered, far longer than a message stays in the retry queue
+	processedEventTTL = 7 * 24 * time.Hour
+)
+
+// mongoService stores every location fix as its own document in the location_fixes collection.
+// A time-series collection was not used because it cannot enforce the unique index that drops duplicates.
 type mongoService struct {
 	db *mongo.Database
-	mu sync.RWMutex
 }
 
 func NewMongoService(db *mongo.Database) *mongoService {
 	return &mongoService{db: db}
 }
 
-func (m *mongoService) RegisterLocation(ctx context.Context, userId string, coords *types.Coordinate, timestamp time.Time) ([]*LocationRecord, error) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+// EnsureIndexes creates the unique (userId, timestamp, coordinate) index. Its (userId, timestamp) prefix
+// serves the range queries, the coordinate makes a redelivered fix fail to insert.
+func (m *mongoService) EnsureIndexes(ctx context.Context) error {
+	_, err := m.db.Collection(db.LocationFixCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "userId", Value: 1},
+			{Key: "timestamp", Value: 1},
+			{Key: "coordinate.latitude", Value: 1},
+			{Key: "coordinate.longitude", Value: 1},
+		},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create location fix index: %w", err)
+	}
+
+	_, err = m.db.Collection(db.ProcessedEventCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "processedAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(processedEventTTL.Seconds())),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create processed event index: %w", err)
+	}
 
-	collection := m.db.Collection(db.LocationCollection)
+	return nil
+}
 
+func (m *mongoService) RegisterLocation(ctx context.Context, userId string, coords *types.Coordinate, timestamp time.Time) (*LocationRecord, error) {
 	// Convert the string userId to a primitive.ObjectID
 	objID, err := primitive.ObjectIDFromHex(userId)
 	if err != nil {
-		return nil, fmt.Errorf("invalid user ID format: %v", err)
+		return nil, fmt.Errorf("%w: %v", ErrInvalidUserID, err)
 	}
 
 	locationRecord := &LocationRecord{
+		UserID:     objID,
 		Coordinate: coords,
 		Timestamp:  timestamp,
 	}
 
-	filter := bson.M{"_id": objID} // Use the converted ObjectID
-	update := bson.M{"$push": bson.M{"history": locationRecord}}
+	result, err := m.db.Collection(db.LocationFixCollection).InsertOne(ctx, locationRecord)
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return nil, fmt.Errorf("failed to register location: %w", err)
+	}
+	// the unique index rejects a fix that is already stored, e.g. a redelivered message
+	duplicate := err != nil
+
+	// EraseUser writes the tombstone before it removes the fixes. Reading the tombstone after the insert,
+	// a fix either finds it and removes itself or is stored before the fixes are removed.
+	erased, err := m.isErased(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if erased {
+		return nil, m.dropLocation(ctx, locationRecord)
+	}
+
+	if duplicate {
+		log.Printf("duplicate location of user %s at %v dropped", userId, timestamp)
+		return locationRecord, nil
+	}
+	locationRecord.ID = result.InsertedID.(primitive.ObjectID)
+
+	return locationRecord, nil
+}
+
+func (m *mongoService) CalculateDistance(ctx context.Context, userId string, startDate time.Time, endDate time.Time) (*DistanceRecord, error) {
+	collection := m.db.Collection(db.LocationFixCollection)
 
-	result, err := collection.UpdateOne(ctx, filter, update)
+	objID, err := primitive.ObjectIDFromHex(userId)
 	if err != nil {
-		return nil, fmt.Errorf("failed to register location: %v", err)
+		return nil, fmt.Errorf("%w: %v", ErrInvalidUserID, err)
 	}
 
-	// Check if a document was actually updated
-	if result.ModifiedCount == 0 {
-		log.Println("User not found, attempting to insert new document.")
+	// only the fixes in the date range are read, ordered by time so consecutive fixes form the path
+	filter := bson.M{
+		"userId":    objID,
+		"timestamp": bson.M{"$gt": startDate, "$lt": endDate},
+	}
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "timestamp", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve user history: %w", err)
+	}
+	defer cursor.Close(ctx)
 
-		// If no document was updated, it means the user doesn't exist.
-		// In this case, we'll create a new document.
-		newDoc := bson.M{
-			"_id":     objID,
-			"history": []*LocationRecord{locationRecord},
+	var history []*LocationRecord
+	var totalDistance float64
+	for cursor.Next(ctx) {
+		var record LocationRecord
+		if err := cursor.Decode(&record); err != nil {
+			return nil, fmt.Errorf("failed to decode location record: %w", err)
 		}
 
-		_, err := collection.InsertOne(ctx, newDoc)
-		if err != nil {
-			return nil, fmt.Errorf("failed to insert new user document: %v", err)
+		if len(history) > 0 {
+			totalDistance += util.CalculateDistance(history[len(history)-1].Coordinate, record.Coordinate)
 		}
+		history = append(history, &record)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("failed to retrieve user history: %w", err)
 	}
 
-	// Retrieve updated history
-	var user struct {
-		History []*LocationRecord `bson:"history"`
+	// nothing in the range is a zero distance, unless the user has no fixes at all
+	if len(history) == 0 {
+		if err := m.ensureHistory(ctx, objID); err != nil {
+			return nil, err
+		}
 	}
-	err = collection.FindOne(ctx, filter).Decode(&user)
+
+	return &DistanceRecord{
+		distance: totalDistance,
+		history:  history,
+	}, nil
+}
+
+func (m *mongoService) isErased(ctx context.Context, userId string) (bool, error) {
+	count, err := m.db.Collection(db.ErasureCollection).CountDocuments(ctx, bson.M{"_id": userId}, options.Count().SetLimit(1))
 	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve updated history: %v", err)
+		return false, fmt.Errorf("failed to check erasure: %w", err)
 	}
 
-	return user.History, nil
+	return count > 0, nil
 }
 
-func (m *mongoService) CalculateDistance(ctx context.Context, userId string, startDate time.Time, endDate time.Time) (*DistanceRecord, error) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+// dropLocation removes a fix stored after the erasure and returns ErrUserErased.
+// The fix is counted on the tombstone unless the erasure removed it first.
+func (m *mongoService) dropLocation(ctx context.Context, record *LocationRecord) error {
+	result, err := m.db.Collection(db.LocationFixCollection).DeleteOne(ctx, bson.M{
+		"userId":               record.UserID,
+		"timestamp":            record.Timestamp,
+		"coordinate.latitude":  record.Coordinate.Latitude,
+		"coordinate.longitude": record.Coordinate.Longitude,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to drop location: %w", err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrUserErased
+	}
 
-	collection := m.db.Collection(db.LocationCollection)
+	_, err = m.db.Collection(db.ErasureCollection).UpdateOne(ctx,
+		bson.M{"_id": record.UserID.Hex()},
+		bson.M{"$inc": bson.M{"droppedLocations": 1}})
+	if err != nil {
+		return fmt.Errorf("failed to count dropped location: %w", err)
+	}
+
+	return ErrUserErased
+}
 
-	// 1. Convert the string userId to a primitive.ObjectID
+// EraseUser writes the tombstone before removing the fixes, so a fix registered meanwhile is either removed here
+// or finds the tombstone in RegisterLocation and removes itself.
+// The fixes of the legacy history array are removed as well.
+func (m *mongoService) EraseUser(ctx context.Context, userId string) (*ErasureRecord, error) {
 	objID, err := primitive.ObjectIDFromHex(userId)
 	if err != nil {
-		return nil, fmt.Errorf("invalid user ID format: %v", err)
+		return nil, fmt.Errorf("%w: %v", ErrInvalidUserID, err)
 	}
 
-	// 2. Define the filter to retrieve the user's history
-	// We only need the history field, so we use a projection.
-	filter := bson.M{"_id": objID}
-	projection := bson.M{"_id": 0, "history": 1}
+	erasures := m.db.Collection(db.ErasureCollection)
+	_, err = erasures.UpdateOne(ctx, bson.M{"_id": userId}, bson.M{"$setOnInsert": bson.M{
+		"erasedAt":         time.Now(),
+		"removedLocations": 0,
+		"droppedLocations": 0,
+	}}, options.Update().SetUpsert(true))
+	if err != nil {
+		return nil, fmt.Errorf("failed to store erasure: %w", err)
+	}
 
-	var userDoc struct {
+	result, err := m.db.Collection(db.LocationFixCollection).DeleteMany(ctx, bson.M{"userId": objID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to erase location fixes: %w", err)
+	}
+	removed := result.DeletedCount
+
+	var legacy struct {
 		History []*LocationRecord `bson:"history"`
 	}
+	err = m.db.Collection(db.LocationCollection).FindOneAndDelete(ctx, bson.M{"_id": objID}).Decode(&legacy)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("failed to erase legacy history: %w", err)
+	}
+	removed += int64(len(legacy.History))
+
+	if _, err := erasures.UpdateOne(ctx, bson.M{"_id": userId}, bson.M{"$inc": bson.M{"removedLocations": removed}}); err != nil {
+		return nil, fmt.Errorf("failed to store erasure: %w", err)
+	}
+
+	return m.ErasureReport(ctx, userId)
+}
+
+func (m *mongoService) ErasureReport(ctx context.Context, userId string) (*ErasureRecord, error) {
+	var erasure ErasureRecord
+	err := m.db.Collection(db.ErasureCollection).FindOne(ctx, bson.M{"_id": userId}).Decode(&erasure)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotErased
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get erasure: %w", err)
+	}
 
-	err = collection.FindOne(ctx, filter, options.FindOne().SetProjection(projection)).Decode(&userDoc)
+	return &erasure, nil
+}
+
+func (m *mongoService) ExportHistory(ctx context.Context, userId string, startDate time.Time, endDate time.Time, fn func(*LocationRecord) error) error {
+	objID, err := primitive.ObjectIDFromHex(userId)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return &DistanceRecord{distance: 0.0, history: nil}, nil
-		}
-		return nil, fmt.Errorf("failed to retrieve user history: %v", err)
+		return fmt.Errorf("%w: %v", ErrInvalidUserID, err)
 	}
 
-	// 3. Filter the retrieved history in-memory to match the date range
-	var filteredHistory []*LocationRecord
-	for _, record := range userDoc.History {
-		if record.Timestamp.After(startDate) && record.Timestamp.Before(endDate) {
-			filteredHistory = append(filteredHistory, record)
+	filter := bson.M{
+		"userId":    objID,
+		"timestamp": bson.M{"$gte": startDate, "$lt": endDate},
+	}
+	cursor, err := m.db.Collection(db.LocationFixCollection).Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "timestamp", Value: 1}}))
+	if err != nil {
+		return fmt.Errorf("failed to retrieve user history: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	// the cursor fetches the fixes in batches, so only one batch is held in memory
+	var exported int
+	for cursor.Next(ctx) {
+		var record LocationRecord
+		if err := cursor.Decode(&record); err != nil {
+			return fmt.Errorf("failed to decode location record: %w", err)
 		}
+		if err := fn(&record); err != nil {
+			return err
+		}
+		exported++
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("failed to retrieve user history: %w", err)
 	}
 
-	// Handle case where no records are in the time range
-	if len(filteredHistory) < 2 {
-		return &DistanceRecord{distance: 0.0, history: filteredHistory}, nil
+	if exported == 0 {
+		return m.ensureHistory(ctx, objID)
 	}
+	return nil
+}
 
-	// 4. Calculate the distance between consecutive points in the filtered history
-	var totalDistance float64
-	for i := 0; i < len(filteredHistory)-1; i++ {
-		currentRecord := filteredHistory[i]
-		nextRecord := filteredHistory[i+1]
+// ensureHistory returns ErrNoHistory when the user has no fix at all
+func (m *mongoService) ensureHistory(ctx context.Context, userID primitive.ObjectID) error {
+	err := m.db.Collection(db.LocationFixCollection).FindOne(ctx, bson.M{"userId": userID}).Err()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return fmt.Errorf("%w: %v", ErrNoHistory, userID.Hex())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to retrieve user history: %w", err)
+	}
+	return nil
+}
 
-		// This is where you would use your util.CalculateDistance function
-		totalDistance += util.CalculateDistance(currentRecord.Coordinate, nextRecord.Coordinate)
+// ClaimEvent inserts the event id, the unique _id lets only one worker claim it.
+// The ids stored before claims existed have no done field and count as applied.
+// The TTL index removes every id after processedEventTTL.
+func (m *mongoService) ClaimEvent(ctx context.Context, eventID string) (bool, error) {
+	collection := m.db.Collection(db.ProcessedEventCollection)
+	now := time.Now()
+
+	_, err := collection.InsertOne(ctx, bson.M{"_id": eventID, "processedAt": now, "done": false})
+	if err == nil {
+		return true, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return false, fmt.Errorf("failed to claim event: %w", err)
 	}
 
-	return &DistanceRecord{
-		distance: totalDistance,
-		history:  filteredHistory,
-	}, nil
+	// the worker holding the claim crashed or lost its connection before finishing or releasing it
+	result, err := collection.UpdateOne(ctx,
+		bson.M{"_id": eventID, "done": false, "processedAt": bson.M{"$lt": now.Add(-eventClaimLease)}},
+		bson.M{"$set": bson.M{"processedAt": now}},
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to take over event claim: %w", err)
+	}
+	if result.ModifiedCount == 1 {
+		return true, nil
+	}
+
+	err = collection.FindOne(ctx, bson.M{"_id": eventID, "done": bson.M{"$ne": false}}).Err()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// held by another worker, or released since the insert failed
+		return false, ErrEventInProgress
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check processed event: %w", err)
+	}
+
+	return false, nil
+}
+
+func (m *mongoService) MarkProcessed(ctx context.Context, eventID string) error {
+	_, err := m.db.Collection(db.ProcessedEventCollection).UpdateOne(ctx,
+		bson.M{"_id": eventID},
+		bson.M{"$set": bson.M{"processedAt": time.Now(), "done": true}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to mark event as processed: %w", err)
+	}
+
+	return nil
+}
+
+func (m *mongoService) ReleaseEvent(ctx context.Context, eventID string) error {
+	_, err := m.db.Collection(db.ProcessedEventCollection).DeleteOne(ctx, bson.M{"_id": eventID, "done": false})
+	if err != nil {
+		return fmt.Errorf("failed to release event claim: %w", err)
+	}
+
+	return nil
+}
+
+// MigrateHistory moves the fixes of the legacy history arrays into the location_fixes collection
+// and removes every migrated document. It can be run again after a failure, fixes already moved are skipped.
+func (m *mongoService) MigrateHistory(ctx context.Context) (int64, error) {
+	legacy := m.db.Collection(db.LocationCollection)
+
+	cursor, err := legacy.Find(ctx, bson.M{"history": bson.M{"$exists": true}})
+	if err != nil {
+		return 0, fmt.Errorf("failed to read legacy history: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var migrated int64
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID      primitive.ObjectID `bson:"_id"`
+			History []*LocationRecord  `bson:"history"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return migrated, fmt.Errorf("failed to decode legacy history: %w", err)
+		}
+
+		for start := 0; start < len(doc.History); start += migrationBatchSize {
+			end := min(start+migrationBatchSize, len(doc.History))
+
+			fixes := make([]interface{}, 0, end-start)
+			for _, record := range doc.History[start:end] {
+				fixes = append(fixes, &LocationRecord{
+					UserID:     doc.ID,
+					Coordinate: record.Coordinate,
+					Timestamp:  record.Timestamp,
+				})
+			}
+
+			if err := m.insertFixes(ctx, fixes); err != nil {
+				return migrated, fmt.Errorf("failed to migrate history of user %s: %w", doc.ID.Hex(), err)
+			}
+		}
+
+		if _, err := legacy.DeleteOne(ctx, bson.M{"_id": doc.ID}); err != nil {
+			return migrated, fmt.Errorf("failed to remove legacy history of user %s: %w", doc.ID.Hex(), err)
+		}
+		migrated++
+	}
+	if err := cursor.Err(); err != nil {
+		return migrated, fmt.Errorf("failed to read legacy history: %w", err)
+	}
+
+	return migrated, nil
+}
+
+// insertFixes inserts the fixes ignoring the ones that are already stored
+func (m *mongoService) insertFixes(ctx context.Context, fixes []interface{}) error {
+	_, err := m.db.Collection(db.LocationFixCollection).InsertMany(ctx, fixes, options.InsertMany().SetOrdered(false))
+
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) && bulkErr.WriteConcernError == nil {
+		for _, writeErr := range bulkErr.WriteErrors {
+			if writeErr.Code != duplicateKeyCode {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return err
 }
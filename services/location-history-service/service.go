@@ -7,13 +7,17 @@ import (
 	"go-clinet-locations/shared/types"
 	"go-clinet-locations/shared/util"
 	"log"
+	"sort"
 	"sync"
 	"time"
 )
 
 type Service struct {
 	history map[string][]*LocationRecord
-	mu      sync.RWMutex
+	// claims holds the ids of the claimed and applied events, the applied ones are never forgotten
+	claims   map[string]*eventClaim
+	erasures map[string]*ErasureRecord
+	mu       sync.RWMutex
 }
 
 type DistanceRecord struct {
@@ -24,6 +28,8 @@ type DistanceRecord struct {
 func NewService() *Service {
 	now := time.Now()
 	return &Service{
+		claims:   make(map[string]*eventClaim),
+		erasures: make(map[string]*ErasureRecord),
 		history: map[string][]*LocationRecord{
 			"user1": []*LocationRecord{
 				{
@@ -52,40 +58,55 @@ func NewService() *Service {
 	}
 }
 
-func (s *Service) RegisterLocation(ctx context.Context, userId string, coords *types.Coordinate, timestamp time.Time) ([]*LocationRecord, error) {
+func (s *Service) RegisterLocation(ctx context.Context, userId string, coords *types.Coordinate, timestamp time.Time) (*LocationRecord, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	log.Println("Registering location...")
 
-	user, ok := s.history[userId]
-	if !ok {
-		s.history[userId] = []*LocationRecord{
-			{
-				Coordinate: coords,
-				Timestamp:  timestamp,
-			},
-		}
-		return s.history[userId], nil
+	if erasure, ok := s.erasures[userId]; ok {
+		erasure.DroppedLocations++
+		return nil, ErrUserErased
 	}
 
-	user = append(user, &LocationRecord{
+	record := &LocationRecord{
 		Coordinate: coords,
 		Timestamp:  timestamp,
-	},
-	)
-	s.history[userId] = user
+	}
+
+	// the history is kept ordered by time, late arrivals are inserted after the records taken at the same time or earlier
+	history := s.history[userId]
+	i := sort.Search(len(history), func(i int) bool {
+		return history[i].Timestamp.After(timestamp)
+	})
 
-	return user, nil
+	// a redelivered message must not add the same fix twice
+	for j := i - 1; j >= 0 && history[j].Timestamp.Equal(timestamp); j-- {
+		if *history[j].Coordinate == *coords {
+			log.Printf("duplicate location of user %s at %v dropped", userId, timestamp)
+			return history[j], nil
+		}
+	}
+
+	history = append(history, nil)
+	copy(history[i+1:], history[i:])
+	history[i] = record
+	s.history[userId] = history
+
+	return record, nil
 }
 
 func (s *Service) CalculateDistance(ctx context.Context, userId string, startDate time.Time, endDate time.Time) (*DistanceRecord, error) {
+	// the records are copied, RegisterLocation inserts into the history of the user in place
+	s.mu.RLock()
 	user, ok := s.history[userId]
+	user = append([]*LocationRecord(nil), user...)
+	s.mu.RUnlock()
 	if !ok {
 		return &DistanceRecord{
 			distance: 0.0,
 			history:  nil,
-		}, fmt.Errorf("there is no user with such id: %v", userId)
+		}, fmt.Errorf("%w: %v", ErrNoHistory, userId)
 	}
 
 	var totalDistance float64
@@ -126,6 +147,98 @@ func (s *Service) CalculateDistance(ctx context.Context, userId string, startDat
 	}, nil
 }
 
+func (s *Service) ExportHistory(ctx context.Context, userId string, startDate time.Time, endDate time.Time, fn func(*LocationRecord) error) error {
+	// the records are copied, so fn runs without holding the lock
+	s.mu.RLock()
+	history, ok := s.history[userId]
+	history = append([]*LocationRecord(nil), history...)
+	s.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: %v", ErrNoHistory, userId)
+	}
+
+	for _, record := range history {
+		if record.Timestamp.Before(startDate) || !record.Timestamp.Before(endDate) {
+			continue
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Service) EraseUser(ctx context.Context, userId string) (*ErasureRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	erasure, ok := s.erasures[userId]
+	if !ok {
+		erasure = &ErasureRecord{UserID: userId, ErasedAt: time.Now()}
+		s.erasures[userId] = erasure
+	}
+	erasure.RemovedLocations += int64(len(s.history[userId]))
+	delete(s.history, userId)
+
+	copied := *erasure
+	return &copied, nil
+}
+
+func (s *Service) ErasureReport(ctx context.Context, userId string) (*ErasureRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	erasure, ok := s.erasures[userId]
+	if !ok {
+		return nil, ErrNotErased
+	}
+
+	copied := *erasure
+	return &copied, nil
+}
+
+type eventClaim struct {
+	claimedAt time.Time
+	done      bool
+}
+
+func (s *Service) ClaimEvent(ctx context.Context, eventID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	claim, ok := s.claims[eventID]
+	switch {
+	case !ok:
+		s.claims[eventID] = &eventClaim{claimedAt: time.Now()}
+		return true, nil
+	case claim.done:
+		return false, nil
+	case time.Since(claim.claimedAt) < eventClaimLease:
+		return false, ErrEventInProgress
+	default:
+		claim.claimedAt = time.Now()
+		return true, nil
+	}
+}
+
+func (s *Service) MarkProcessed(ctx context.Context, eventID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.claims[eventID] = &eventClaim{claimedAt: time.Now(), done: true}
+	return nil
+}
+
+func (s *Service) ReleaseEvent(ctx context.Context, eventID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if claim, ok := s.claims[eventID]; ok && !claim.done {
+		delete(s.claims, eventID)
+	}
+	return nil
+}
+
 func (d *DistanceRecord) ToProto() *pb.CalculateDistanceResponse {
 	var protoLocationRecords []*pb.LocationRecord
 	for _, u := range d.history {
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"go-clinet-locations/shared/types"
 	"testing"
 	"time"
@@ -63,24 +64,103 @@ func TestService_RegisterLocation(t *testing.T) {
 					t.Errorf("unexpected error: %v", err)
 				}
 				if result == nil {
-					t.Errorf("expected location records but got nil")
+					t.Fatalf("expected location record but got nil")
 				}
-				if len(result) == 0 {
-					t.Errorf("expected at least one location record")
+				// Check if the record matches what we registered
+				if result.Coordinate.Latitude != tt.coords.Latitude {
+					t.Errorf("expected latitude %.6f, got %.6f", tt.coords.Latitude, result.Coordinate.Latitude)
 				}
-				// Check if the last record matches what we registered
-				lastRecord := result[len(result)-1]
-				if lastRecord.Coordinate.Latitude != tt.coords.Latitude {
-					t.Errorf("expected latitude %.6f, got %.6f", tt.coords.Latitude, lastRecord.Coordinate.Latitude)
-				}
-				if lastRecord.Coordinate.Longitude != tt.coords.Longitude {
-					t.Errorf("expected longitude %.6f, got %.6f", tt.coords.Longitude, lastRecord.Coordinate.Longitude)
+				if result.Coordinate.Longitude != tt.coords.Longitude {
+					t.Errorf("expected longitude %.6f, got %.6f", tt.coords.Longitude, result.Coordinate.Longitude)
 				}
 			}
 		})
 	}
 }
 
+func TestService_RegisterLocation_OutOfOrder(t *testing.T) {
+	service := NewService()
+	ctx := context.Background()
+	start := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+
+	// the second fix arrives last, e.g. after a queue backlog
+	fixes := []struct {
+		coords    *types.Coordinate
+		timestamp time.Time
+	}{
+		{coords: &types.Coordinate{Latitude: 51.10, Longitude: 17.00}, timestamp: start},
+		{coords: &types.Coordinate{Latitude: 51.12, Longitude: 17.00}, timestamp: start.Add(2 * time.Minute)},
+		{coords: &types.Coordinate{Latitude: 51.11, Longitude: 17.00}, timestamp: start.Add(time.Minute)},
+	}
+
+	for _, fix := range fixes {
+		if _, err := service.RegisterLocation(ctx, "late", fix.coords, fix.timestamp); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	result := service.history["late"]
+	if len(result) != 3 {
+		t.Fatalf("expected 3 location records, got %d", len(result))
+	}
+	expectedLatitudes := []float64{51.10, 51.11, 51.12}
+	for i, record := range result {
+		if record.Coordinate.Latitude != expectedLatitudes[i] {
+			t.Errorf("expected latitude %.2f at position %d, got %.2f", expectedLatitudes[i], i, record.Coordinate.Latitude)
+		}
+	}
+}
+
+func TestService_RegisterLocation_Duplicate(t *testing.T) {
+	service := NewService()
+	ctx := context.Background()
+	timestamp := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		coords        *types.Coordinate
+		timestamp     time.Time
+		expectedCount int
+	}{
+		{
+			name:          "first delivery",
+			coords:        &types.Coordinate{Latitude: 51.1, Longitude: 17.0},
+			timestamp:     timestamp,
+			expectedCount: 1,
+		},
+		{
+			name:          "redelivery is dropped",
+			coords:        &types.Coordinate{Latitude: 51.1, Longitude: 17.0},
+			timestamp:     timestamp,
+			expectedCount: 1,
+		},
+		{
+			name:          "same time at another place is kept",
+			coords:        &types.Coordinate{Latitude: 51.2, Longitude: 17.0},
+			timestamp:     timestamp,
+			expectedCount: 2,
+		},
+		{
+			name:          "same place at another time is kept",
+			coords:        &types.Coordinate{Latitude: 51.1, Longitude: 17.0},
+			timestamp:     timestamp.Add(time.Second),
+			expectedCount: 3,
+		},
+	}
+
+	// the cases run in order against the same history
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := service.RegisterLocation(ctx, "redelivered", tt.coords, tt.timestamp); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if count := len(service.history["redelivered"]); count != tt.expectedCount {
+				t.Errorf("expected %d location records, got %d", tt.expectedCount, count)
+			}
+		})
+	}
+}
+
 func TestService_CalculateDistance(t *testing.T) {
 	now := time.Now()
 
@@ -182,6 +262,10 @@ func TestService_ConcurrentAccess(t *testing.T) {
 			if err != nil {
 				t.Errorf("unexpected error in concurrent access: %v", err)
 			}
+			// reading while the others register
+			if _, err := service.CalculateDistance(ctx, "concurrent_user", time.Now().Add(-time.Hour), time.Now()); err != nil {
+				t.Errorf("unexpected error in concurrent access: %v", err)
+			}
 			done <- true
 		}(i)
 	}
@@ -202,6 +286,74 @@ func TestService_ConcurrentAccess(t *testing.T) {
 	}
 }
 
+func TestService_ClaimEvent(t *testing.T) {
+	ctx := context.Background()
+	service := NewService()
+
+	claimed, err := service.ClaimEvent(ctx, "event")
+	if err != nil || !claimed {
+		t.Fatalf("expected the first claim to succeed, got %v, %v", claimed, err)
+	}
+	if _, err := service.ClaimEvent(ctx, "event"); !errors.Is(err, ErrEventInProgress) {
+		t.Errorf("expected ErrEventInProgress while the claim is held, got %v", err)
+	}
+
+	// the worker holding the claim crashed
+	service.claims["event"].claimedAt = time.Now().Add(-eventClaimLease)
+	if claimed, err := service.ClaimEvent(ctx, "event"); err != nil || !claimed {
+		t.Errorf("expected an expired claim to be taken over, got %v, %v", claimed, err)
+	}
+
+	if err := service.ReleaseEvent(ctx, "event"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if claimed, err := service.ClaimEvent(ctx, "event"); err != nil || !claimed {
+		t.Errorf("expected a released event to be claimed again, got %v, %v", claimed, err)
+	}
+
+	if err := service.MarkProcessed(ctx, "event"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := service.ReleaseEvent(ctx, "event"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if claimed, err := service.ClaimEvent(ctx, "event"); err != nil || claimed {
+		t.Errorf("expected a processed event not to be claimed, got %v, %v", claimed, err)
+	}
+}
+
+func TestService_EraseUser_DroppedLocations(t *testing.T) {
+	ctx := context.Background()
+	service := NewService()
+	timestamp := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+
+	if _, err := service.RegisterLocation(ctx, "leaver", &types.Coordinate{Latitude: 51.1, Longitude: 17.0}, timestamp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := service.EraseUser(ctx, "leaver"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	late := []struct {
+		latitude float64
+		offset   time.Duration
+	}{{51.2, time.Minute}, {51.3, 2 * time.Minute}}
+	for _, fix := range late {
+		_, err := service.RegisterLocation(ctx, "leaver", &types.Coordinate{Latitude: fix.latitude, Longitude: 17.0}, timestamp.Add(fix.offset))
+		if !errors.Is(err, ErrUserErased) {
+			t.Errorf("expected %v, got %v", ErrUserErased, err)
+		}
+	}
+
+	report, err := service.ErasureReport(ctx, "leaver")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if report.RemovedLocations != 1 || report.DroppedLocations != 2 {
+		t.Errorf("expected 1 removed and 2 dropped locations, got %d and %d", report.RemovedLocations, report.DroppedLocations)
+	}
+}
+
 func TestDistanceRecord_ToProto(t *testing.T) {
 	now := time.Now()
 
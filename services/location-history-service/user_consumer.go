@@ -2,55 +2,127 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"go-clinet-locations/shared/contracts"
+	"errors"
 	"go-clinet-locations/shared/messaging"
+	"go-clinet-locations/shared/proto/events"
 	"go-clinet-locations/shared/types"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"log"
-	"time"
 
 	"github.com/rabbitmq/amqp091-go"
 )
 
 type userConsumer struct {
-	rabbitmq *messaging.RabbitMQ
-	service  LocationsService
+	consumer  messaging.Consumer
+	service   LocationsService
+	processed ProcessedEvents
 }
 
-func NewUserConsumer(rabbitmq *messaging.RabbitMQ, service LocationsService) *userConsumer {
+func NewUserConsumer(consumer messaging.Consumer, service LocationsService, processed ProcessedEvents) *userConsumer {
 	return &userConsumer{
-		rabbitmq: rabbitmq,
-		service:  service,
+		consumer:  consumer,
+		service:   service,
+		processed: processed,
 	}
 }
 
-func (c *userConsumer) Listen() error {
-	return c.rabbitmq.ConsumeMessages(messaging.SaveUserLocationQueue, func(ctx context.Context, msg amqp091.Delivery) error {
-		var userEvent contracts.AmqpMessage
+// Listen handles the events of different users in parallel, the events of one user in the order they were sent
+func (c *userConsumer) Listen(ctx context.Context, workers, prefetchCount int) error {
+	return c.consumer.ConsumeMessages(ctx, messaging.SaveUserLocationQueue, c.handleMessage, messaging.ConsumerOptions{
+		PrefetchCount: prefetchCount,
+		Workers:       workers,
+		OrderByOwner:  true,
+	})
+}
 
-		if err := json.Unmarshal(msg.Body, &userEvent); err != nil {
-			return err
-		}
+// handleMessage applies every event once. The event is claimed before it is applied, so two workers never apply it
+// at the same time. When the claim is not finished, e.g. after a crash, the event is applied again once the claim
+// is taken over, which the location store drops as a duplicate and the erasure tolerates.
+func (c *userConsumer) handleMessage(ctx context.Context, msg amqp091.Delivery) error {
+	envelope, err := messaging.DecodeMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	var apply func(context.Context, *events.EventEnvelope) error
+	switch envelope.GetEventType() {
+	case messaging.EventType(&events.UserLocationRegistered{}):
+		apply = c.registerLocation
+	case messaging.EventType(&events.UserDeleted{}):
+		apply = c.eraseUser
+	default:
+		// an event type added later is not meant for this consumer, it is acknowledged so it does not end up in the dead letter queue
+		log.Printf("event %s of type %s skipped", envelope.GetEventId(), envelope.GetEventType())
+		return nil
+	}
 
-		var payload types.UserLocation
+	claimed, err := c.processed.ClaimEvent(ctx, envelope.GetEventId())
+	if err != nil {
+		// ErrEventInProgress retries the message until the other worker finished or its claim expired
+		return err
+	}
+	if !claimed {
+		log.Printf("event %s already processed, skipped", envelope.GetEventId())
+		return nil
+	}
 
-		if err := json.Unmarshal(userEvent.Data, &payload); err != nil {
-			return err
+	if err := apply(ctx, envelope); err != nil {
+		if releaseErr := c.processed.ReleaseEvent(ctx, envelope.GetEventId()); releaseErr != nil {
+			// the claim expires after eventClaimLease instead
+			log.Printf("ERROR: Failed to release event %s: %v", envelope.GetEventId(), releaseErr)
 		}
+		return err
+	}
 
-		log.Printf("user data received: %+v", payload)
+	return c.processed.MarkProcessed(ctx, envelope.GetEventId())
+}
 
-		now := time.Now()
+func (c *userConsumer) registerLocation(ctx context.Context, envelope *events.EventEnvelope) error {
+	var payload events.UserLocationRegistered
+	if err := messaging.UnpackEvent(envelope, &payload); err != nil {
+		return err
+	}
 
-		locationRecords, err := c.service.RegisterLocation(ctx, payload.UserId, payload.Coordinate, now)
+	log.Printf("user data received: %s", payload.String())
 
-		if err != nil {
-			return status.Errorf(codes.Internal, "failed to Register Location %v", err)
-		}
-		log.Printf("%+v", locationRecords)
+	// prefer the time the device took the fix, a queue backlog would distort the history otherwise.
+	// Without it the time the event was published is used, so an event applied again stores the same fix.
+	timestamp := envelope.GetOccurredAt().AsTime()
+	if payload.GetRecordedAt() != nil {
+		timestamp = payload.GetRecordedAt().AsTime()
+	}
+
+	coordinate := &types.Coordinate{
+		Latitude:  payload.GetCoordinate().GetLatitude(),
+		Longitude: payload.GetCoordinate().GetLongitude(),
+	}
+	locationRecord, err := c.service.RegisterLocation(ctx, payload.GetUserId(), coordinate, timestamp)
 
+	// the location was queued before the user was deleted, it must not be stored
+	if errors.Is(err, ErrUserErased) {
+		log.Printf("location of erased user %s dropped", payload.GetUserId())
 		return nil
-	})
+	}
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to Register Location %v", err)
+	}
+	log.Printf("%+v", locationRecord)
+
+	return nil
+}
+
+func (c *userConsumer) eraseUser(ctx context.Context, envelope *events.EventEnvelope) error {
+	var payload events.UserDeleted
+	if err := messaging.UnpackEvent(envelope, &payload); err != nil {
+		return err
+	}
+
+	erasure, err := c.service.EraseUser(ctx, payload.GetUserId())
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to erase user %s: %v", payload.GetUserId(), err)
+	}
+	log.Printf("history of user %s erased, %d locations removed", erasure.UserID, erasure.RemovedLocations)
+
+	return nil
 }
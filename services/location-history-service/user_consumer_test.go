@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"go-clinet-locations/shared/contracts"
+	"go-clinet-locations/shared/messaging"
+	"go-clinet-locations/shared/proto/events"
+	"go-clinet-locations/shared/types"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"testing"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+func newLocationDelivery(t *testing.T, userId string, latitude, longitude float64) amqp091.Delivery {
+	envelope, err := messaging.NewEnvelope("user-service", userId, &events.UserLocationRegistered{
+		UserId:     userId,
+		Coordinate: &events.Coordinate{Latitude: latitude, Longitude: longitude},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	body, err := messaging.EncodeEnvelope(envelope)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return amqp091.Delivery{Body: body}
+}
+
+func TestUserConsumer_Redelivery(t *testing.T) {
+	ctx := context.Background()
+	service := NewService()
+	consumer := NewUserConsumer(nil, service, service)
+
+	// two events of the same place, only the event id tells a redelivery from a new one
+	first := newLocationDelivery(t, "mover", 51.1, 17.0)
+	second := newLocationDelivery(t, "mover", 51.2, 17.0)
+
+	for _, msg := range []amqp091.Delivery{first, second, first, second} {
+		if err := consumer.handleMessage(ctx, msg); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	history := service.history["mover"]
+	if len(history) != 2 {
+		t.Fatalf("expected 2 locations, got %d", len(history))
+	}
+	if history[0].Coordinate.Latitude != 51.1 || history[1].Coordinate.Latitude != 51.2 {
+		t.Errorf("expected the locations of both events in order, got %.1f and %.1f", history[0].Coordinate.Latitude, history[1].Coordinate.Latitude)
+	}
+}
+
+func TestUserConsumer_ReappliedEvent(t *testing.T) {
+	ctx := context.Background()
+	service := NewService()
+	consumer := NewUserConsumer(nil, service, service)
+
+	msg := newLocationDelivery(t, "mover", 51.1, 17.0)
+	if err := consumer.handleMessage(ctx, msg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the claim was lost, e.g. the worker crashed before finishing it, so the event is applied again
+	service.claims = make(map[string]*eventClaim)
+	time.Sleep(time.Millisecond)
+	if err := consumer.handleMessage(ctx, msg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(service.history["mover"]) != 1 {
+		t.Errorf("expected the fix stored once, got %d locations", len(service.history["mover"]))
+	}
+}
+
+// failingLocations fails RegisterLocation the given number of times before passing it on
+type failingLocations struct {
+	LocationsService
+	failures int
+}
+
+func (f *failingLocations) RegisterLocation(ctx context.Context, userId string, coords *types.Coordinate, timestamp time.Time) (*LocationRecord, error) {
+	if f.failures > 0 {
+		f.failures--
+		return nil, errors.New("connection reset")
+	}
+	return f.LocationsService.RegisterLocation(ctx, userId, coords, timestamp)
+}
+
+func TestUserConsumer_FailedEventReleased(t *testing.T) {
+	ctx := context.Background()
+	service := NewService()
+	consumer := NewUserConsumer(nil, &failingLocations{LocationsService: service, failures: 1}, service)
+
+	msg := newLocationDelivery(t, "mover", 51.1, 17.0)
+	if err := consumer.handleMessage(ctx, msg); err == nil {
+		t.Fatalf("expected the first attempt to fail")
+	}
+	// the retry arrives long before the claim would expire
+	if err := consumer.handleMessage(ctx, msg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(service.history["mover"]) != 1 {
+		t.Errorf("expected the retry to store the fix, got %d locations", len(service.history["mover"]))
+	}
+}
+
+func TestUserConsumer_LegacyMessage(t *testing.T) {
+	ctx := context.Background()
+	service := NewService()
+	consumer := NewUserConsumer(nil, service, service)
+
+	// the JSON published before the event envelope, the data is base64 encoded by encoding/json
+	body := []byte(`{"ownerId":"mover","data":"eyJ1c2VySWQiOiJtb3ZlciIsImNvb3JkaW5hdGUiOnsibGF0aXR1ZGUiOjUxLjEsImxvbmdpdHVkZSI6MTcuMDN9fQ=="}`)
+	legacy := amqp091.Delivery{ContentType: messaging.LegacyContentType, Body: body}
+
+	for _, msg := range []amqp091.Delivery{legacy, legacy} {
+		if err := consumer.handleMessage(ctx, msg); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	history := service.history["mover"]
+	if len(history) != 1 {
+		t.Fatalf("expected the legacy location stored once, got %d locations", len(history))
+	}
+	if history[0].Coordinate.Latitude != 51.1 || history[0].Coordinate.Longitude != 17.03 {
+		t.Errorf("expected 51.1,17.03, got %v,%v", history[0].Coordinate.Latitude, history[0].Coordinate.Longitude)
+	}
+}
+
+func TestUserConsumer_SkipsUnknownEventType(t *testing.T) {
+	service := NewService()
+	consumer := NewUserConsumer(nil, service, service)
+
+	envelope, err := messaging.NewEnvelope("user-service", "mover", &events.UserCreated{UserId: "mover", UserName: "mover"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	body, _ := messaging.EncodeEnvelope(envelope)
+
+	if err := consumer.handleMessage(context.Background(), amqp091.Delivery{Body: body}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if len(service.history["mover"]) != 0 {
+		t.Errorf("expected no locations, got %d", len(service.history["mover"]))
+	}
+}
+
+func TestUserConsumer_EraseUser(t *testing.T) {
+	ctx := context.Background()
+	service := NewService()
+	consumer := NewUserConsumer(nil, service, service)
+
+	for _, msg := range []amqp091.Delivery{
+		newLocationDelivery(t, "leaver", 51.1, 17.0),
+		newLocationDelivery(t, "leaver", 51.2, 17.0),
+		newLocationDelivery(t, "stayer", 51.3, 17.0),
+	} {
+		if err := consumer.handleMessage(ctx, msg); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	envelope, err := messaging.NewEnvelope("user-service", "leaver", &events.UserDeleted{UserId: "leaver", UserName: "leaver"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	body, _ := messaging.EncodeEnvelope(envelope)
+	deleted := amqp091.Delivery{Body: body}
+
+	// the redelivered deletion and a redelivered location queued before the deletion arrive afterwards
+	late := newLocationDelivery(t, "leaver", 51.4, 17.0)
+	for _, msg := range []amqp091.Delivery{deleted, deleted, late, late} {
+		if err := consumer.handleMessage(ctx, msg); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if len(service.history["leaver"]) != 0 {
+		t.Errorf("expected the history to be erased, got %d locations", len(service.history["leaver"]))
+	}
+	if len(service.history["stayer"]) != 1 {
+		t.Errorf("expected the history of other users to stay, got %d locations", len(service.history["stayer"]))
+	}
+
+	report, err := service.ErasureReport(ctx, "leaver")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if report.RemovedLocations != 2 || report.DroppedLocations != 1 {
+		t.Errorf("expected 2 removed and 1 dropped location, got %d and %d", report.RemovedLocations, report.DroppedLocations)
+	}
+	if _, err := service.ErasureReport(ctx, "stayer"); !errors.Is(err, ErrNotErased) {
+		t.Errorf("expected %v, got %v", ErrNotErased, err)
+	}
+}
+
+// TestUserConsumer_Listen consumes the events the user service publishes through the in-memory broker
+func TestUserConsumer_Listen(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := messaging.NewInmemBroker()
+	service := NewService()
+	if err := NewUserConsumer(broker, service, service).Listen(ctx, 4, 16); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	start := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	users := []string{"alice", "bob", "carol"}
+	for i := 0; i < 10; i++ {
+		for _, userId := range users {
+			envelope, err := messaging.NewEnvelope("user-service", userId, &events.UserLocationRegistered{
+				UserId:     userId,
+				Coordinate: &events.Coordinate{Latitude: 51.1 + float64(i)*0.01, Longitude: 17.0},
+				RecordedAt: timestamppb.New(start.Add(time.Duration(i) * time.Minute)),
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := broker.PublishMessage(ctx, contracts.UserEventLocationRegistered, envelope); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+	}
+	broker.WaitIdle()
+
+	for _, userId := range users {
+		distance, err := service.CalculateDistance(ctx, userId, start.Add(-time.Minute), start.Add(time.Hour))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(distance.history) != 10 {
+			t.Errorf("expected 10 locations of %s, got %d", userId, len(distance.history))
+		}
+		if distance.distance == 0 {
+			t.Errorf("expected the distance of %s to be set", userId)
+		}
+	}
+	if deadLetters := broker.DeadLetters(messaging.SaveUserLocationQueue); len(deadLetters) != 0 {
+		t.Errorf("expected no dead letters, got %d", len(deadLetters))
+	}
+}
+
+// TestUserConsumer_FromOutbox follows the events of the user service from its outbox through the relay
+// and the in-memory broker to the history
+func TestUserConsumer_FromOutbox(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := messaging.NewInmemBroker()
+	service := NewService()
+	if err := NewUserConsumer(broker, service, service).Listen(ctx, 4, 16); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	outbox := messaging.NewInmemOutbox()
+	write := func(routingKey, userId string, event proto.Message) *messaging.OutboxEvent {
+		envelope, err := messaging.NewEnvelope("user-service", userId, event)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		outboxEvent, err := messaging.NewOutboxEvent(routingKey, envelope)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		outbox.Add(outboxEvent)
+		return outboxEvent
+	}
+
+	start := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	var written []*messaging.OutboxEvent
+	for i := 0; i < 3; i++ {
+		written = append(written, write(contracts.UserEventLocationRegistered, "mover", &events.UserLocationRegistered{
+			UserId:     "mover",
+			Coordinate: &events.Coordinate{Latitude: 51.1 + float64(i)*0.01, Longitude: 17.0},
+			RecordedAt: timestamppb.New(start.Add(time.Duration(i) * time.Minute)),
+		}))
+	}
+	write(contracts.UserEventLocationRegistered, "leaver", &events.UserLocationRegistered{
+		UserId:     "leaver",
+		Coordinate: &events.Coordinate{Latitude: 51.1, Longitude: 17.0},
+		RecordedAt: timestamppb.New(start),
+	})
+	write(contracts.UserEventDeleted, "leaver", &events.UserDeleted{UserId: "leaver", UserName: "leaver"})
+
+	relay := messaging.NewOutboxRelay(outbox, broker)
+	if delivered, err := relay.RelayPending(ctx); err != nil || delivered != 5 {
+		t.Fatalf("expected 5 delivered events, got %d and error %v", delivered, err)
+	}
+	broker.WaitIdle()
+
+	// the relay stopped between the publish and the delete, the event is published again
+	copied := *written[1]
+	outbox.Add(&copied)
+	if _, err := relay.RelayPending(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	broker.WaitIdle()
+
+	if pending := outbox.Events(); len(pending) != 0 {
+		t.Errorf("expected the outbox to be empty, got %d events", len(pending))
+	}
+
+	distance, err := service.CalculateDistance(ctx, "mover", start.Add(-time.Minute), start.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(distance.history) != 3 {
+		t.Fatalf("expected 3 locations of mover, got %d", len(distance.history))
+	}
+	for i, record := range distance.history {
+		if expected := start.Add(time.Duration(i) * time.Minute); !record.Timestamp.Equal(expected) {
+			t.Errorf("expected location %d at %v, got %v", i, expected, record.Timestamp)
+		}
+	}
+
+	erasure, err := service.ErasureReport(ctx, "leaver")
+	if err != nil {
+		t.Fatalf("expected leaver to be erased, got %v", err)
+	}
+	if erasure.RemovedLocations != 1 {
+		t.Errorf("expected 1 removed location of leaver, got %d", erasure.RemovedLocations)
+	}
+	if deadLetters := broker.DeadLetters(messaging.SaveUserLocationQueue); len(deadLetters) != 0 {
+		t.Errorf("expected no dead letters, got %d", len(deadLetters))
+	}
+}
@@ -2,7 +2,7 @@ package main
 
 import (
 	"context"
-	"go-clinet-locations/services/user-service/internal/infrastructure/events"
+	"errors"
 	"go-clinet-locations/services/user-service/internal/infrastructure/grpc"
 	"go-clinet-locations/services/user-service/internal/infrastructure/repository"
 	"go-clinet-locations/services/user-service/internal/service"
@@ -10,11 +10,13 @@ import (
 	"go-clinet-locations/shared/env"
 	"go-clinet-locations/shared/messaging"
 	grpcserver "google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
 	"log"
 	"net"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
 var GrpcAddr = ":9093"
@@ -33,9 +35,28 @@ func main() {
 	}
 	defer mongoClient.Disconnect(ctx)
 
+	// users are written together with their outbox events in a transaction
+	if err := db.EnsureTransactions(ctx, mongoClient); err != nil {
+		log.Fatalf("Failed to initialize MongoDB, err: %v", err)
+	}
+
 	mongoDb := db.GetDatabase(mongoClient, db.NewMongoDefaultConfig())
 	mongoDbRepo := repository.NewMongoRepository(mongoDb)
 
+	// users created before the geospatial search only have coordinates, give them a GeoJSON location first
+	migrated, err := mongoDbRepo.MigrateLocations(ctx)
+	if err != nil {
+		log.Fatalf("Failed to migrate user locations, err: %v", err)
+	}
+	log.Printf("Migrated locations of %d users", migrated)
+
+	// duplicated userNames keep the service running without the unique index until they are renamed, see the README
+	if err := mongoDbRepo.EnsureIndexes(ctx); errors.Is(err, repository.ErrDuplicateUserNames) {
+		log.Printf("ERROR: userNames are not unique until the duplicates are renamed, err: %v", err)
+	} else if err != nil {
+		log.Fatalf("Failed to create MongoDB indexes, err: %v", err)
+	}
+
 	log.Printf(mongoDb.Name())
 
 	// Rabbit mq
@@ -49,8 +70,6 @@ func main() {
 
 	log.Println("Starting RabbitMQ connection")
 
-	publisher := events.NewUserEventPublisher(conn)
-
 	//inmemRepo := repository.NewInmemRepository()
 	//svc := service.NewService(inmemRepo)
 	svc := service.NewService(mongoDbRepo)
@@ -68,8 +87,16 @@ func main() {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
-	grpcServer := grpcserver.NewServer()
-	grpc.NewGRPCHandler(grpcServer, svc, publisher)
+	// allow the keepalive pings of the gateway clients, they are sent every 30s even without a call in flight
+	grpcServer := grpcserver.NewServer(grpcserver.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+		MinTime:             20 * time.Second,
+		PermitWithoutStream: true,
+	}))
+	grpc.NewGRPCHandler(grpcServer, svc)
+
+	// the location events written to the outbox together with the users are published in the background
+	relay := messaging.NewOutboxRelay(mongoDbRepo, conn)
+	go relay.Run(ctx)
 
 	log.Println("Starting gRPC server Trip service on port ", lis.Addr().String())
 
@@ -0,0 +1,189 @@
+package domain
+
+import (
+	"errors"
+	pb "go-clinet-locations/shared/proto/user"
+	"go-clinet-locations/shared/types"
+	"go-clinet-locations/shared/util"
+	"math"
+)
+
+var ErrInvalidArea = errors.New("invalid search area")
+
+// quarterCircumference is the distance in km from a point to the edge of its hemisphere
+const quarterCircumference = math.Pi / 2 * util.EarthRadius
+
+// SearchArea is the region the users are searched in
+type SearchArea interface {
+	// Center is the point the distance of every result is measured from
+	Center() *types.Coordinate
+	// Radius is the distance in km from the center that encloses the whole area
+	Radius() float64
+	// Contains reports whether the point belongs to the area
+	Contains(point *types.Coordinate) bool
+}
+
+// CircleArea is every point within Distance km of Location
+type CircleArea struct {
+	Location *types.Coordinate
+	Distance float64
+}
+
+func (a *CircleArea) Center() *types.Coordinate {
+	return a.Location
+}
+
+func (a *CircleArea) Radius() float64 {
+	return a.Distance
+}
+
+func (a *CircleArea) Contains(point *types.Coordinate) bool {
+	return util.CalculateDistance(a.Location, point) <= a.Distance
+}
+
+// BoundingBoxArea is a latitude/longitude rectangle such as the visible map viewport.
+// It crosses the antimeridian when SouthWest is east of NorthEast.
+type BoundingBoxArea struct {
+	SouthWest *types.Coordinate
+	NorthEast *types.Coordinate
+}
+
+func (a *BoundingBoxArea) Center() *types.Coordinate {
+	width := a.NorthEast.Longitude - a.SouthWest.Longitude
+	if width < 0 {
+		width += 360
+	}
+
+	longitude := a.SouthWest.Longitude + width/2
+	if longitude > 180 {
+		longitude -= 360
+	}
+
+	return &types.Coordinate{
+		Latitude:  (a.SouthWest.Latitude + a.NorthEast.Latitude) / 2,
+		Longitude: longitude,
+	}
+}
+
+func (a *BoundingBoxArea) Radius() float64 {
+	center := a.Center()
+
+	// the edges along the parallels bulge away from the corners, so their middles are checked as well
+	var radius float64
+	for _, lat := range []float64{a.SouthWest.Latitude, a.NorthEast.Latitude} {
+		for _, lon := range []float64{a.SouthWest.Longitude, center.Longitude, a.NorthEast.Longitude} {
+			radius = math.Max(radius, util.CalculateDistance(center, &types.Coordinate{Latitude: lat, Longitude: lon}))
+		}
+	}
+	return radius
+}
+
+func (a *BoundingBoxArea) Contains(point *types.Coordinate) bool {
+	return util.PointInBoundingBox(point, a.SouthWest, a.NorthEast)
+}
+
+// PolygonArea is a single ring without holes, for example a district boundary.
+// Its edges are great circle arcs, as MongoDB reads a GeoJSON polygon.
+type PolygonArea struct {
+	Vertices []*types.Coordinate
+}
+
+func (a *PolygonArea) Center() *types.Coordinate {
+	return util.PolygonCenter(a.ring())
+}
+
+func (a *PolygonArea) Radius() float64 {
+	center := a.Center()
+
+	// the distance from the center along an edge never exceeds the farther end,
+	// as long as both ends are less than a quarter of the globe away
+	var radius float64
+	for _, vertex := range a.Vertices {
+		radius = math.Max(radius, util.CalculateDistance(center, vertex))
+	}
+	return radius
+}
+
+func (a *PolygonArea) Contains(point *types.Coordinate) bool {
+	return util.PointInPolygon(point, a.Vertices)
+}
+
+// ring returns the vertices without the closing one, so it does not count twice in the center
+func (a *PolygonArea) ring() []*types.Coordinate {
+	n := len(a.Vertices)
+	if n > 1 && *a.Vertices[0] == *a.Vertices[n-1] {
+		return a.Vertices[:n-1]
+	}
+	return a.Vertices
+}
+
+// ValidateArea checks the area is well formed before searching in it
+func ValidateArea(area SearchArea) error {
+	switch a := area.(type) {
+	case *CircleArea:
+		if a.Location == nil || a.Distance < 0 {
+			return ErrInvalidArea
+		}
+	case *BoundingBoxArea:
+		if a.SouthWest == nil || a.NorthEast == nil || a.SouthWest.Latitude > a.NorthEast.Latitude {
+			return ErrInvalidArea
+		}
+	case *PolygonArea:
+		for _, vertex := range a.Vertices {
+			if vertex == nil {
+				return ErrInvalidArea
+			}
+		}
+		if len(a.ring()) < 3 {
+			return ErrInvalidArea
+		}
+		// MongoDB searches the complement of a ring larger than a hemisphere
+		center := a.Center()
+		for _, vertex := range a.Vertices {
+			if util.CalculateDistance(center, vertex) >= quarterCircumference {
+				return ErrInvalidArea
+			}
+		}
+	default:
+		return ErrInvalidArea
+	}
+	return nil
+}
+
+// SearchAreaFromProto returns the area of the request, falling back to the circle
+// described by coordinate and radius for clients that do not set one
+func SearchAreaFromProto(req *pb.SearchUsersRequest) SearchArea {
+	switch area := req.GetArea().(type) {
+	case *pb.SearchUsersRequest_Circle:
+		return &CircleArea{
+			Location: coordinateFromProto(area.Circle.GetCenter()),
+			Distance: area.Circle.GetRadius(),
+		}
+	case *pb.SearchUsersRequest_Bbox:
+		return &BoundingBoxArea{
+			SouthWest: coordinateFromProto(area.Bbox.GetSouthWest()),
+			NorthEast: coordinateFromProto(area.Bbox.GetNorthEast()),
+		}
+	case *pb.SearchUsersRequest_Polygon:
+		vertices := make([]*types.Coordinate, 0, len(area.Polygon.GetVertices()))
+		for _, vertex := range area.Polygon.GetVertices() {
+			vertices = append(vertices, coordinateFromProto(vertex))
+		}
+		return &PolygonArea{Vertices: vertices}
+	default:
+		return &CircleArea{
+			Location: coordinateFromProto(req.GetCoordinate()),
+			Distance: float64(req.GetRadius()),
+		}
+	}
+}
+
+func coordinateFromProto(coordinate *pb.Coordinate) *types.Coordinate {
+	if coordinate == nil {
+		return nil
+	}
+	return &types.Coordinate{
+		Latitude:  coordinate.GetLatitude(),
+		Longitude: coordinate.GetLongitude(),
+	}
+}
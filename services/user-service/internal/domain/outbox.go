@@ -0,0 +1,59 @@
+package domain
+
+import (
+	"go-clinet-locations/shared/contracts"
+	"go-clinet-locations/shared/messaging"
+	"go-clinet-locations/shared/proto/events"
+	"go-clinet-locations/shared/types"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"time"
+)
+
+// EventProducer is the producer of the events published by this service
+const EventProducer = "user-service"
+
+// NewUserLocationEvent builds the event asking the location history to record the current position of the user
+func NewUserLocationEvent(user *UserModel, recordedAt *time.Time) (*messaging.OutboxEvent, error) {
+	event := &events.UserLocationRegistered{
+		UserId:     user.ID.Hex(),
+		Coordinate: coordinateToEvent(user.Coordinates),
+	}
+	if recordedAt != nil {
+		event.RecordedAt = timestamppb.New(*recordedAt)
+	}
+
+	return newOutboxEvent(contracts.UserEventLocationRegistered, user, event)
+}
+
+func NewUserCreatedEvent(user *UserModel) (*messaging.OutboxEvent, error) {
+	return newOutboxEvent(contracts.UserEventCreated, user, &events.UserCreated{
+		UserId:     user.ID.Hex(),
+		UserName:   user.UserName,
+		Coordinate: coordinateToEvent(user.Coordinates),
+	})
+}
+
+// NewUserDeletedEvent builds the event asking the other services to erase the data of the user
+func NewUserDeletedEvent(user *UserModel) (*messaging.OutboxEvent, error) {
+	return newOutboxEvent(contracts.UserEventDeleted, user, &events.UserDeleted{
+		UserId:   user.ID.Hex(),
+		UserName: user.UserName,
+	})
+}
+
+func newOutboxEvent(routingKey string, user *UserModel, event proto.Message) (*messaging.OutboxEvent, error) {
+	envelope, err := messaging.NewEnvelope(EventProducer, user.ID.Hex(), event)
+	if err != nil {
+		return nil, err
+	}
+
+	return messaging.NewOutboxEvent(routingKey, envelope)
+}
+
+func coordinateToEvent(coordinate *types.Coordinate) *events.Coordinate {
+	return &events.Coordinate{
+		Latitude:  coordinate.Latitude,
+		Longitude: coordinate.Longitude,
+	}
+}
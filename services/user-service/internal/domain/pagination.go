@@ -0,0 +1,159 @@
+package domain
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
+)
+
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid page cursor")
+	ErrInvalidSort   = errors.New("invalid sort order")
+)
+
+// SortOrder is the order of the search results, ties are always broken by ID
+type SortOrder string
+
+const (
+	SortByDistance SortOrder = "distance"
+	SortByUserName SortOrder = "userName"
+)
+
+// ParseSortOrder validates the sort requested by the client, empty means the default order
+func ParseSortOrder(value string) (SortOrder, error) {
+	switch SortOrder(value) {
+	case "":
+		return SortByDistance, nil
+	case SortByDistance, SortByUserName:
+		return SortOrder(value), nil
+	default:
+		return "", ErrInvalidSort
+	}
+}
+
+// SearchPage describes which slice of the search results should be returned
+type SearchPage struct {
+	Limit  int
+	Cursor string
+	Sort   SortOrder
+}
+
+// Cursor is the content of the opaque cursor handed out to the clients.
+// It holds the sort key of the last returned user, so the next page can resume right after it.
+type Cursor struct {
+	Sort     SortOrder          `json:"s"`
+	ID       primitive.ObjectID `json:"id"`
+	Distance float64            `json:"d,omitempty"`
+	UserName string             `json:"n,omitempty"`
+}
+
+// EncodeCursor builds an opaque cursor that resumes right after the given user
+func EncodeCursor(user *UserModel, order SortOrder) string {
+	c := Cursor{Sort: order, ID: user.ID}
+	switch order {
+	case SortByDistance:
+		c.Distance = user.Distance
+	case SortByUserName:
+		c.UserName = user.UserName
+	}
+
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// DecodeCursor returns the position of the last user of the previous page.
+// A cursor created for a different sort order cannot be used.
+func DecodeCursor(cursor string, order SortOrder) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c Cursor
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	if c.Sort != order || c.ID.IsZero() {
+		return nil, ErrInvalidCursor
+	}
+
+	return &c, nil
+}
+
+// Normalize applies the default and maximum page size and the default sort
+func (p SearchPage) Normalize() SearchPage {
+	if p.Limit <= 0 {
+		p.Limit = DefaultSearchLimit
+	}
+	if p.Limit > MaxSearchLimit {
+		p.Limit = MaxSearchLimit
+	}
+	if p.Sort == "" {
+		p.Sort = SortByDistance
+	}
+	return p
+}
+
+// SortUsers orders the users by the given sort order, ties are broken by ID so the order is stable
+func SortUsers(users []*UserModel, order SortOrder) {
+	sort.Slice(users, func(i, j int) bool {
+		return less(users[i], users[j], order)
+	})
+}
+
+// isAfter reports whether the user comes after the cursor position
+func (c *Cursor) isAfter(user *UserModel) bool {
+	return less(&UserModel{ID: c.ID, Distance: c.Distance, UserName: c.UserName}, user, c.Sort)
+}
+
+func less(a, b *UserModel, order SortOrder) bool {
+	switch order {
+	case SortByDistance:
+		if a.Distance != b.Distance {
+			return a.Distance < b.Distance
+		}
+	case SortByUserName:
+		if a.UserName != b.UserName {
+			return a.UserName < b.UserName
+		}
+	}
+	return a.ID.Hex() < b.ID.Hex()
+}
+
+// PaginateUsers sorts the users and returns the page described by page together with the cursor of the next page
+func PaginateUsers(users []*UserModel, page SearchPage) ([]*UserModel, string, error) {
+	page = page.Normalize()
+	SortUsers(users, page.Sort)
+
+	start := 0
+	if page.Cursor != "" {
+		cursor, err := DecodeCursor(page.Cursor, page.Sort)
+		if err != nil {
+			return nil, "", err
+		}
+
+		start = len(users)
+		for i, user := range users {
+			if cursor.isAfter(user) {
+				start = i
+				break
+			}
+		}
+	}
+
+	end := start + page.Limit
+	if end >= len(users) {
+		return users[start:], "", nil
+	}
+
+	result := users[start:end]
+	return result, EncodeCursor(result[len(result)-1], page.Sort), nil
+}
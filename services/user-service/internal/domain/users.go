@@ -6,29 +6,71 @@ import (
 	pb "go-clinet-locations/shared/proto/user"
 	"go-clinet-locations/shared/types"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
 )
 
 type UserModel struct {
 	ID          primitive.ObjectID `bson:"_id,omitempty"`
 	UserName    string             `bson:"userName"`
 	Coordinates *types.Coordinate  `bson:"coordinates"`
+	// Distance from the search centre in km, only set on search results
+	Distance float64 `bson:"distance,omitempty"`
 }
 
 type UserRepository interface {
-	CreateUser(ctx context.Context, user *UserModel) (*UserModel, error)
-	UpdateUser(ctx context.Context, userName string, coordinates *types.Coordinate) (*UserModel, error)
+	// CreateUser and UpdateUser store the location event of the user in the outbox in the same write.
+	// recordedAt is the time the device took the fix, nil when unknown.
+	// CreateUser returns ErrUserNameTaken when another user has the same userName.
+	CreateUser(ctx context.Context, user *UserModel, recordedAt *time.Time) (*UserModel, error)
+	UpdateUser(ctx context.Context, userName string, coordinates *types.Coordinate, recordedAt *time.Time) (*UserModel, error)
 	GetUsers(ctx context.Context) ([]*UserModel, error)
+	// SearchUsers returns users inside the area with their distance from its center set, ordered by page.Sort
+	SearchUsers(ctx context.Context, area SearchArea, page SearchPage) ([]*UserModel, string, error)
+	// NearestUsers returns the k users closest to location with their distance set, nearest first
+	NearestUsers(ctx context.Context, location *types.Coordinate, k int) ([]*UserModel, error)
+	// GetUser and DeleteUser return ErrUserNotFound when no user matches the key
+	GetUser(ctx context.Context, key UserKey) (*UserModel, error)
+	// ListUsers returns every user ordered by userName, page.Sort is ignored
+	ListUsers(ctx context.Context, page SearchPage) ([]*UserModel, string, error)
+	// DeleteUser drops the unpublished events of the user from the outbox and stores
+	// the UserDeleted event in the same write, so no location of the user is sent afterwards
+	DeleteUser(ctx context.Context, key UserKey) (*UserModel, error)
 }
 
 type UserService interface {
-	CreateUser(ctx context.Context, user *UserModel) (*UserModel, error)
-	UpdateUser(ctx context.Context, userName string, coordinates *types.Coordinate) (*UserModel, error)
-	SearchUsers(ctx context.Context, location *types.Coordinate, radius float64) ([]*UserModel, error)
+	CreateUser(ctx context.Context, user *UserModel, recordedAt *time.Time) (*UserModel, error)
+	UpdateUser(ctx context.Context, userName string, coordinates *types.Coordinate, recordedAt *time.Time) (*UserModel, error)
+	SearchUsers(ctx context.Context, area SearchArea, page SearchPage) ([]*UserModel, string, error)
+	NearestUsers(ctx context.Context, location *types.Coordinate, k int) ([]*UserModel, error)
+	GetUser(ctx context.Context, key UserKey) (*UserModel, error)
+	ListUsers(ctx context.Context, page SearchPage) ([]*UserModel, string, error)
+	DeleteUser(ctx context.Context, key UserKey) (*UserModel, error)
 }
 
+// UserKey identifies a single user, by ID when it is set and by userName otherwise
+type UserKey struct {
+	ID       primitive.ObjectID
+	UserName string
+}
+
+// Matches reports whether the user is the one the key identifies
+func (k UserKey) Matches(user *UserModel) bool {
+	if !k.ID.IsZero() {
+		return user.ID == k.ID
+	}
+	return user.UserName == k.UserName
+}
+
+const (
+	DefaultNearestUsers = 10
+	MaxNearestUsers     = 100
+)
+
 // Common errors
 var (
-	ErrUserNotFound = errors.New("user not found")
+	ErrUserNotFound   = errors.New("user not found")
+	ErrUserNameTaken  = errors.New("userName is already taken")
+	ErrInvalidUserKey = errors.New("exactly one of ID and userName is required")
 )
 
 func (u *UserModel) ToProto() *pb.User {
@@ -39,6 +81,7 @@ func (u *UserModel) ToProto() *pb.User {
 			Latitude:  u.Coordinates.Latitude,
 			Longitude: u.Coordinates.Longitude,
 		},
+		Distance: u.Distance,
 	}
 }
 
@@ -2,26 +2,30 @@ package grpc
 
 import (
 	"context"
+	"errors"
 	"go-clinet-locations/services/user-service/internal/domain"
-	"go-clinet-locations/services/user-service/internal/infrastructure/events"
+	"go-clinet-locations/shared/db"
 	pb "go-clinet-locations/shared/proto/user"
 	"go-clinet-locations/shared/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"log"
+	"time"
 )
 
 type grpcHandler struct {
 	pb.UnimplementedUserServiceServer
-	service   domain.UserService
-	publisher *events.UserEvenPublisher
+	service domain.UserService
 }
 
-func NewGRPCHandler(server *grpc.Server, service domain.UserService, publisher *events.UserEvenPublisher) *grpcHandler {
+// NewGRPCHandler registers the user service. The location events of created and updated users
+// are written to the outbox by the repository and published by the outbox relay.
+func NewGRPCHandler(server *grpc.Server, service domain.UserService) *grpcHandler {
 	handler := &grpcHandler{
-		service:   service,
-		publisher: publisher,
+		service: service,
 	}
 
 	pb.RegisterUserServiceServer(server, handler)
@@ -29,7 +33,15 @@ func NewGRPCHandler(server *grpc.Server, service domain.UserService, publisher *
 	return handler
 }
 func (h *grpcHandler) CreateUser(ctx context.Context, req *pb.UpdateUserRequest) (*pb.CreateUserResponse, error) {
-	reqCoordinate := req.Coordinate
+	reqCoordinate := req.GetCoordinate()
+	if reqCoordinate == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "coordinate is required")
+	}
+
+	recordedAt, err := parseRecordedAt(req.GetRecordedAt())
+	if err != nil {
+		return nil, err
+	}
 
 	userCords := &types.Coordinate{
 		Longitude: reqCoordinate.Longitude,
@@ -41,22 +53,12 @@ func (h *grpcHandler) CreateUser(ctx context.Context, req *pb.UpdateUserRequest)
 		Coordinates: userCords,
 	}
 
-	user, err := h.service.CreateUser(ctx, newUser)
+	user, err := h.service.CreateUser(ctx, newUser, recordedAt)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to create user %v", err)
+		return nil, statusFromError(ctx, err, "failed to create user")
 	}
 	log.Printf("user created with id: %v", user.ID)
 
-	if err := h.publisher.PublishUserCreated(ctx, &types.UserLocation{
-		UserId: user.ID.Hex(),
-		Coordinate: &types.Coordinate{
-			Latitude:  user.Coordinates.Latitude,
-			Longitude: user.Coordinates.Longitude,
-		},
-	}); err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to publish user location evet: %v", err)
-	}
-
 	return &pb.CreateUserResponse{
 		User: &pb.User{
 			ID:       user.ID.Hex(),
@@ -72,29 +74,23 @@ func (h *grpcHandler) CreateUser(ctx context.Context, req *pb.UpdateUserRequest)
 
 func (h *grpcHandler) UpdateUser(ctx context.Context, req *pb.UpdateUserRequest) (*pb.UpdateUserResponse, error) {
 	reqCoordinate := req.GetCoordinate()
+	if reqCoordinate == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "coordinate is required")
+	}
+
+	recordedAt, err := parseRecordedAt(req.GetRecordedAt())
+	if err != nil {
+		return nil, err
+	}
 
 	userCords := &types.Coordinate{
 		Longitude: reqCoordinate.Longitude,
 		Latitude:  reqCoordinate.Latitude,
 	}
 
-	user, err := h.service.UpdateUser(ctx, req.GetUserName(), userCords)
+	user, err := h.service.UpdateUser(ctx, req.GetUserName(), userCords, recordedAt)
 	if err != nil {
-		// Check if the error is "user not found" and return appropriate gRPC status
-		if err.Error() == "user not found" {
-			return nil, status.Errorf(codes.NotFound, "user not found")
-		}
-		return nil, status.Errorf(codes.Internal, "failed to update user %v", err)
-	}
-
-	if err := h.publisher.PublishUserCreated(ctx, &types.UserLocation{
-		UserId: user.ID.Hex(),
-		Coordinate: &types.Coordinate{
-			Latitude:  user.Coordinates.Latitude,
-			Longitude: user.Coordinates.Longitude,
-		},
-	}); err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to publish user location event: %v", err)
+		return nil, statusFromError(ctx, err, "failed to update user")
 	}
 
 	return &pb.UpdateUserResponse{
@@ -110,18 +106,139 @@ func (h *grpcHandler) UpdateUser(ctx context.Context, req *pb.UpdateUserRequest)
 }
 
 func (h *grpcHandler) SearchUsers(ctx context.Context, req *pb.SearchUsersRequest) (*pb.SearchUsersResponse, error) {
+	area := domain.SearchAreaFromProto(req)
+
+	sortOrder, err := domain.ParseSortOrder(req.GetSort())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid sort %q", req.GetSort())
+	}
+
+	page := domain.SearchPage{
+		Limit:  int(req.GetPageSize()),
+		Cursor: req.GetPageToken(),
+		Sort:   sortOrder,
+	}
+	users, nextCursor, err := h.service.SearchUsers(ctx, area, page)
+
+	if err != nil {
+		return nil, statusFromError(ctx, err, "failed to search users")
+	}
+
+	return &pb.SearchUsersResponse{
+		Users:         domain.ToUsersProto(users),
+		NextPageToken: nextCursor,
+	}, nil
+
+}
+
+func (h *grpcHandler) NearestUsers(ctx context.Context, req *pb.NearestUsersRequest) (*pb.NearestUsersResponse, error) {
 	reqCoordinate := req.GetCoordinate()
 
 	coordinate := &types.Coordinate{
-		Longitude: reqCoordinate.Longitude,
-		Latitude:  reqCoordinate.Latitude,
+		Longitude: reqCoordinate.GetLongitude(),
+		Latitude:  reqCoordinate.GetLatitude(),
+	}
+	users, err := h.service.NearestUsers(ctx, coordinate, int(req.GetK()))
+
+	if err != nil {
+		return nil, statusFromError(ctx, err, "failed to find nearest users")
 	}
-	users, err := h.service.SearchUsers(ctx, coordinate, float64(req.GetRadius()))
 
+	return &pb.NearestUsersResponse{Users: domain.ToUsersProto(users)}, nil
+}
+
+func (h *grpcHandler) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.GetUserResponse, error) {
+	key, err := parseUserKey(req.GetID(), req.GetUserName())
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to search users %v", err)
+		return nil, err
 	}
 
-	return &pb.SearchUsersResponse{Users: domain.ToUsersProto(users)}, nil
+	user, err := h.service.GetUser(ctx, key)
+	if err != nil {
+		return nil, statusFromError(ctx, err, "failed to get user")
+	}
+
+	return &pb.GetUserResponse{User: user.ToProto()}, nil
+}
+
+func (h *grpcHandler) ListUsers(ctx context.Context, req *pb.ListUsersRequest) (*pb.ListUsersResponse, error) {
+	page := domain.SearchPage{
+		Limit:  int(req.GetPageSize()),
+		Cursor: req.GetPageToken(),
+	}
+	users, nextCursor, err := h.service.ListUsers(ctx, page)
+	if err != nil {
+		return nil, statusFromError(ctx, err, "failed to list users")
+	}
+
+	return &pb.ListUsersResponse{
+		Users:         domain.ToUsersProto(users),
+		NextPageToken: nextCursor,
+	}, nil
+}
+
+func (h *grpcHandler) DeleteUser(ctx context.Context, req *pb.DeleteUserRequest) (*pb.DeleteUserResponse, error) {
+	key, err := parseUserKey(req.GetID(), req.GetUserName())
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := h.service.DeleteUser(ctx, key)
+	if err != nil {
+		return nil, statusFromError(ctx, err, "failed to delete user")
+	}
+	log.Printf("user deleted with id: %v", user.ID)
+
+	return &pb.DeleteUserResponse{User: user.ToProto()}, nil
+}
+
+// statusFromError returns the gRPC status of a failed call, the domain errors carry the code
+// the client can act on, anything else is reported as internal
+func statusFromError(ctx context.Context, err error, message string) error {
+	switch {
+	case errors.Is(err, domain.ErrUserNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, domain.ErrUserNameTaken):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, domain.ErrInvalidCursor), errors.Is(err, domain.ErrInvalidSort), errors.Is(err, domain.ErrInvalidArea),
+		errors.Is(err, domain.ErrInvalidUserKey):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case ctx.Err() != nil:
+		// the client gave up or its deadline passed while the call was running
+		return status.FromContextError(ctx.Err()).Err()
+	case db.IsUnavailable(err):
+		return status.Errorf(codes.Unavailable, "%s: %v", message, err)
+	case mongo.IsTimeout(err):
+		return status.Errorf(codes.DeadlineExceeded, "%s: %v", message, err)
+	default:
+		return status.Errorf(codes.Internal, "%s: %v", message, err)
+	}
+}
+
+// parseUserKey returns the key of a request that identifies the user by ID or userName
+func parseUserKey(id, userName string) (domain.UserKey, error) {
+	if id == "" {
+		return domain.UserKey{UserName: userName}, nil
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return domain.UserKey{}, status.Errorf(codes.InvalidArgument, "invalid ID %q", id)
+	}
+
+	return domain.UserKey{ID: objectID, UserName: userName}, nil
+}
+
+// parseRecordedAt returns nil when the client did not send the time of the fix
+func parseRecordedAt(recordedAt string) (*time.Time, error) {
+	if recordedAt == "" {
+		return nil, nil
+	}
+
+	timestamp, err := time.Parse(time.RFC3339, recordedAt)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid recordedAt %q", recordedAt)
+	}
 
+	return &timestamp, nil
 }
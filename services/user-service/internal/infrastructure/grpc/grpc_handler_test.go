@@ -0,0 +1,48 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-clinet-locations/services/user-service/internal/domain"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+func TestStatusFromError(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error
+		expectedCode codes.Code
+	}{
+		{name: "user not found", err: domain.ErrUserNotFound, expectedCode: codes.NotFound},
+		{name: "userName taken", err: domain.ErrUserNameTaken, expectedCode: codes.AlreadyExists},
+		{
+			name:         "mongo unreachable",
+			err:          fmt.Errorf("failed to update user: %w", topology.ServerSelectionError{Wrapped: topology.ErrServerSelectionTimeout}),
+			expectedCode: codes.Unavailable,
+		},
+		{
+			name:         "connection dropped",
+			err:          fmt.Errorf("failed to search users: %w", mongo.CommandError{Labels: []string{"NetworkError"}}),
+			expectedCode: codes.Unavailable,
+		},
+		{
+			name:         "query timed out",
+			err:          fmt.Errorf("failed to search users: %w", mongo.CommandError{Code: 50, Name: "MaxTimeMSExpired"}),
+			expectedCode: codes.DeadlineExceeded,
+		},
+		{name: "unexpected", err: errors.New("boom"), expectedCode: codes.Internal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := status.Code(statusFromError(context.Background(), tt.err, "failed")); code != tt.expectedCode {
+				t.Errorf("expected code %v, got %v", tt.expectedCode, code)
+			}
+		})
+	}
+}
@@ -0,0 +1,292 @@
+package repository
+
+import (
+	"github.com/mmcloughlin/geohash"
+	"go-clinet-locations/services/user-service/internal/domain"
+	"go-clinet-locations/shared/types"
+	"go-clinet-locations/shared/util"
+	"math"
+)
+
+// geohashPrecision is the length of the geohash used as a bucket key.
+// 5 characters give cells of roughly 4.9km x 4.9km which matches the default search radius.
+const geohashPrecision = 5
+
+// kmPerDegree is the length of one degree of latitude (and of longitude at the equator)
+const kmPerDegree = math.Pi * util.EarthRadius / 180
+
+// geohashIndex buckets users by the geohash cell of their coordinates so a radius search
+// only has to look at the cells overlapping the searched circle.
+// It is not safe for concurrent use, the repository guards it with its own mutex.
+type geohashIndex struct {
+	cells map[string]map[string]*domain.UserModel
+	// userCells remembers the cell of every indexed user, so it can be moved on update
+	userCells map[string]string
+
+	cellHeight float64
+	cellWidth  float64
+}
+
+func newGeohashIndex() *geohashIndex {
+	box := geohash.BoundingBox(geohash.EncodeWithPrecision(0, 0, geohashPrecision))
+
+	return &geohashIndex{
+		cells:      make(map[string]map[string]*domain.UserModel),
+		userCells:  make(map[string]string),
+		cellHeight: box.MaxLat - box.MinLat,
+		cellWidth:  box.MaxLng - box.MinLng,
+	}
+}
+
+// put adds the user under key, moving it to a new cell when the coordinates have changed
+func (idx *geohashIndex) put(key string, user *domain.UserModel) {
+	idx.remove(key)
+
+	cell := encodeCell(user.Coordinates.Latitude, user.Coordinates.Longitude)
+	if idx.cells[cell] == nil {
+		idx.cells[cell] = make(map[string]*domain.UserModel)
+	}
+	idx.cells[cell][key] = user
+	idx.userCells[key] = cell
+}
+
+func (idx *geohashIndex) remove(key string) {
+	cell, ok := idx.userCells[key]
+	if !ok {
+		return
+	}
+
+	delete(idx.cells[cell], key)
+	if len(idx.cells[cell]) == 0 {
+		delete(idx.cells, cell)
+	}
+	delete(idx.userCells, key)
+}
+
+// search returns copies of the users inside the area with their distance from its center set, in no particular order.
+// Only the cells around the circle enclosing the area are visited.
+func (idx *geohashIndex) search(area domain.SearchArea) []*domain.UserModel {
+	var result []*domain.UserModel
+
+	center := area.Center()
+	for _, cell := range idx.cellsAround(center, area.Radius()) {
+		for _, user := range idx.cells[cell] {
+			if area.Contains(user.Coordinates) {
+				result = append(result, withDistance(user, util.CalculateDistance(center, user.Coordinates)))
+			}
+		}
+	}
+
+	return result
+}
+
+// withDistance copies the user so the stored model is never modified by a search
+func withDistance(user *domain.UserModel, distance float64) *domain.UserModel {
+	return &domain.UserModel{
+		ID:          user.ID,
+		UserName:    user.UserName,
+		Coordinates: user.Coordinates,
+		Distance:    distance,
+	}
+}
+
+// cellsAround returns the non-empty cells overlapping the bounding box of the circle.
+// When the box spans more cells than are populated it is cheaper to visit every populated cell.
+func (idx *geohashIndex) cellsAround(location *types.Coordinate, radius float64) []string {
+	latDelta := radius / kmPerDegree
+	minLat := math.Max(location.Latitude-latDelta, -90)
+	maxLat := math.Min(location.Latitude+latDelta, 90)
+
+	// longitude extent of a spherical circle, the box covers every longitude when the circle contains a pole
+	lonDelta := 180.0
+	if minLat > -90 && maxLat < 90 {
+		ratio := math.Sin(radius/util.EarthRadius) / math.Cos(location.Latitude*math.Pi/180)
+		if ratio < 1 {
+			lonDelta = math.Asin(ratio) * 180 / math.Pi
+		}
+	}
+
+	var lonRanges [][2]float64
+	switch {
+	case lonDelta >= 180:
+		lonRanges = [][2]float64{{-180, 180}}
+	case location.Longitude-lonDelta < -180:
+		lonRanges = [][2]float64{{-180, location.Longitude + lonDelta}, {location.Longitude - lonDelta + 360, 180}}
+	case location.Longitude+lonDelta > 180:
+		lonRanges = [][2]float64{{location.Longitude - lonDelta, 180}, {-180, location.Longitude + lonDelta - 360}}
+	default:
+		lonRanges = [][2]float64{{location.Longitude - lonDelta, location.Longitude + lonDelta}}
+	}
+
+	var boxCells float64
+	for _, lonRange := range lonRanges {
+		boxCells += (math.Ceil((maxLat-minLat)/idx.cellHeight) + 1) * (math.Ceil((lonRange[1]-lonRange[0])/idx.cellWidth) + 1)
+	}
+	if boxCells >= float64(len(idx.cells)) {
+		return idx.allCells()
+	}
+
+	seen := make(map[string]bool)
+	var cells []string
+	for _, lonRange := range lonRanges {
+		for _, cell := range idx.cellsInBox(minLat, maxLat, lonRange[0], lonRange[1]) {
+			if seen[cell] {
+				continue
+			}
+			seen[cell] = true
+			if _, ok := idx.cells[cell]; ok {
+				cells = append(cells, cell)
+			}
+		}
+	}
+
+	return cells
+}
+
+// cellsInBox walks the box in steps of one cell so that every cell it overlaps is hit at least once
+func (idx *geohashIndex) cellsInBox(minLat, maxLat, minLon, maxLon float64) []string {
+	var cells []string
+
+	for lat := minLat; ; lat += idx.cellHeight {
+		lat = math.Min(lat, maxLat)
+		for lon := minLon; ; lon += idx.cellWidth {
+			lon = math.Min(lon, maxLon)
+			cells = append(cells, encodeCell(lat, lon))
+			if lon >= maxLon {
+				break
+			}
+		}
+		if lat >= maxLat {
+			break
+		}
+	}
+
+	return cells
+}
+
+// nearest returns copies of the k users closest to location with their distance set, nearest first.
+// Rings of cells around the location are visited until the k-th candidate is closer than anything
+// in the cells not visited yet, so only a full scan is needed when the users are very sparse.
+func (idx *geohashIndex) nearest(location *types.Coordinate, k int) []*domain.UserModel {
+	if k <= 0 || len(idx.userCells) == 0 {
+		return nil
+	}
+	if k >= len(idx.userCells) {
+		return idx.nearestByScan(location, k)
+	}
+
+	row, col := idx.gridPosition(location.Latitude, location.Longitude)
+	rows := int(math.Round(180 / idx.cellHeight))
+	cols := int(math.Round(360 / idx.cellWidth))
+
+	var candidates []*domain.UserModel
+	visitedCells := 0
+	for ring := 0; ; ring++ {
+		// past a quarter of the globe the longitude bound below stops holding, scanning is cheaper anyway
+		if float64(2*ring+1)*idx.cellWidth >= 180 || visitedCells > len(idx.cells) {
+			return idx.nearestByScan(location, k)
+		}
+
+		for _, cell := range idx.ringCells(row, col, ring, rows, cols) {
+			visitedCells++
+			for _, user := range idx.cells[cell] {
+				candidates = append(candidates, withDistance(user, util.CalculateDistance(location, user.Coordinates)))
+			}
+		}
+
+		if len(candidates) < k {
+			continue
+		}
+
+		domain.SortUsers(candidates, domain.SortByDistance)
+		if candidates[k-1].Distance < idx.unvisitedDistance(location, row, col, ring) {
+			return candidates[:k]
+		}
+	}
+}
+
+func (idx *geohashIndex) nearestByScan(location *types.Coordinate, k int) []*domain.UserModel {
+	var result []*domain.UserModel
+	for _, users := range idx.cells {
+		for _, user := range users {
+			result = append(result, withDistance(user, util.CalculateDistance(location, user.Coordinates)))
+		}
+	}
+
+	domain.SortUsers(result, domain.SortByDistance)
+	if len(result) > k {
+		result = result[:k]
+	}
+	return result
+}
+
+// gridPosition returns the row and column of the cell containing the point
+func (idx *geohashIndex) gridPosition(lat, lon float64) (int, int) {
+	box := geohash.BoundingBox(encodeCell(lat, lon))
+	row := int(math.Round((box.MinLat + 90) / idx.cellHeight))
+	col := int(math.Round((box.MinLng + 180) / idx.cellWidth))
+	return row, col
+}
+
+// ringCells returns the cells exactly ring cells away from the given one,
+// wrapping around the antimeridian and skipping rows beyond the poles
+func (idx *geohashIndex) ringCells(row, col, ring, rows, cols int) []string {
+	var cells []string
+	for dRow := -ring; dRow <= ring; dRow++ {
+		r := row + dRow
+		if r < 0 || r >= rows {
+			continue
+		}
+		for dCol := -ring; dCol <= ring; dCol++ {
+			if dRow != -ring && dRow != ring && dCol != -ring && dCol != ring {
+				continue
+			}
+			c := ((col+dCol)%cols + cols) % cols
+			lat := -90 + (float64(r)+0.5)*idx.cellHeight
+			lon := -180 + (float64(c)+0.5)*idx.cellWidth
+			cells = append(cells, encodeCell(lat, lon))
+		}
+	}
+	return cells
+}
+
+// unvisitedDistance is a lower bound in km of the distance from location to any point
+// outside of the cells visited so far
+func (idx *geohashIndex) unvisitedDistance(location *types.Coordinate, row, col, ring int) float64 {
+	minLat := -90 + float64(row-ring)*idx.cellHeight
+	maxLat := -90 + float64(row+ring+1)*idx.cellHeight
+	minLon := -180 + float64(col-ring)*idx.cellWidth
+	maxLon := -180 + float64(col+ring+1)*idx.cellWidth
+
+	// any point north or south of the visited rows is at least the latitude difference away
+	bound := math.Inf(1)
+	if minLat > -90 {
+		bound = math.Min(bound, (location.Latitude-minLat)*kmPerDegree)
+	}
+	if maxLat < 90 {
+		bound = math.Min(bound, (maxLat-location.Latitude)*kmPerDegree)
+	}
+
+	// any point east or west of the visited columns is at least as far as the meridian bounding them
+	lonGap := math.Min(location.Longitude-minLon, maxLon-location.Longitude) * math.Pi / 180
+	lat := location.Latitude * math.Pi / 180
+	bound = math.Min(bound, util.EarthRadius*math.Asin(math.Cos(lat)*math.Sin(lonGap)))
+
+	return bound
+}
+
+func (idx *geohashIndex) allCells() []string {
+	cells := make([]string, 0, len(idx.cells))
+	for cell := range idx.cells {
+		cells = append(cells, cell)
+	}
+	return cells
+}
+
+// encodeCell returns the geohash cell of the point. Geohash ranges are half-open,
+// so the north pole and the antimeridian are nudged into the last cell.
+func encodeCell(lat, lon float64) string {
+	lat = math.Min(lat, math.Nextafter(90, 0))
+	lon = math.Min(lon, math.Nextafter(180, 0))
+	return geohash.EncodeWithPrecision(lat, lon, geohashPrecision)
+}
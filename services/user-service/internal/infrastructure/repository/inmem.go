@@ -4,18 +4,26 @@ import (
 	"context"
 	"fmt"
 	"go-clinet-locations/services/user-service/internal/domain"
+	"go-clinet-locations/shared/messaging"
 	"go-clinet-locations/shared/types"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
 	"sync"
+	"time"
 )
 
 type inmemRepository struct {
 	users map[string]*domain.UserModel
-	mu    sync.RWMutex
+	index *geohashIndex
+	// the outbox holds the events not published yet, oldest first
+	*messaging.InmemOutbox
+	mu sync.RWMutex
 }
 
 func NewInmemRepository() *inmemRepository {
-	return &inmemRepository{
+	r := &inmemRepository{
+		index:       newGeohashIndex(),
+		InmemOutbox: messaging.NewInmemOutbox(),
 		users: map[string]*domain.UserModel{
 			// Magnolia
 			"user1": {
@@ -65,16 +73,44 @@ func NewInmemRepository() *inmemRepository {
 			},
 		},
 	}
+
+	for key, user := range r.users {
+		r.index.put(key, user)
+	}
+
+	return r
 }
 
-func (r *inmemRepository) CreateUser(ctx context.Context, user *domain.UserModel) (*domain.UserModel, error) {
+func (r *inmemRepository) CreateUser(ctx context.Context, user *domain.UserModel, recordedAt *time.Time) (*domain.UserModel, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	for _, existing := range r.users {
+		if existing.UserName == user.UserName {
+			return nil, domain.ErrUserNameTaken
+		}
+	}
+
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+
+	createdEvent, err := domain.NewUserCreatedEvent(user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user created event: %v", err)
+	}
+	locationEvent, err := domain.NewUserLocationEvent(user, recordedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create location event: %v", err)
+	}
+
 	r.users[user.ID.Hex()] = user
+	r.index.put(user.ID.Hex(), user)
+	r.Add(createdEvent)
+	r.Add(locationEvent)
 	return user, nil
 }
-func (r *inmemRepository) UpdateUser(ctx context.Context, userName string, coordinates *types.Coordinate) (*domain.UserModel, error) {
+func (r *inmemRepository) UpdateUser(ctx context.Context, userName string, coordinates *types.Coordinate, recordedAt *time.Time) (*domain.UserModel, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
@@ -87,22 +123,98 @@ func (r *inmemRepository) UpdateUser(ctx context.Context, userName string, coord
 				Coordinates: coordinates,
 			}
 
+			event, err := domain.NewUserLocationEvent(updatedUser, recordedAt)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create location event: %v", err)
+			}
+
 			r.users[key] = updatedUser
+			r.index.put(key, updatedUser)
+			r.Add(event)
 
 			return updatedUser, nil
 		}
 	}
 
-	return nil, fmt.Errorf("failed to find user in DB")
+	return nil, domain.ErrUserNotFound
 }
 
 func (r *inmemRepository) GetUsers(ctx context.Context) ([]*domain.UserModel, error) {
-	// TODO: check if I need to use mutexes on getting
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 
-	result := make([]*domain.UserModel, 0, 10)
+	result := make([]*domain.UserModel, 0, len(r.users))
 
 	for _, value := range r.users {
 		result = append(result, value)
 	}
+
+	// map iteration order is random, sort by ID so paginated search results are stable
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID.Hex() < result[j].ID.Hex()
+	})
+
 	return result, nil
 }
+
+func (r *inmemRepository) SearchUsers(ctx context.Context, area domain.SearchArea, page domain.SearchPage) ([]*domain.UserModel, string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// only the geohash cells around the area are visited
+	filteredUsers := r.index.search(area)
+
+	return domain.PaginateUsers(filteredUsers, page)
+}
+
+func (r *inmemRepository) NearestUsers(ctx context.Context, location *types.Coordinate, k int) ([]*domain.UserModel, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.index.nearest(location, k), nil
+}
+
+func (r *inmemRepository) GetUser(ctx context.Context, key domain.UserKey) (*domain.UserModel, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, user := range r.users {
+		if key.Matches(user) {
+			return user, nil
+		}
+	}
+
+	return nil, domain.ErrUserNotFound
+}
+
+func (r *inmemRepository) ListUsers(ctx context.Context, page domain.SearchPage) ([]*domain.UserModel, string, error) {
+	users, err := r.GetUsers(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	page.Sort = domain.SortByUserName
+	return domain.PaginateUsers(users, page)
+}
+
+func (r *inmemRepository) DeleteUser(ctx context.Context, key domain.UserKey) (*domain.UserModel, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for mapKey, user := range r.users {
+		if key.Matches(user) {
+			event, err := domain.NewUserDeletedEvent(user)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create user deleted event: %v", err)
+			}
+
+			delete(r.users, mapKey)
+			r.index.remove(mapKey)
+			r.DropEvents(user.ID.Hex())
+			r.Add(event)
+			return user, nil
+		}
+	}
+
+	return nil, domain.ErrUserNotFound
+}
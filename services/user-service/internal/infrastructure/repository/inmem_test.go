@@ -0,0 +1,398 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-clinet-locations/services/user-service/internal/domain"
+	"go-clinet-locations/shared/contracts"
+	"go-clinet-locations/shared/messaging"
+	"go-clinet-locations/shared/types"
+	"go-clinet-locations/shared/util"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func newEmptyInmemRepository() *inmemRepository {
+	return &inmemRepository{
+		users:       make(map[string]*domain.UserModel),
+		index:       newGeohashIndex(),
+		InmemOutbox: messaging.NewInmemOutbox(),
+	}
+}
+
+// searchAll follows the cursors until every page of the search has been read
+func searchAll(t *testing.T, repo *inmemRepository, area domain.SearchArea) []*domain.UserModel {
+	var result []*domain.UserModel
+	cursor := ""
+	for {
+		users, next, err := repo.SearchUsers(context.Background(), area, domain.SearchPage{Limit: domain.MaxSearchLimit, Cursor: cursor})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		result = append(result, users...)
+		if next == "" {
+			return result
+		}
+		cursor = next
+	}
+}
+
+func TestInmemRepository_SearchUsersMatchesFullScan(t *testing.T) {
+	ctx := context.Background()
+	repo := newEmptyInmemRepository()
+	rnd := rand.New(rand.NewSource(1))
+
+	// dense cluster around Wroclaw plus users spread over the globe, including the poles and the antimeridian
+	var users []*domain.UserModel
+	for i := 0; i < 2000; i++ {
+		lat := 51.1 + rnd.Float64()*0.2
+		lon := 16.9 + rnd.Float64()*0.3
+		if i%4 == 0 {
+			lat = rnd.Float64()*180 - 90
+			lon = rnd.Float64()*360 - 180
+		}
+		user := &domain.UserModel{
+			ID:          primitive.NewObjectID(),
+			UserName:    fmt.Sprintf("user%d", i),
+			Coordinates: &types.Coordinate{Latitude: lat, Longitude: lon},
+		}
+		users = append(users, user)
+		if _, err := repo.CreateUser(ctx, user, nil); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		location *types.Coordinate
+		radius   float64
+	}{
+		{name: "city centre", location: &types.Coordinate{Latitude: 51.11822470712269, Longitude: 16.990711729269563}, radius: 5},
+		{name: "small radius", location: &types.Coordinate{Latitude: 51.2, Longitude: 17.0}, radius: 0.5},
+		{name: "whole region", location: &types.Coordinate{Latitude: 51.2, Longitude: 17.0}, radius: 300},
+		{name: "antimeridian", location: &types.Coordinate{Latitude: 10, Longitude: 179.9}, radius: 800},
+		{name: "north pole", location: &types.Coordinate{Latitude: 89.9, Longitude: 0}, radius: 1500},
+		{name: "half of the globe", location: &types.Coordinate{Latitude: 0, Longitude: 0}, radius: 10000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expected := make(map[string]bool)
+			for _, user := range users {
+				if util.CalculateDistance(tt.location, user.Coordinates) <= tt.radius {
+					expected[user.ID.Hex()] = true
+				}
+			}
+
+			result := searchAll(t, repo, &domain.CircleArea{Location: tt.location, Distance: tt.radius})
+
+			if len(result) != len(expected) {
+				t.Errorf("expected %d users, got %d", len(expected), len(result))
+			}
+			for _, user := range result {
+				if !expected[user.ID.Hex()] {
+					t.Errorf("user %s is outside of the radius", user.UserName)
+				}
+			}
+		})
+	}
+}
+
+func TestInmemRepository_SearchAreaMatchesFullScan(t *testing.T) {
+	ctx := context.Background()
+	repo := newEmptyInmemRepository()
+	rnd := rand.New(rand.NewSource(3))
+
+	var users []*domain.UserModel
+	for i := 0; i < 2000; i++ {
+		lat := 51.0 + rnd.Float64()*0.3
+		lon := 16.8 + rnd.Float64()*0.4
+		if i%4 == 0 {
+			lat = rnd.Float64()*180 - 90
+			lon = rnd.Float64()*360 - 180
+		}
+		user := &domain.UserModel{
+			ID:          primitive.NewObjectID(),
+			UserName:    fmt.Sprintf("user%d", i),
+			Coordinates: &types.Coordinate{Latitude: lat, Longitude: lon},
+		}
+		users = append(users, user)
+		if _, err := repo.CreateUser(ctx, user, nil); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		area domain.SearchArea
+	}{
+		{
+			name: "city viewport",
+			area: &domain.BoundingBoxArea{
+				SouthWest: &types.Coordinate{Latitude: 51.05, Longitude: 16.9},
+				NorthEast: &types.Coordinate{Latitude: 51.15, Longitude: 17.1},
+			},
+		},
+		{
+			name: "viewport across the antimeridian",
+			area: &domain.BoundingBoxArea{
+				SouthWest: &types.Coordinate{Latitude: -30, Longitude: 170},
+				NorthEast: &types.Coordinate{Latitude: 30, Longitude: -170},
+			},
+		},
+		{
+			name: "concave district",
+			area: &domain.PolygonArea{Vertices: []*types.Coordinate{
+				{Latitude: 51.0, Longitude: 16.8},
+				{Latitude: 51.0, Longitude: 17.2},
+				{Latitude: 51.3, Longitude: 17.2},
+				{Latitude: 51.15, Longitude: 17.0},
+				{Latitude: 51.3, Longitude: 16.8},
+				{Latitude: 51.0, Longitude: 16.8},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expected := make(map[string]bool)
+			for _, user := range users {
+				if tt.area.Contains(user.Coordinates) {
+					expected[user.ID.Hex()] = true
+				}
+			}
+
+			result := searchAll(t, repo, tt.area)
+
+			if len(result) != len(expected) {
+				t.Errorf("expected %d users, got %d", len(expected), len(result))
+			}
+			for _, user := range result {
+				if !expected[user.ID.Hex()] {
+					t.Errorf("user %s is outside of the area", user.UserName)
+				}
+			}
+		})
+	}
+}
+
+func TestInmemRepository_UpdateUserMovesIndexCell(t *testing.T) {
+	ctx := context.Background()
+	repo := newEmptyInmemRepository()
+
+	user := &domain.UserModel{
+		ID:          primitive.NewObjectID(),
+		UserName:    "mover",
+		Coordinates: &types.Coordinate{Latitude: 51.11822470712269, Longitude: 16.990711729269563},
+	}
+	if _, err := repo.CreateUser(ctx, user, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	warsaw := &types.Coordinate{Latitude: 52.23553956649786, Longitude: 20.984595191389918}
+	if _, err := repo.UpdateUser(ctx, "mover", warsaw, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if result := searchAll(t, repo, &domain.CircleArea{Location: user.Coordinates, Distance: 5}); len(result) != 0 {
+		t.Errorf("expected no users at the old location, got %d", len(result))
+	}
+
+	if result := searchAll(t, repo, &domain.CircleArea{Location: warsaw, Distance: 5}); len(result) != 1 {
+		t.Errorf("expected the user at the new location, got %d users", len(result))
+	}
+}
+
+func TestInmemRepository_CreateUserRejectsTakenUserName(t *testing.T) {
+	ctx := context.Background()
+	repo := newEmptyInmemRepository()
+
+	coordinates := &types.Coordinate{Latitude: 51.11822470712269, Longitude: 16.990711729269563}
+	if _, err := repo.CreateUser(ctx, &domain.UserModel{ID: primitive.NewObjectID(), UserName: "twin", Coordinates: coordinates}, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := repo.CreateUser(ctx, &domain.UserModel{ID: primitive.NewObjectID(), UserName: "twin", Coordinates: coordinates}, nil); !errors.Is(err, domain.ErrUserNameTaken) {
+		t.Errorf("expected %v, got %v", domain.ErrUserNameTaken, err)
+	}
+
+	users, _ := repo.GetUsers(ctx)
+	if len(users) != 1 {
+		t.Errorf("expected 1 stored user, got %d", len(users))
+	}
+	events, _ := repo.PendingEvents(ctx, 10)
+	if len(events) != 2 {
+		t.Errorf("expected only the events of the first user, got %d", len(events))
+	}
+}
+
+func TestInmemRepository_DeleteUserRemovesIndexCell(t *testing.T) {
+	ctx := context.Background()
+	repo := newEmptyInmemRepository()
+
+	user := &domain.UserModel{
+		ID:          primitive.NewObjectID(),
+		UserName:    "leaver",
+		Coordinates: &types.Coordinate{Latitude: 51.11822470712269, Longitude: 16.990711729269563},
+	}
+	if _, err := repo.CreateUser(ctx, user, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	deleted, err := repo.DeleteUser(ctx, domain.UserKey{UserName: "leaver"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if deleted.ID != user.ID {
+		t.Errorf("expected the deleted user %s, got %s", user.ID.Hex(), deleted.ID.Hex())
+	}
+
+	if _, err := repo.GetUser(ctx, domain.UserKey{ID: user.ID}); !errors.Is(err, domain.ErrUserNotFound) {
+		t.Errorf("expected %v after delete, got %v", domain.ErrUserNotFound, err)
+	}
+	if result := searchAll(t, repo, &domain.CircleArea{Location: user.Coordinates, Distance: 5}); len(result) != 0 {
+		t.Errorf("expected the deleted user to be gone from the index, got %d users", len(result))
+	}
+	if _, err := repo.DeleteUser(ctx, domain.UserKey{UserName: "leaver"}); !errors.Is(err, domain.ErrUserNotFound) {
+		t.Errorf("expected %v for a second delete, got %v", domain.ErrUserNotFound, err)
+	}
+
+	// the unpublished events of the user are replaced by the deletion
+	events, err := repo.PendingEvents(ctx, 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(events) != 1 || events[0].RoutingKey != contracts.UserEventDeleted {
+		t.Fatalf("expected only the user deleted event, got %d events", len(events))
+	}
+	if events[0].OwnerID != user.ID.Hex() {
+		t.Errorf("expected the event of user %s, got %s", user.ID.Hex(), events[0].OwnerID)
+	}
+}
+
+func TestInmemRepository_NearestUsersMatchesFullScan(t *testing.T) {
+	ctx := context.Background()
+	repo := newEmptyInmemRepository()
+	rnd := rand.New(rand.NewSource(2))
+
+	// a dense city and a few sparse users far away from it
+	var users []*domain.UserModel
+	for i := 0; i < 1000; i++ {
+		lat := 51.1 + rnd.Float64()*0.1
+		lon := 16.9 + rnd.Float64()*0.2
+		if i%50 == 0 {
+			lat = rnd.Float64()*160 - 80
+			lon = rnd.Float64()*360 - 180
+		}
+		user := &domain.UserModel{
+			ID:          primitive.NewObjectID(),
+			UserName:    fmt.Sprintf("user%d", i),
+			Coordinates: &types.Coordinate{Latitude: lat, Longitude: lon},
+		}
+		users = append(users, user)
+		if _, err := repo.CreateUser(ctx, user, nil); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		location *types.Coordinate
+		k        int
+	}{
+		{name: "inside the city", location: &types.Coordinate{Latitude: 51.15, Longitude: 17.0}, k: 10},
+		{name: "single user", location: &types.Coordinate{Latitude: 51.15, Longitude: 17.0}, k: 1},
+		{name: "next to the city", location: &types.Coordinate{Latitude: 51.5, Longitude: 17.5}, k: 25},
+		{name: "far from everyone", location: &types.Coordinate{Latitude: -40, Longitude: 170}, k: 5},
+		{name: "antimeridian", location: &types.Coordinate{Latitude: 0, Longitude: -179.99}, k: 3},
+		{name: "more than stored", location: &types.Coordinate{Latitude: 0, Longitude: 0}, k: 2000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expected := make([]float64, 0, len(users))
+			for _, user := range users {
+				expected = append(expected, util.CalculateDistance(tt.location, user.Coordinates))
+			}
+			sort.Float64s(expected)
+			if len(expected) > tt.k {
+				expected = expected[:tt.k]
+			}
+
+			result, err := repo.NearestUsers(ctx, tt.location, tt.k)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(result) != len(expected) {
+				t.Fatalf("expected %d users, got %d", len(expected), len(result))
+			}
+			for i, user := range result {
+				if math.Abs(user.Distance-expected[i]) > 1e-9 {
+					t.Errorf("expected distance %.6f at position %d, got %.6f", expected[i], i, user.Distance)
+				}
+			}
+		})
+	}
+}
+
+func TestInmemRepository_OutboxEvents(t *testing.T) {
+	ctx := context.Background()
+	repo := newEmptyInmemRepository()
+
+	user := &domain.UserModel{
+		UserName:    "mover",
+		Coordinates: &types.Coordinate{Latitude: 51.11822470712269, Longitude: 16.990711729269563},
+	}
+	created, err := repo.CreateUser(ctx, user, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if created.ID.IsZero() {
+		t.Errorf("expected the created user to get an ID")
+	}
+
+	warsaw := &types.Coordinate{Latitude: 52.23553956649786, Longitude: 20.984595191389918}
+	if _, err := repo.UpdateUser(ctx, "mover", warsaw, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := repo.UpdateUser(ctx, "unknown", warsaw, nil); !errors.Is(err, domain.ErrUserNotFound) {
+		t.Errorf("expected %v for an unknown user, got %v", domain.ErrUserNotFound, err)
+	}
+
+	events, err := repo.PendingEvents(ctx, 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the user created and location events of the new user, then the location event of the update
+	if len(events) != 3 {
+		t.Fatalf("expected 3 pending events, got %d", len(events))
+	}
+	expectedKeys := []string{contracts.UserEventCreated, contracts.UserEventLocationRegistered, contracts.UserEventLocationRegistered}
+	for i, event := range events {
+		if event.RoutingKey != expectedKeys[i] {
+			t.Errorf("expected routing key %s at position %d, got %s", expectedKeys[i], i, event.RoutingKey)
+		}
+		if event.OwnerID != created.ID.Hex() {
+			t.Errorf("expected owner %s, got %s", created.ID.Hex(), event.OwnerID)
+		}
+	}
+
+	if err := repo.RecordFailure(ctx, events[0].ID, fmt.Errorf("broker unavailable")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := repo.DeleteEvent(ctx, events[1].ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	events, _ = repo.PendingEvents(ctx, 10)
+	if len(events) != 2 {
+		t.Fatalf("expected 2 pending events, got %d", len(events))
+	}
+	if events[0].Attempts != 1 || events[0].LastError != "broker unavailable" {
+		t.Errorf("expected the failure to be recorded, got %d attempts and error %q", events[0].Attempts, events[0].LastError)
+	}
+}
@@ -2,15 +2,19 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"go-clinet-locations/services/user-service/internal/domain"
 	"go-clinet-locations/shared/db"
+	"go-clinet-locations/shared/messaging"
 	"go-clinet-locations/shared/types"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"strings"
 	"sync"
+	"time"
 )
 
 type mongoRepository struct {
@@ -18,49 +22,248 @@ type mongoRepository struct {
 	mu sync.RWMutex
 }
 
+// userDocument is the stored shape of a user. Coordinates are kept for the existing readers,
+// location duplicates them as a GeoJSON point so the 2dsphere index can be used for searching.
+type userDocument struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty"`
+	UserName    string             `bson:"userName"`
+	Coordinates *types.Coordinate  `bson:"coordinates"`
+	Location    *geoJSONPoint      `bson:"location"`
+}
+
+type geoJSONPoint struct {
+	Type        string    `bson:"type"`
+	Coordinates []float64 `bson:"coordinates"` // [longitude, latitude]
+}
+
+func newGeoJSONPoint(coordinates *types.Coordinate) *geoJSONPoint {
+	return &geoJSONPoint{
+		Type:        "Point",
+		Coordinates: []float64{coordinates.Longitude, coordinates.Latitude},
+	}
+}
+
+// ErrDuplicateUserNames is returned by EnsureIndexes while users share a userName, the unique index is not created then
+var ErrDuplicateUserNames = errors.New("userNames stored more than once")
+
 func NewMongoRepository(db *mongo.Database) *mongoRepository {
 	return &mongoRepository{db: db}
 }
 
-func (r *mongoRepository) CreateUser(ctx context.Context, user *domain.UserModel) (*domain.UserModel, error) {
-	result, err := r.db.Collection(db.UserCollection).InsertOne(ctx, user)
+// EnsureIndexes creates the 2dsphere index used by the radius search and the unique userName index.
+// While duplicated userNames are stored it returns ErrDuplicateUserNames listing them, they have to be renamed first.
+func (r *mongoRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.db.Collection(db.UserCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "location", Value: "2dsphere"}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create location index: %w", err)
+	}
 
+	_, err = r.db.Collection(db.UserCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "userName", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		duplicates, reportErr := r.DuplicateUserNames(ctx)
+		if reportErr != nil {
+			return fmt.Errorf("%w: %v", ErrDuplicateUserNames, reportErr)
+		}
+		return fmt.Errorf("%w: %s", ErrDuplicateUserNames, strings.Join(duplicates, ", "))
+	}
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("failed to create userName index: %w", err)
+	}
+
+	return nil
+}
+
+// DuplicateUserNames returns the userNames stored for more than one user
+func (r *mongoRepository) DuplicateUserNames(ctx context.Context) ([]string, error) {
+	cursor, err := r.db.Collection(db.UserCollection).Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{"_id": "$userName", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find duplicate userNames: %w", err)
 	}
+	defer cursor.Close(ctx)
 
-	user.ID = result.InsertedID.(primitive.ObjectID)
+	var groups []struct {
+		UserName string `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, fmt.Errorf("failed to find duplicate userNames: %w", err)
+	}
+
+	userNames := make([]string, 0, len(groups))
+	for _, group := range groups {
+		userNames = append(userNames, group.UserName)
+	}
+	return userNames, nil
+}
+
+// MigrateLocations fills the GeoJSON location of users stored with the old coordinates{latitude,longitude} layout only.
+// It is safe to run on every start, already migrated users are not touched.
+func (r *mongoRepository) MigrateLocations(ctx context.Context) (int64, error) {
+	filter := bson.M{
+		"location":    bson.M{"$exists": false},
+		"coordinates": bson.M{"$type": "object"},
+	}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"location": bson.M{
+				"type":        "Point",
+				"coordinates": bson.A{"$coordinates.longitude", "$coordinates.latitude"},
+			},
+		}}},
+	}
+
+	result, err := r.db.Collection(db.UserCollection).UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, fmt.Errorf("failed to migrate user locations: %w", err)
+	}
+
+	return result.ModifiedCount, nil
+}
+
+func (r *mongoRepository) CreateUser(ctx context.Context, user *domain.UserModel, recordedAt *time.Time) (*domain.UserModel, error) {
+	err := r.withTransaction(ctx, func(sc mongo.SessionContext) error {
+		result, err := r.db.Collection(db.UserCollection).InsertOne(sc, &userDocument{
+			ID:          user.ID,
+			UserName:    user.UserName,
+			Coordinates: user.Coordinates,
+			Location:    newGeoJSONPoint(user.Coordinates),
+		})
+		if mongo.IsDuplicateKeyError(err) {
+			return domain.ErrUserNameTaken
+		}
+		if err != nil {
+			return err
+		}
+
+		user.ID = result.InsertedID.(primitive.ObjectID)
+
+		createdEvent, err := domain.NewUserCreatedEvent(user)
+		if err != nil {
+			return fmt.Errorf("failed to create user created event: %w", err)
+		}
+		locationEvent, err := domain.NewUserLocationEvent(user, recordedAt)
+		if err != nil {
+			return fmt.Errorf("failed to create location event: %w", err)
+		}
+
+		return r.insertEvents(sc, createdEvent, locationEvent)
+	})
+	if err != nil {
+		return nil, err
+	}
 
 	return user, nil
 }
-func (r *mongoRepository) UpdateUser(ctx context.Context, userName string, coordinates *types.Coordinate) (*domain.UserModel, error) {
+func (r *mongoRepository) UpdateUser(ctx context.Context, userName string, coordinates *types.Coordinate, recordedAt *time.Time) (*domain.UserModel, error) {
 	collection := r.db.Collection(db.UserCollection)
 	filter := bson.M{"userName": userName}
-	update := bson.M{"$set": bson.M{"coordinates": bson.M{"latitude": coordinates.Latitude, "longitude": coordinates.Longitude}}}
+	update := bson.M{"$set": bson.M{
+		"coordinates": bson.M{"latitude": coordinates.Latitude, "longitude": coordinates.Longitude},
+		"location":    newGeoJSONPoint(coordinates),
+	}}
+
+	var updatedUser domain.UserModel
+	err := r.withTransaction(ctx, func(sc mongo.SessionContext) error {
+		result := collection.FindOneAndUpdate(sc, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After))
+		if result.Err() != nil {
+			// Check if the error is "no documents in result" which means user doesn't exist
+			if result.Err() == mongo.ErrNoDocuments {
+				return domain.ErrUserNotFound
+			}
+			return fmt.Errorf("failed to update user: %w", result.Err())
+		}
 
-	result := collection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After))
-	if result.Err() != nil {
-		// Check if the error is "no documents in result" which means user doesn't exist
-		if result.Err() == mongo.ErrNoDocuments {
-			return nil, fmt.Errorf("user not found")
+		if err := result.Decode(&updatedUser); err != nil {
+			return fmt.Errorf("failed to decode updated user: %w", err)
 		}
-		return nil, fmt.Errorf("failed to update user: %v", result.Err())
-	}
 
-	var updatedUser domain.UserModel
-	if err := result.Decode(&updatedUser); err != nil {
-		return nil, fmt.Errorf("failed to decode updated user: %v", err)
+		event, err := domain.NewUserLocationEvent(&updatedUser, recordedAt)
+		if err != nil {
+			return fmt.Errorf("failed to create location event: %w", err)
+		}
+
+		return r.insertEvents(sc, event)
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return &updatedUser, nil
 }
 
+// withTransaction runs fn in a transaction, so the user and its outbox event are written together or not at all
+func (r *mongoRepository) withTransaction(ctx context.Context, fn func(sc mongo.SessionContext) error) error {
+	session, err := r.db.Client().StartSession()
+	if err != nil {
+		return fmt.Errorf("failed to start session: %w", err)
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
+// insertEvents writes the events to the outbox in the given order
+func (r *mongoRepository) insertEvents(sc mongo.SessionContext, events ...*messaging.OutboxEvent) error {
+	for _, event := range events {
+		if _, err := r.db.Collection(db.UserOutboxCollection).InsertOne(sc, event); err != nil {
+			return fmt.Errorf("failed to store event: %w", err)
+		}
+	}
+	return nil
+}
+
+func (r *mongoRepository) PendingEvents(ctx context.Context, limit int) ([]*messaging.OutboxEvent, error) {
+	// ObjectIDs grow with time, so sorting by _id delivers the events in the order they were written
+	cursor, err := r.db.Collection(db.UserOutboxCollection).Find(ctx, bson.M{},
+		options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(limit)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pending events: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var events []*messaging.OutboxEvent
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, fmt.Errorf("failed to decode pending events: %w", err)
+	}
+	return events, nil
+}
+
+func (r *mongoRepository) DeleteEvent(ctx context.Context, id primitive.ObjectID) error {
+	if _, err := r.db.Collection(db.UserOutboxCollection).DeleteOne(ctx, bson.M{"_id": id}); err != nil {
+		return fmt.Errorf("failed to delete event: %w", err)
+	}
+	return nil
+}
+
+func (r *mongoRepository) RecordFailure(ctx context.Context, id primitive.ObjectID, cause error) error {
+	update := bson.M{
+		"$inc": bson.M{"attempts": 1},
+		"$set": bson.M{"lastError": cause.Error()},
+	}
+	if _, err := r.db.Collection(db.UserOutboxCollection).UpdateOne(ctx, bson.M{"_id": id}, update); err != nil {
+		return fmt.Errorf("failed to record event failure: %w", err)
+	}
+	return nil
+}
+
 func (r *mongoRepository) GetUsers(ctx context.Context) ([]*domain.UserModel, error) {
 	collection := r.db.Collection(db.UserCollection)
 
-	cursor, err := collection.Find(ctx, bson.M{})
+	// sort by _id so paginated search results are stable between requests
+	cursor, err := collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
 	if err != nil {
-		return nil, fmt.Errorf("failed to get users: %v", err)
+		return nil, fmt.Errorf("failed to get users: %w", err)
 	}
 	defer cursor.Close(ctx)
 
@@ -68,7 +271,7 @@ func (r *mongoRepository) GetUsers(ctx context.Context) ([]*domain.UserModel, er
 	for cursor.Next(ctx) {
 		var user domain.UserModel
 		if err := cursor.Decode(&user); err != nil {
-			return nil, fmt.Errorf("failed to decode user: %v", err)
+			return nil, fmt.Errorf("failed to decode user: %w", err)
 		}
 		users = append(users, &domain.UserModel{
 			ID:       user.ID,
@@ -81,9 +284,226 @@ func (r *mongoRepository) GetUsers(ctx context.Context) ([]*domain.UserModel, er
 	}
 
 	if err := cursor.Err(); err != nil {
-		return nil, fmt.Errorf("cursor error: %v", err)
+		return nil, fmt.Errorf("cursor error: %w", err)
 	}
 
 	return users, nil
 
 }
+
+func (r *mongoRepository) SearchUsers(ctx context.Context, area domain.SearchArea, page domain.SearchPage) ([]*domain.UserModel, string, error) {
+	collection := r.db.Collection(db.UserCollection)
+
+	// $geoNear uses the 2dsphere index to visit only the circle enclosing the area
+	// and adds the distance in km from the area center to every user
+	geoNear := bson.M{
+		"near":               newGeoJSONPoint(area.Center()),
+		"key":                "location",
+		"distanceField":      "distance",
+		"maxDistance":        area.Radius() * 1000,
+		"distanceMultiplier": 0.001,
+		"spherical":          true,
+	}
+	if query := areaQuery(area); query != nil {
+		// Mongo measures on a slightly larger sphere, keep a margin so the exact query decides about the edges
+		geoNear["maxDistance"] = area.Radius() * 1000 * 1.01
+		geoNear["query"] = query
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$geoNear", Value: geoNear}},
+	}
+
+	sortKey := "distance"
+	if page.Sort == domain.SortByUserName {
+		sortKey = "userName"
+	}
+
+	if page.Cursor != "" {
+		cursor, err := domain.DecodeCursor(page.Cursor, page.Sort)
+		if err != nil {
+			return nil, "", err
+		}
+
+		var lastValue any = cursor.Distance
+		if page.Sort == domain.SortByUserName {
+			lastValue = cursor.UserName
+		}
+
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.M{
+			"$or": bson.A{
+				bson.M{sortKey: bson.M{"$gt": lastValue}},
+				bson.M{sortKey: lastValue, "_id": bson.M{"$gt": cursor.ID}},
+			},
+		}}})
+	}
+
+	// one extra user tells whether there is a next page
+	pipeline = append(pipeline,
+		bson.D{{Key: "$sort", Value: bson.D{{Key: sortKey, Value: 1}, {Key: "_id", Value: 1}}}},
+		bson.D{{Key: "$limit", Value: page.Limit + 1}},
+	)
+
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to search users: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var users []*domain.UserModel
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, "", fmt.Errorf("failed to decode users: %w", err)
+	}
+
+	return domain.PaginateUsers(users, domain.SearchPage{Limit: page.Limit, Sort: page.Sort})
+}
+
+func (r *mongoRepository) GetUser(ctx context.Context, key domain.UserKey) (*domain.UserModel, error) {
+	var user domain.UserModel
+	err := r.db.Collection(db.UserCollection).FindOne(ctx, userKeyFilter(key)).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, domain.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	return &user, nil
+}
+
+// ListUsers pages through the users by userName, ties are broken by _id like in the search
+func (r *mongoRepository) ListUsers(ctx context.Context, page domain.SearchPage) ([]*domain.UserModel, string, error) {
+	page.Sort = domain.SortByUserName
+
+	filter := bson.M{}
+	if page.Cursor != "" {
+		cursor, err := domain.DecodeCursor(page.Cursor, page.Sort)
+		if err != nil {
+			return nil, "", err
+		}
+
+		filter = bson.M{"$or": bson.A{
+			bson.M{"userName": bson.M{"$gt": cursor.UserName}},
+			bson.M{"userName": cursor.UserName, "_id": bson.M{"$gt": cursor.ID}},
+		}}
+	}
+
+	// one extra user tells whether there is a next page
+	opts := options.Find().
+		SetSort(bson.D{{Key: "userName", Value: 1}, {Key: "_id", Value: 1}}).
+		SetLimit(int64(page.Limit + 1))
+
+	cursor, err := r.db.Collection(db.UserCollection).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list users: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var users []*domain.UserModel
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, "", fmt.Errorf("failed to decode users: %w", err)
+	}
+
+	return domain.PaginateUsers(users, domain.SearchPage{Limit: page.Limit, Sort: page.Sort})
+}
+
+func (r *mongoRepository) DeleteUser(ctx context.Context, key domain.UserKey) (*domain.UserModel, error) {
+	var user domain.UserModel
+	err := r.withTransaction(ctx, func(sc mongo.SessionContext) error {
+		err := r.db.Collection(db.UserCollection).FindOneAndDelete(sc, userKeyFilter(key)).Decode(&user)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return domain.ErrUserNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to delete user: %w", err)
+		}
+
+		if _, err := r.db.Collection(db.UserOutboxCollection).DeleteMany(sc, bson.M{"ownerId": user.ID.Hex()}); err != nil {
+			return fmt.Errorf("failed to drop pending events: %w", err)
+		}
+
+		event, err := domain.NewUserDeletedEvent(&user)
+		if err != nil {
+			return fmt.Errorf("failed to create user deleted event: %w", err)
+		}
+
+		return r.insertEvents(sc, event)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func userKeyFilter(key domain.UserKey) bson.M {
+	if !key.ID.IsZero() {
+		return bson.M{"_id": key.ID}
+	}
+	return bson.M{"userName": key.UserName}
+}
+
+// areaQuery narrows the circle searched by $geoNear down to the exact area, circles need no extra query
+func areaQuery(area domain.SearchArea) bson.M {
+	switch a := area.(type) {
+	case *domain.BoundingBoxArea:
+		query := bson.M{
+			"coordinates.latitude": bson.M{"$gte": a.SouthWest.Latitude, "$lte": a.NorthEast.Latitude},
+		}
+		if a.SouthWest.Longitude <= a.NorthEast.Longitude {
+			query["coordinates.longitude"] = bson.M{"$gte": a.SouthWest.Longitude, "$lte": a.NorthEast.Longitude}
+		} else {
+			// the box crosses the antimeridian
+			query["$or"] = bson.A{
+				bson.M{"coordinates.longitude": bson.M{"$gte": a.SouthWest.Longitude}},
+				bson.M{"coordinates.longitude": bson.M{"$lte": a.NorthEast.Longitude}},
+			}
+		}
+		return query
+	case *domain.PolygonArea:
+		ring := bson.A{}
+		for _, vertex := range a.Vertices {
+			ring = append(ring, bson.A{vertex.Longitude, vertex.Latitude})
+		}
+		// GeoJSON rings have to be closed
+		first, last := a.Vertices[0], a.Vertices[len(a.Vertices)-1]
+		if *first != *last {
+			ring = append(ring, bson.A{first.Longitude, first.Latitude})
+		}
+
+		return bson.M{"location": bson.M{"$geoWithin": bson.M{
+			"$geometry": bson.M{"type": "Polygon", "coordinates": bson.A{ring}},
+		}}}
+	default:
+		return nil
+	}
+}
+
+func (r *mongoRepository) NearestUsers(ctx context.Context, location *types.Coordinate, k int) ([]*domain.UserModel, error) {
+	collection := r.db.Collection(db.UserCollection)
+
+	// without maxDistance $geoNear walks the 2dsphere index outwards until k users are found
+	pipeline := mongo.Pipeline{
+		{{Key: "$geoNear", Value: bson.M{
+			"near":               newGeoJSONPoint(location),
+			"key":                "location",
+			"distanceField":      "distance",
+			"distanceMultiplier": 0.001,
+			"spherical":          true,
+		}}},
+		{{Key: "$limit", Value: k}},
+	}
+
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find nearest users: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var users []*domain.UserModel
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, fmt.Errorf("failed to decode users: %w", err)
+	}
+
+	return users, nil
+}
@@ -4,8 +4,8 @@ import (
 	"context"
 	"go-clinet-locations/services/user-service/internal/domain"
 	"go-clinet-locations/shared/types"
-	"go-clinet-locations/shared/util"
-	"log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
 )
 
 type service struct {
@@ -18,33 +18,64 @@ func NewService(repo domain.UserRepository) *service {
 	}
 }
 
-func (s *service) CreateUser(ctx context.Context, user *domain.UserModel) (*domain.UserModel, error) {
+func (s *service) CreateUser(ctx context.Context, user *domain.UserModel, recordedAt *time.Time) (*domain.UserModel, error) {
+	// the ID is assigned here, so every repository stores the user under its final ID
 	newUser := &domain.UserModel{
+		ID:          primitive.NewObjectID(),
 		UserName:    user.UserName,
 		Coordinates: user.Coordinates,
 	}
-	return s.repo.CreateUser(ctx, newUser)
+	return s.repo.CreateUser(ctx, newUser, recordedAt)
 }
-func (s *service) UpdateUser(ctx context.Context, userName string, coordinates *types.Coordinate) (*domain.UserModel, error) {
+func (s *service) UpdateUser(ctx context.Context, userName string, coordinates *types.Coordinate, recordedAt *time.Time) (*domain.UserModel, error) {
 
-	return s.repo.UpdateUser(ctx, userName, coordinates)
+	return s.repo.UpdateUser(ctx, userName, coordinates, recordedAt)
 }
 
-func (s *service) SearchUsers(ctx context.Context, location *types.Coordinate, radius float64) ([]*domain.UserModel, error) {
-	users, err := s.repo.GetUsers(ctx)
-	if err != nil {
-		log.Fatalf("faled to get users: %v", err)
+func (s *service) SearchUsers(ctx context.Context, area domain.SearchArea, page domain.SearchPage) ([]*domain.UserModel, string, error) {
+	if err := domain.ValidateArea(area); err != nil {
+		return nil, "", err
+	}
+
+	return s.repo.SearchUsers(ctx, area, page.Normalize())
+}
+
+func (s *service) NearestUsers(ctx context.Context, location *types.Coordinate, k int) ([]*domain.UserModel, error) {
+	if k <= 0 {
+		k = domain.DefaultNearestUsers
+	}
+	if k > domain.MaxNearestUsers {
+		k = domain.MaxNearestUsers
+	}
+
+	return s.repo.NearestUsers(ctx, location, k)
+}
+
+func (s *service) GetUser(ctx context.Context, key domain.UserKey) (*domain.UserModel, error) {
+	if err := validateUserKey(key); err != nil {
 		return nil, err
 	}
 
-	var filteredUsers []*domain.UserModel
-	for _, user := range users {
-		distance := util.CalculateDistance(location, user.Coordinates)
-		log.Println(distance, user.UserName)
-		if distance <= radius {
-			filteredUsers = append(filteredUsers, user)
-		}
+	return s.repo.GetUser(ctx, key)
+}
+
+func (s *service) ListUsers(ctx context.Context, page domain.SearchPage) ([]*domain.UserModel, string, error) {
+	page.Sort = domain.SortByUserName
+
+	return s.repo.ListUsers(ctx, page.Normalize())
+}
+
+func (s *service) DeleteUser(ctx context.Context, key domain.UserKey) (*domain.UserModel, error) {
+	if err := validateUserKey(key); err != nil {
+		return nil, err
 	}
 
-	return filteredUsers, nil
+	return s.repo.DeleteUser(ctx, key)
+}
+
+func validateUserKey(key domain.UserKey) error {
+	if key.ID.IsZero() == (key.UserName == "") {
+		return domain.ErrInvalidUserKey
+	}
+	return nil
 }
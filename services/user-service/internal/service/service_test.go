@@ -2,10 +2,20 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	amqp "github.com/rabbitmq/amqp091-go"
 	"go-clinet-locations/services/user-service/internal/domain"
 	"go-clinet-locations/services/user-service/internal/testutil"
+	"go-clinet-locations/shared/messaging"
+	pbevents "go-clinet-locations/shared/proto/events"
 	"go-clinet-locations/shared/types"
+	"go-clinet-locations/shared/util"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"math"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestService_CreateUser(t *testing.T) {
@@ -44,7 +54,7 @@ func TestService_CreateUser(t *testing.T) {
 			mockRepo := testutil.NewMockUserRepository()
 			service := NewService(mockRepo)
 
-			result, err := service.CreateUser(ctx, tt.user)
+			result, err := service.CreateUser(ctx, tt.user, nil)
 
 			if tt.expectError {
 				if err == nil {
@@ -65,6 +75,30 @@ func TestService_CreateUser(t *testing.T) {
 	}
 }
 
+func TestService_CreateUser_AssignsIDAndRejectsTakenUserName(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := testutil.NewMockUserRepository()
+	service := NewService(mockRepo)
+
+	coordinate := testutil.CreateTestCoordinate(51.11822470712269, 16.990711729269563)
+	first, err := service.CreateUser(ctx, &domain.UserModel{UserName: "testuser", Coordinates: coordinate}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if first.ID.IsZero() {
+		t.Errorf("expected the service to assign an ID")
+	}
+
+	if _, err := service.CreateUser(ctx, &domain.UserModel{UserName: "testuser", Coordinates: coordinate}, nil); !errors.Is(err, domain.ErrUserNameTaken) {
+		t.Errorf("expected %v, got %v", domain.ErrUserNameTaken, err)
+	}
+
+	users, _ := mockRepo.GetUsers(ctx)
+	if len(users) != 1 {
+		t.Errorf("expected 1 stored user, got %d", len(users))
+	}
+}
+
 func TestService_UpdateUser(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -106,7 +140,7 @@ func TestService_UpdateUser(t *testing.T) {
 			mockRepo.SetUsers(tt.setupUsers)
 			service := NewService(mockRepo)
 
-			result, err := service.UpdateUser(ctx, tt.username, tt.coordinates)
+			result, err := service.UpdateUser(ctx, tt.username, tt.coordinates, nil)
 
 			if tt.expectError {
 				if err == nil {
@@ -206,7 +240,7 @@ func TestService_SearchUsers(t *testing.T) {
 			mockRepo.SetUsers(tt.setupUsers)
 			service := NewService(mockRepo)
 
-			result, err := service.SearchUsers(ctx, tt.location, tt.radius)
+			result, _, err := service.SearchUsers(ctx, &domain.CircleArea{Location: tt.location, Distance: tt.radius}, domain.SearchPage{})
 
 			if tt.expectError {
 				if err == nil {
@@ -223,3 +257,493 @@ func TestService_SearchUsers(t *testing.T) {
 		})
 	}
 }
+
+func TestService_SearchUsers_Pagination(t *testing.T) {
+	ctx := context.Background()
+	location := testutil.CreateTestCoordinate(51.11822470712269, 16.990711729269563)
+
+	var setupUsers []*domain.UserModel
+	for i := 0; i < 7; i++ {
+		setupUsers = append(setupUsers, testutil.CreateTestUser(fmt.Sprintf("user%d", i), 51.118+float64(i)*0.001, 16.99))
+	}
+	// user far away from the search location must never show up on any page
+	setupUsers = append(setupUsers, testutil.CreateTestUser("faraway", 52.23553956649786, 20.984595191389918))
+
+	mockRepo := testutil.NewMockUserRepository()
+	mockRepo.SetUsers(setupUsers)
+	service := NewService(mockRepo)
+
+	seen := make(map[string]bool)
+	var pages int
+	cursor := ""
+	for {
+		result, next, err := service.SearchUsers(ctx, &domain.CircleArea{Location: location, Distance: 10.0}, domain.SearchPage{Limit: 3, Cursor: cursor})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(result) > 3 {
+			t.Errorf("expected at most 3 users per page, got %d", len(result))
+		}
+		for _, user := range result {
+			if seen[user.ID.Hex()] {
+				t.Errorf("user %s returned on more than one page", user.UserName)
+			}
+			if user.UserName == "faraway" {
+				t.Errorf("user outside of the radius returned")
+			}
+			seen[user.ID.Hex()] = true
+		}
+
+		pages++
+		if next == "" {
+			break
+		}
+		if pages > 10 {
+			t.Fatalf("pagination did not terminate")
+		}
+		cursor = next
+	}
+
+	if len(seen) != 7 {
+		t.Errorf("expected 7 users across all pages, got %d", len(seen))
+	}
+	if pages != 3 {
+		t.Errorf("expected 3 pages, got %d", pages)
+	}
+}
+
+func TestService_SearchUsers_InvalidCursor(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := testutil.NewMockUserRepository()
+	mockRepo.SetUsers([]*domain.UserModel{
+		testutil.CreateTestUser("user1", 51.11822470712269, 16.990711729269563),
+	})
+	service := NewService(mockRepo)
+
+	location := testutil.CreateTestCoordinate(51.11822470712269, 16.990711729269563)
+	_, _, err := service.SearchUsers(ctx, &domain.CircleArea{Location: location, Distance: 10.0}, domain.SearchPage{Cursor: "not-a-cursor"})
+	if !errors.Is(err, domain.ErrInvalidCursor) {
+		t.Errorf("expected ErrInvalidCursor, got %v", err)
+	}
+}
+
+func TestService_SearchUsers_Sort(t *testing.T) {
+	ctx := context.Background()
+	location := testutil.CreateTestCoordinate(51.11822470712269, 16.990711729269563)
+
+	mockRepo := testutil.NewMockUserRepository()
+	mockRepo.SetUsers([]*domain.UserModel{
+		testutil.CreateTestUser("anna", 51.11956092410769, 17.05696305051491),  // ~4.5 km away
+		testutil.CreateTestUser("zack", 51.11822470712269, 16.990711729269563), // same location
+		testutil.CreateTestUser("mark", 51.10181370006046, 17.10312341673202),  // ~8 km away
+		testutil.CreateTestUser("bob", 52.23553956649786, 20.984595191389918),  // ~300 km away
+	})
+	service := NewService(mockRepo)
+
+	tests := []struct {
+		name          string
+		sort          domain.SortOrder
+		expectedNames []string
+	}{
+		{
+			name:          "default sort - nearest first",
+			sort:          "",
+			expectedNames: []string{"zack", "anna", "mark"},
+		},
+		{
+			name:          "sort by distance",
+			sort:          domain.SortByDistance,
+			expectedNames: []string{"zack", "anna", "mark"},
+		},
+		{
+			name:          "sort by userName",
+			sort:          domain.SortByUserName,
+			expectedNames: []string{"anna", "mark", "zack"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, _, err := service.SearchUsers(ctx, &domain.CircleArea{Location: location, Distance: 10.0}, domain.SearchPage{Sort: tt.sort})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(result) != len(tt.expectedNames) {
+				t.Fatalf("expected %d users, got %d", len(tt.expectedNames), len(result))
+			}
+			for i, user := range result {
+				if user.UserName != tt.expectedNames[i] {
+					t.Errorf("expected %s at position %d, got %s", tt.expectedNames[i], i, user.UserName)
+				}
+				expectedDistance := util.CalculateDistance(location, user.Coordinates)
+				if math.Abs(user.Distance-expectedDistance) > 0.001 {
+					t.Errorf("expected distance %.3f for %s, got %.3f", expectedDistance, user.UserName, user.Distance)
+				}
+			}
+		})
+	}
+}
+
+func TestService_SearchUsers_CursorOfOtherSort(t *testing.T) {
+	ctx := context.Background()
+	location := testutil.CreateTestCoordinate(51.11822470712269, 16.990711729269563)
+
+	mockRepo := testutil.NewMockUserRepository()
+	mockRepo.SetUsers([]*domain.UserModel{
+		testutil.CreateTestUser("user1", 51.11822470712269, 16.990711729269563),
+		testutil.CreateTestUser("user2", 51.11956092410769, 17.05696305051491),
+	})
+	service := NewService(mockRepo)
+
+	_, next, err := service.SearchUsers(ctx, &domain.CircleArea{Location: location, Distance: 10.0}, domain.SearchPage{Limit: 1, Sort: domain.SortByDistance})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, _, err = service.SearchUsers(ctx, &domain.CircleArea{Location: location, Distance: 10.0}, domain.SearchPage{Limit: 1, Cursor: next, Sort: domain.SortByUserName})
+	if !errors.Is(err, domain.ErrInvalidCursor) {
+		t.Errorf("expected ErrInvalidCursor, got %v", err)
+	}
+}
+
+func TestService_GetUser(t *testing.T) {
+	ctx := context.Background()
+	user := testutil.CreateTestUser("user1", 51.0, 16.0)
+
+	mockRepo := testutil.NewMockUserRepository()
+	mockRepo.SetUsers([]*domain.UserModel{user, testutil.CreateTestUser("user2", 52.0, 17.0)})
+	service := NewService(mockRepo)
+
+	tests := []struct {
+		name          string
+		key           domain.UserKey
+		expectedError error
+	}{
+		{name: "by userName", key: domain.UserKey{UserName: "user1"}},
+		{name: "by ID", key: domain.UserKey{ID: user.ID}},
+		{name: "unknown userName", key: domain.UserKey{UserName: "nobody"}, expectedError: domain.ErrUserNotFound},
+		{name: "unknown ID", key: domain.UserKey{ID: primitive.NewObjectID()}, expectedError: domain.ErrUserNotFound},
+		{name: "empty key", key: domain.UserKey{}, expectedError: domain.ErrInvalidUserKey},
+		{name: "both ID and userName", key: domain.UserKey{ID: user.ID, UserName: "user1"}, expectedError: domain.ErrInvalidUserKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := service.GetUser(ctx, tt.key)
+
+			if tt.expectedError != nil {
+				if !errors.Is(err, tt.expectedError) {
+					t.Errorf("expected %v, got %v", tt.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.ID != user.ID {
+				t.Errorf("expected user %s, got %s", user.ID.Hex(), result.ID.Hex())
+			}
+		})
+	}
+}
+
+func TestService_ListUsers_Pagination(t *testing.T) {
+	ctx := context.Background()
+
+	var setupUsers []*domain.UserModel
+	for _, name := range []string{"zack", "anna", "mark", "bob", "kate"} {
+		setupUsers = append(setupUsers, testutil.CreateTestUser(name, 51.0, 16.0))
+	}
+
+	mockRepo := testutil.NewMockUserRepository()
+	mockRepo.SetUsers(setupUsers)
+	service := NewService(mockRepo)
+
+	var names []string
+	cursor := ""
+	for pages := 1; ; pages++ {
+		result, next, err := service.ListUsers(ctx, domain.SearchPage{Limit: 2, Cursor: cursor})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, user := range result {
+			names = append(names, user.UserName)
+		}
+
+		if next == "" {
+			break
+		}
+		if pages > 5 {
+			t.Fatalf("pagination did not terminate")
+		}
+		cursor = next
+	}
+
+	expected := []string{"anna", "bob", "kate", "mark", "zack"}
+	if fmt.Sprint(names) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+}
+
+func TestService_DeleteUser(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := testutil.NewMockUserRepository()
+	mockRepo.SetUsers([]*domain.UserModel{testutil.CreateTestUser("user1", 51.0, 16.0)})
+	service := NewService(mockRepo)
+
+	if _, err := service.DeleteUser(ctx, domain.UserKey{UserName: "user1"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := service.GetUser(ctx, domain.UserKey{UserName: "user1"}); !errors.Is(err, domain.ErrUserNotFound) {
+		t.Errorf("expected %v after delete, got %v", domain.ErrUserNotFound, err)
+	}
+	if _, err := service.DeleteUser(ctx, domain.UserKey{UserName: "user1"}); !errors.Is(err, domain.ErrUserNotFound) {
+		t.Errorf("expected %v for a second delete, got %v", domain.ErrUserNotFound, err)
+	}
+}
+
+func TestService_NearestUsers(t *testing.T) {
+	ctx := context.Background()
+	location := testutil.CreateTestCoordinate(51.11822470712269, 16.990711729269563)
+
+	var setupUsers []*domain.UserModel
+	for i := 0; i < 15; i++ {
+		setupUsers = append(setupUsers, testutil.CreateTestUser(fmt.Sprintf("user%d", i), 51.118+float64(i)*0.5, 16.99))
+	}
+
+	tests := []struct {
+		name          string
+		k             int
+		expectedCount int
+	}{
+		{name: "default k", k: 0, expectedCount: domain.DefaultNearestUsers},
+		{name: "explicit k", k: 3, expectedCount: 3},
+		{name: "k larger than the number of users", k: 50, expectedCount: 15},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := testutil.NewMockUserRepository()
+			mockRepo.SetUsers(setupUsers)
+			service := NewService(mockRepo)
+
+			result, err := service.NearestUsers(ctx, location, tt.k)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(result) != tt.expectedCount {
+				t.Errorf("expected %d users, got %d", tt.expectedCount, len(result))
+			}
+			for i := 1; i < len(result); i++ {
+				if result[i].Distance < result[i-1].Distance {
+					t.Errorf("users are not ordered nearest first")
+				}
+			}
+		})
+	}
+}
+
+func TestService_SearchUsers_Area(t *testing.T) {
+	ctx := context.Background()
+
+	mockRepo := testutil.NewMockUserRepository()
+	mockRepo.SetUsers([]*domain.UserModel{
+		testutil.CreateTestUser("rynek", 51.10999, 17.03206),
+		testutil.CreateTestUser("dworzec", 51.09823, 17.03665),
+		testutil.CreateTestUser("biskupin", 51.10379, 17.09937),
+		testutil.CreateTestUser("warsaw", 52.23553956649786, 20.984595191389918),
+	})
+	service := NewService(mockRepo)
+
+	tests := []struct {
+		name          string
+		area          domain.SearchArea
+		expectedNames []string
+		expectError   bool
+	}{
+		{
+			name: "bounding box of the old town",
+			area: &domain.BoundingBoxArea{
+				SouthWest: testutil.CreateTestCoordinate(51.095, 17.02),
+				NorthEast: testutil.CreateTestCoordinate(51.115, 17.05),
+			},
+			expectedNames: []string{"dworzec", "rynek"},
+		},
+		{
+			name: "polygon around the east of the city",
+			area: &domain.PolygonArea{Vertices: []*types.Coordinate{
+				testutil.CreateTestCoordinate(51.09, 17.08),
+				testutil.CreateTestCoordinate(51.09, 17.12),
+				testutil.CreateTestCoordinate(51.12, 17.12),
+				testutil.CreateTestCoordinate(51.12, 17.08),
+				testutil.CreateTestCoordinate(51.09, 17.08),
+			}},
+			expectedNames: []string{"biskupin"},
+		},
+		{
+			name: "bounding box with swapped corners",
+			area: &domain.BoundingBoxArea{
+				SouthWest: testutil.CreateTestCoordinate(51.115, 17.02),
+				NorthEast: testutil.CreateTestCoordinate(51.095, 17.05),
+			},
+			expectError: true,
+		},
+		{
+			name: "polygon with two vertices",
+			area: &domain.PolygonArea{Vertices: []*types.Coordinate{
+				testutil.CreateTestCoordinate(51.09, 17.08),
+				testutil.CreateTestCoordinate(51.12, 17.12),
+			}},
+			expectError: true,
+		},
+		{
+			name: "polygon larger than a hemisphere",
+			area: &domain.PolygonArea{Vertices: []*types.Coordinate{
+				testutil.CreateTestCoordinate(0, 0),
+				testutil.CreateTestCoordinate(0, 120),
+				testutil.CreateTestCoordinate(0, -120),
+			}},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, _, err := service.SearchUsers(ctx, tt.area, domain.SearchPage{Sort: domain.SortByUserName})
+			if tt.expectError {
+				if !errors.Is(err, domain.ErrInvalidArea) {
+					t.Errorf("expected ErrInvalidArea, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(result) != len(tt.expectedNames) {
+				t.Fatalf("expected %d users, got %d", len(tt.expectedNames), len(result))
+			}
+			for i, user := range result {
+				if user.UserName != tt.expectedNames[i] {
+					t.Errorf("expected %s at position %d, got %s", tt.expectedNames[i], i, user.UserName)
+				}
+			}
+		})
+	}
+}
+
+// TestService_LocationEventsReachLocationQueue follows the location events from the outbox
+// through the in-memory broker to the queue of the location history
+func TestService_LocationEventsReachLocationQueue(t *testing.T) {
+	ctx := context.Background()
+	broker := messaging.NewInmemBroker()
+
+	var mu sync.Mutex
+	var received []*pbevents.EventEnvelope
+	err := broker.ConsumeMessages(ctx, messaging.SaveUserLocationQueue, func(ctx context.Context, msg amqp.Delivery) error {
+		envelope, err := messaging.DecodeEnvelope(msg.Body)
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, envelope)
+		return nil
+	}, messaging.ConsumerOptions{Workers: 4, OrderByOwner: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	repo := testutil.NewMockUserRepository()
+	service := NewService(repo)
+	user, err := service.CreateUser(ctx, testutil.CreateTestUser("mover", 51.1, 17.0), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	recordedAt := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	for i := 1; i <= 3; i++ {
+		if _, err := service.UpdateUser(ctx, "mover", testutil.CreateTestCoordinate(51.1+float64(i)*0.01, 17.0), &recordedAt); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if _, err := messaging.NewOutboxRelay(repo, broker).RelayPending(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	broker.WaitIdle()
+
+	if len(repo.Events()) != 0 {
+		t.Errorf("expected the outbox to be empty, got %d events", len(repo.Events()))
+	}
+
+	// the user created event is not routed to the location queue, the locations arrive in order
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 4 {
+		t.Fatalf("expected 4 location events, got %d", len(received))
+	}
+	for i, envelope := range received {
+		if envelope.GetProducer() != domain.EventProducer || envelope.GetOwnerId() != user.ID.Hex() {
+			t.Errorf("expected producer %s and owner %s, got %s and %s", domain.EventProducer, user.ID.Hex(), envelope.GetProducer(), envelope.GetOwnerId())
+		}
+		var event pbevents.UserLocationRegistered
+		if err := messaging.UnpackEvent(envelope, &event); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expectedLatitude := 51.1 + float64(i)*0.01
+		if event.GetCoordinate().GetLatitude() != expectedLatitude {
+			t.Errorf("expected latitude %.2f at position %d, got %.2f", expectedLatitude, i, event.GetCoordinate().GetLatitude())
+		}
+		if i == 0 && event.GetRecordedAt() != nil {
+			t.Errorf("expected no recordedAt for the created user, got %v", event.GetRecordedAt().AsTime())
+		}
+		if i > 0 && !event.GetRecordedAt().AsTime().Equal(recordedAt) {
+			t.Errorf("expected recordedAt %v, got %v", recordedAt, event.GetRecordedAt().AsTime())
+		}
+	}
+}
+
+// TestService_DeletionDropsPendingLocations checks a user deleted before the relay ran
+// only reaches the location queue as a deletion
+func TestService_DeletionDropsPendingLocations(t *testing.T) {
+	ctx := context.Background()
+	broker := messaging.NewInmemBroker()
+
+	var mu sync.Mutex
+	var eventTypes []string
+	err := broker.ConsumeMessages(ctx, messaging.SaveUserLocationQueue, func(ctx context.Context, msg amqp.Delivery) error {
+		envelope, err := messaging.DecodeEnvelope(msg.Body)
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		eventTypes = append(eventTypes, envelope.GetEventType())
+		return nil
+	}, messaging.ConsumerOptions{Workers: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	repo := testutil.NewMockUserRepository()
+	service := NewService(repo)
+	user, err := service.CreateUser(ctx, testutil.CreateTestUser("leaver", 51.1, 17.0), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := service.DeleteUser(ctx, domain.UserKey{ID: user.ID}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := messaging.NewOutboxRelay(repo, broker).RelayPending(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	broker.WaitIdle()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(eventTypes) != 1 || eventTypes[0] != messaging.EventType(&pbevents.UserDeleted{}) {
+		t.Errorf("expected only the user deleted event, got %v", eventTypes)
+	}
+}
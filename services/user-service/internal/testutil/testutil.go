@@ -3,50 +3,158 @@ package testutil
 import (
 	"context"
 	"go-clinet-locations/services/user-service/internal/domain"
+	"go-clinet-locations/shared/messaging"
 	"go-clinet-locations/shared/types"
+	"go-clinet-locations/shared/util"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
 	"time"
 )
 
 // MockUserRepository is a mock implementation of UserRepository for testing
 type MockUserRepository struct {
 	users map[string]*domain.UserModel
+	*messaging.InmemOutbox
 }
 
 // NewMockUserRepository creates a new mock repository
 func NewMockUserRepository() *MockUserRepository {
 	return &MockUserRepository{
-		users: make(map[string]*domain.UserModel),
+		users:       make(map[string]*domain.UserModel),
+		InmemOutbox: messaging.NewInmemOutbox(),
 	}
 }
 
-// CreateUser mocks user creation
-func (m *MockUserRepository) CreateUser(ctx context.Context, user *domain.UserModel) (*domain.UserModel, error) {
-	user.ID = primitive.NewObjectID()
+// CreateUser mocks user creation, userNames are unique
+func (m *MockUserRepository) CreateUser(ctx context.Context, user *domain.UserModel, recordedAt *time.Time) (*domain.UserModel, error) {
+	for _, existing := range m.users {
+		if existing.UserName == user.UserName {
+			return nil, domain.ErrUserNameTaken
+		}
+	}
+
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
 	m.users[user.ID.Hex()] = user
-	return user, nil
+
+	createdEvent, err := domain.NewUserCreatedEvent(user)
+	if err != nil {
+		return nil, err
+	}
+	m.Add(createdEvent)
+
+	return user, m.addLocationEvent(user, recordedAt)
 }
 
 // UpdateUser mocks user update
-func (m *MockUserRepository) UpdateUser(ctx context.Context, userName string, coordinates *types.Coordinate) (*domain.UserModel, error) {
+func (m *MockUserRepository) UpdateUser(ctx context.Context, userName string, coordinates *types.Coordinate, recordedAt *time.Time) (*domain.UserModel, error) {
 	for _, user := range m.users {
 		if user.UserName == userName {
 			user.Coordinates = coordinates
-			return user, nil
+			return user, m.addLocationEvent(user, recordedAt)
 		}
 	}
 	return nil, domain.ErrUserNotFound
 }
 
-// GetUsers mocks getting all users
+// GetUsers mocks getting all users ordered by ID
 func (m *MockUserRepository) GetUsers(ctx context.Context) ([]*domain.UserModel, error) {
 	var users []*domain.UserModel
 	for _, user := range m.users {
 		users = append(users, user)
 	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].ID.Hex() < users[j].ID.Hex()
+	})
 	return users, nil
 }
 
+// SearchUsers mocks the area search
+func (m *MockUserRepository) SearchUsers(ctx context.Context, area domain.SearchArea, page domain.SearchPage) ([]*domain.UserModel, string, error) {
+	users, _ := m.GetUsers(ctx)
+
+	var filteredUsers []*domain.UserModel
+	for _, user := range users {
+		if area.Contains(user.Coordinates) {
+			filteredUsers = append(filteredUsers, &domain.UserModel{
+				ID:          user.ID,
+				UserName:    user.UserName,
+				Coordinates: user.Coordinates,
+				Distance:    util.CalculateDistance(area.Center(), user.Coordinates),
+			})
+		}
+	}
+
+	return domain.PaginateUsers(filteredUsers, page)
+}
+
+// NearestUsers mocks the k nearest users lookup
+func (m *MockUserRepository) NearestUsers(ctx context.Context, location *types.Coordinate, k int) ([]*domain.UserModel, error) {
+	users, _ := m.GetUsers(ctx)
+
+	var result []*domain.UserModel
+	for _, user := range users {
+		result = append(result, &domain.UserModel{
+			ID:          user.ID,
+			UserName:    user.UserName,
+			Coordinates: user.Coordinates,
+			Distance:    util.CalculateDistance(location, user.Coordinates),
+		})
+	}
+
+	domain.SortUsers(result, domain.SortByDistance)
+	if len(result) > k {
+		result = result[:k]
+	}
+	return result, nil
+}
+
+// GetUser mocks the lookup by ID or userName
+func (m *MockUserRepository) GetUser(ctx context.Context, key domain.UserKey) (*domain.UserModel, error) {
+	for _, user := range m.users {
+		if key.Matches(user) {
+			return user, nil
+		}
+	}
+	return nil, domain.ErrUserNotFound
+}
+
+// ListUsers mocks paging through the users by userName
+func (m *MockUserRepository) ListUsers(ctx context.Context, page domain.SearchPage) ([]*domain.UserModel, string, error) {
+	users, _ := m.GetUsers(ctx)
+
+	page.Sort = domain.SortByUserName
+	return domain.PaginateUsers(users, page)
+}
+
+// DeleteUser mocks user removal, the pending events of the user are replaced by the UserDeleted event
+func (m *MockUserRepository) DeleteUser(ctx context.Context, key domain.UserKey) (*domain.UserModel, error) {
+	for id, user := range m.users {
+		if key.Matches(user) {
+			event, err := domain.NewUserDeletedEvent(user)
+			if err != nil {
+				return nil, err
+			}
+
+			delete(m.users, id)
+			m.DropEvents(user.ID.Hex())
+			m.Add(event)
+			return user, nil
+		}
+	}
+	return nil, domain.ErrUserNotFound
+}
+
+func (m *MockUserRepository) addLocationEvent(user *domain.UserModel, recordedAt *time.Time) error {
+	event, err := domain.NewUserLocationEvent(user, recordedAt)
+	if err != nil {
+		return err
+	}
+	m.Add(event)
+	return nil
+}
+
 // SetUsers sets users in the mock repository
 func (m *MockUserRepository) SetUsers(users []*domain.UserModel) {
 	m.users = make(map[string]*domain.UserModel)
@@ -1,32 +1,10 @@
 package contracts
 
-// AmqpMessage is the message structure for AMQP.
-type AmqpMessage struct {
-	OwnerID string `json:"ownerId"`
-	Data    []byte `json:"data"`
-}
-
-// Routing keys - using consistent event/command patterns
+// Routing keys - using consistent event/command patterns.
+// The message of every routing key is an events.EventEnvelope, see proto/events.proto.
 const (
-	// Trip events (trip.event.*)
-	TripEventCreated             = "trip.event.created"
-	TripEventDriverAssigned      = "trip.event.driver_assigned"
-	TripEventNoDriversFound      = "trip.event.no_drivers_found"
-	TripEventDriverNotInterested = "trip.event.driver_not_interested"
-
-	// Driver commands (driver.cmd.*)
-	DriverCmdTripRequest = "driver.cmd.trip_request"
-	DriverCmdTripAccept  = "driver.cmd.trip_accept"
-	DriverCmdTripDecline = "driver.cmd.trip_decline"
-	DriverCmdLocation    = "driver.cmd.location"
-	DriverCmdRegister    = "driver.cmd.register"
-
-	// Payment events (payment.event.*)
-	PaymentEventSessionCreated = "payment.event.session_created"
-	PaymentEventSuccess        = "payment.event.success"
-	PaymentEventFailed         = "payment.event.failed"
-	PaymentEventCancelled      = "payment.event.cancelled"
-
-	// Payment commands (payment.cmd.*)
-	PaymentCmdCreateSession = "payment.cmd.create_session"
+	// User events (user.event.*)
+	UserEventLocationRegistered = "user.event.location_registered"
+	UserEventCreated            = "user.event.created"
+	UserEventDeleted            = "user.event.deleted"
 )
@@ -10,4 +10,28 @@ type APIResponse struct {
 type APIError struct {
 	Code    string `json:"code"`
 	Message string `json:"message"`
+	// Details lists every invalid field of a request that failed validation
+	Details []FieldError `json:"details,omitempty"`
 }
+
+// FieldError describes one invalid field, Field is the JSON path or the query param
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error codes of the API, clients can rely on them not changing
+const (
+	ErrCodeInvalidJSON         = "INVALID_JSON"
+	ErrCodeInvalidUsername     = "INVALID_USERNAME"
+	ErrCodeInvalidCoordinates  = "INVALID_COORDINATES"
+	ErrCodeInvalidRecordedAt   = "INVALID_RECORDED_AT"
+	ErrCodeInvalidArea         = "INVALID_AREA"
+	ErrCodeInvalidParameter    = "INVALID_PARAMETER"
+	ErrCodeUserNotFound        = "USER_NOT_FOUND"
+	ErrCodeAlreadyExists       = "ALREADY_EXISTS"
+	ErrCodeUpstreamUnavailable = "UPSTREAM_UNAVAILABLE"
+	ErrCodeUpstreamTimeout     = "UPSTREAM_TIMEOUT"
+	ErrCodeInternal            = "INTERNAL_ERROR"
+)
@@ -2,18 +2,30 @@ package db
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
 	"log"
 	"os"
 	"time"
 )
 
 const (
-	UserCollection     = "users"
+	UserCollection = "users"
+	// LocationCollection holds the legacy one document per user layout with a history array
 	LocationCollection = "locations"
+	// LocationFixCollection holds one document per location fix
+	LocationFixCollection = "location_fixes"
+	// UserOutboxCollection holds the user events waiting to be published
+	UserOutboxCollection = "user_outbox"
+	// ProcessedEventCollection holds the ids of the events the location history has applied
+	ProcessedEventCollection = "processed_events"
+	// ErasureCollection holds a tombstone for every erased user, it is never cleaned up
+	ErasureCollection = "erasures"
 )
 
 // MongoConfig holds MongoDB connection configuration
@@ -55,7 +67,30 @@ func NewMongoClient(ctx context.Context, cfg *MongoConfig) (*mongo.Client, error
 	return client, nil
 }
 
+// EnsureTransactions fails unless the client is connected to a replica set or a sharded cluster,
+// a standalone server rejects every transaction
+func EnsureTransactions(ctx context.Context, client *mongo.Client) error {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		return fmt.Errorf("failed to check the mongodb topology: %w", err)
+	}
+	if hello.SetName == "" && hello.Msg != "isdbgrid" {
+		return errors.New("mongodb transactions require a replica set or a sharded cluster, a standalone server is not supported")
+	}
+
+	return nil
+}
+
 // GetDatabase returns a database instance
 func GetDatabase(client *mongo.Client, cfg *MongoConfig) *mongo.Database {
 	return client.Database(cfg.Database)
 }
+
+// IsUnavailable reports whether MongoDB could not be reached, the operation may succeed when retried.
+// A server selection timeout is one of them, it is not a slow query.
+func IsUnavailable(err error) bool {
+	return mongo.IsNetworkError(err) || errors.As(err, &topology.ServerSelectionError{})
+}
@@ -0,0 +1,25 @@
+package messaging
+
+import (
+	"context"
+	"go-clinet-locations/shared/proto/events"
+)
+
+// Publisher publishes events to the user exchange
+type Publisher interface {
+	PublishMessage(ctx context.Context, routingKey string, envelope *events.EventEnvelope) error
+}
+
+// Consumer subscribes handlers to the queues bound to the user exchange, see QueueBindings
+type Consumer interface {
+	ConsumeMessages(ctx context.Context, queueName string, handler MessageHandler, options ConsumerOptions) error
+}
+
+var (
+	_ Publisher = (*RabbitMQ)(nil)
+	_ Consumer  = (*RabbitMQ)(nil)
+	_ Publisher = (*InmemBroker)(nil)
+	_ Consumer  = (*InmemBroker)(nil)
+)
+
+var _ OutboxRepository = (*InmemOutbox)(nil)
@@ -0,0 +1,142 @@
+package messaging
+
+import (
+	"context"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"hash/fnv"
+	"log"
+	"sync"
+)
+
+// ConsumerOptions control how many messages of a queue are handled at once
+type ConsumerOptions struct {
+	// PrefetchCount is the number of unacknowledged messages the broker sends ahead, at least Workers
+	PrefetchCount int
+	// Workers is the number of handlers running in parallel
+	Workers int
+	// OrderByOwner hands all messages of an owner to the same worker, so they are handled in the order
+	// they were published while the messages of different owners are handled in parallel
+	OrderByOwner bool
+}
+
+// DefaultConsumerOptions handle one message at a time
+func DefaultConsumerOptions() ConsumerOptions {
+	return ConsumerOptions{
+		PrefetchCount: 1,
+		Workers:       1,
+	}
+}
+
+func (o ConsumerOptions) withDefaults() ConsumerOptions {
+	o.Workers = max(o.Workers, 1)
+	// with fewer messages prefetched than workers some of the workers would always be idle
+	o.PrefetchCount = max(o.PrefetchCount, o.Workers)
+	return o
+}
+
+type consumer struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	queueName string
+	handler   MessageHandler
+	options   ConsumerOptions
+}
+
+// worker returns the worker the message of the owner is handed to
+func (c consumer) worker(msg amqp.Delivery) int {
+	owner, _ := msg.Headers[OwnerIDHeader].(string)
+
+	hash := fnv.New32a()
+	hash.Write([]byte(owner))
+	return int(hash.Sum32() % uint32(c.options.Workers))
+}
+
+// failureHandler decides what happens to a message whose handler failed, e.g. a retry
+type failureHandler func(ctx context.Context, queueName string, msg amqp.Delivery)
+
+// dispatch hands the deliveries to the workers until the deliveries are closed or the consumer is stopped,
+// and returns once every worker finished its message. A message that was not handed to a worker
+// stays unacknowledged and is delivered again when the channel is closed.
+func dispatch(c consumer, msgs <-chan amqp.Delivery, failed failureHandler) {
+	// without ordering all workers take from one queue, so a slow message does not hold up the others
+	queues := make([]chan amqp.Delivery, 1)
+	if c.options.OrderByOwner {
+		queues = make([]chan amqp.Delivery, c.options.Workers)
+	}
+	for i := range queues {
+		queues[i] = make(chan amqp.Delivery)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < c.options.Workers; i++ {
+		wg.Add(1)
+		go func(deliveries <-chan amqp.Delivery) {
+			defer wg.Done()
+			for msg := range deliveries {
+				handle(c, msg, failed)
+			}
+		}(queues[i%len(queues)])
+	}
+
+	defer func() {
+		for _, queue := range queues {
+			close(queue)
+		}
+		wg.Wait()
+	}()
+
+	for {
+		var msg amqp.Delivery
+		var ok bool
+		select {
+		case <-c.ctx.Done():
+			return
+		case msg, ok = <-msgs:
+			if !ok {
+				return
+			}
+		}
+
+		queue := queues[0]
+		if c.options.OrderByOwner {
+			queue = queues[c.worker(msg)]
+		}
+
+		select {
+		case <-c.ctx.Done():
+			return
+		case queue <- msg:
+		}
+	}
+}
+
+// handle runs the handler and acknowledges the message once it succeeded
+func handle(c consumer, msg amqp.Delivery, failed failureHandler) {
+	if c.ctx.Err() != nil {
+		// handed over while the consumer was stopping, it is left to the next consumer
+		if nackErr := msg.Nack(false, true); nackErr != nil {
+			log.Printf("ERROR: Failed to return message: %v", nackErr)
+		}
+		return
+	}
+	log.Printf("Received a message: %s", msg.MessageId)
+
+	if err := c.handler(c.ctx, msg); err != nil {
+		if c.ctx.Err() != nil {
+			// interrupted by the shutdown, the message did not fail and goes back to the queue as it is
+			if nackErr := msg.Nack(false, true); nackErr != nil {
+				log.Printf("ERROR: Failed to return message: %v", nackErr)
+			}
+			return
+		}
+
+		log.Printf("ERROR: Failed to handle message: %v. Message body: %s", err, msg.Body)
+		failed(c.ctx, c.queueName, msg)
+		return
+	}
+
+	// Only Ack if the handler succeeds
+	if ackErr := msg.Ack(false); ackErr != nil {
+		log.Printf("ERROR: Failed to Ack message: %v. Message body: %s", ackErr, msg.Body)
+	}
+}
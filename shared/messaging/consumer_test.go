@@ -0,0 +1,234 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAcknowledger counts the acknowledged and returned messages
+type fakeAcknowledger struct {
+	mu     sync.Mutex
+	acked  int
+	nacked int
+}
+
+func (a *fakeAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.acked++
+	return nil
+}
+
+func (a *fakeAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.nacked++
+	return nil
+}
+
+func (a *fakeAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+func TestDispatch_OrderByOwner(t *testing.T) {
+	acknowledger := &fakeAcknowledger{}
+	owners := []string{"alice", "bob", "carol", "dave", "erin"}
+	const perOwner = 50
+
+	var mu sync.Mutex
+	handled := make(map[string][]int)
+	running, maxRunning := 0, 0
+
+	handler := func(ctx context.Context, msg amqp.Delivery) error {
+		mu.Lock()
+		running++
+		maxRunning = max(maxRunning, running)
+		mu.Unlock()
+
+		time.Sleep(time.Duration(rand.Intn(200)) * time.Microsecond)
+
+		mu.Lock()
+		defer mu.Unlock()
+		running--
+		owner := msg.Headers[OwnerIDHeader].(string)
+		var seq int
+		fmt.Sscanf(string(msg.Body), "%d", &seq)
+		handled[owner] = append(handled[owner], seq)
+		return nil
+	}
+
+	msgs := make(chan amqp.Delivery, len(owners)*perOwner)
+	for seq := 0; seq < perOwner; seq++ {
+		for _, owner := range owners {
+			msgs <- amqp.Delivery{
+				Acknowledger: acknowledger,
+				Headers:      amqp.Table{OwnerIDHeader: owner},
+				Body:         []byte(fmt.Sprintf("%d", seq)),
+			}
+		}
+	}
+	close(msgs)
+
+	c := consumer{
+		ctx:       context.Background(),
+		queueName: "test",
+		handler:   handler,
+		options:   ConsumerOptions{Workers: 4, OrderByOwner: true}.withDefaults(),
+	}
+	dispatch(c, msgs, (&RabbitMQ{}).retryOrPark)
+
+	if acknowledger.acked != len(owners)*perOwner {
+		t.Errorf("expected %d acknowledged messages, got %d", len(owners)*perOwner, acknowledger.acked)
+	}
+	for _, owner := range owners {
+		for i, seq := range handled[owner] {
+			if seq != i {
+				t.Fatalf("expected message %d of %s at position %d, got %d", i, owner, i, seq)
+			}
+		}
+	}
+	if maxRunning < 2 {
+		t.Errorf("expected messages of different owners to be handled in parallel")
+	}
+}
+
+func TestDispatch_StopsWhenCancelled(t *testing.T) {
+	acknowledger := &fakeAcknowledger{}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	started := make(chan struct{})
+	calls := 0
+	handler := func(ctx context.Context, msg amqp.Delivery) error {
+		calls++
+		if calls == 1 {
+			close(started)
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	msgs := make(chan amqp.Delivery, 3)
+	for i := 0; i < 3; i++ {
+		msgs <- amqp.Delivery{Acknowledger: acknowledger}
+	}
+
+	c := consumer{ctx: ctx, queueName: "test", handler: handler, options: DefaultConsumerOptions().withDefaults()}
+	done := make(chan struct{})
+	go func() {
+		dispatch(c, msgs, (&RabbitMQ{}).retryOrPark)
+		close(done)
+	}()
+
+	<-started
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected dispatch to stop after the cancellation")
+	}
+
+	// the interrupted message is returned to the queue, the others are not handled anymore
+	if calls != 1 {
+		t.Errorf("expected the handler to be called once, got %d", calls)
+	}
+	if acknowledger.acked != 0 || acknowledger.nacked == 0 {
+		t.Errorf("expected the interrupted message to be returned, got %d acknowledged and %d returned", acknowledger.acked, acknowledger.nacked)
+	}
+}
+
+func TestConsumerOptions_WithDefaults(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  ConsumerOptions
+		expected ConsumerOptions
+	}{
+		{name: "zero value", options: ConsumerOptions{}, expected: ConsumerOptions{PrefetchCount: 1, Workers: 1}},
+		{name: "prefetch below workers", options: ConsumerOptions{PrefetchCount: 2, Workers: 8}, expected: ConsumerOptions{PrefetchCount: 8, Workers: 8}},
+		{name: "prefetch above workers", options: ConsumerOptions{PrefetchCount: 32, Workers: 8, OrderByOwner: true}, expected: ConsumerOptions{PrefetchCount: 32, Workers: 8, OrderByOwner: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := tt.options.withDefaults(); result != tt.expected {
+				t.Errorf("expected %+v, got %+v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestShutdown_DrainsInFlightMessages(t *testing.T) {
+	tests := []struct {
+		name        string
+		handleTime  time.Duration
+		deadline    time.Duration
+		expectAcked bool
+		expectError bool
+	}{
+		{name: "handler finishes before the deadline", handleTime: 20 * time.Millisecond, deadline: time.Second, expectAcked: true},
+		{name: "handler cancelled at the deadline", handleTime: time.Minute, deadline: 20 * time.Millisecond, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acknowledger := &fakeAcknowledger{}
+			r := &RabbitMQ{}
+
+			started := make(chan struct{})
+			handler := func(ctx context.Context, msg amqp.Delivery) error {
+				close(started)
+				select {
+				case <-time.After(tt.handleTime):
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			c := consumer{ctx: ctx, cancel: cancel, queueName: "test", handler: handler, options: DefaultConsumerOptions().withDefaults()}
+			r.consumers = append(r.consumers, c)
+
+			msgs := make(chan amqp.Delivery, 1)
+			msgs <- amqp.Delivery{Acknowledger: acknowledger}
+			// the broker closes the deliveries once the subscription is cancelled
+			close(msgs)
+
+			r.inflight.Add(1)
+			go func() {
+				defer r.inflight.Done()
+				dispatch(c, msgs, r.retryOrPark)
+			}()
+			<-started
+
+			shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), tt.deadline)
+			defer cancelShutdown()
+			err := r.Shutdown(shutdownCtx)
+
+			if tt.expectError && err == nil {
+				t.Errorf("expected error but got none")
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !r.isClosed() {
+				t.Errorf("expected the connection to be closed")
+			}
+
+			r.inflight.Wait()
+			acknowledger.mu.Lock()
+			defer acknowledger.mu.Unlock()
+			if tt.expectAcked && acknowledger.acked != 1 {
+				t.Errorf("expected the message to be acknowledged, got %d acknowledged", acknowledger.acked)
+			}
+			if !tt.expectAcked && acknowledger.nacked != 1 {
+				t.Errorf("expected the message to be returned, got %d returned", acknowledger.nacked)
+			}
+		})
+	}
+}
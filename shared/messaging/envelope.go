@@ -0,0 +1,78 @@
+package messaging
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"go-clinet-locations/shared/proto/events"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"log"
+)
+
+// EventSchemaVersion is the version of proto/events.proto the envelopes are published with.
+// Bump it when the meaning of a field changes, adding fields does not need a new version.
+const EventSchemaVersion = 1
+
+// OwnerIDHeader carries the owner of the event so it can be routed without decoding the body
+const OwnerIDHeader = "owner-id"
+
+const EnvelopeContentType = "application/x-protobuf"
+
+var ErrUnexpectedEventType = errors.New("unexpected event type")
+
+// NewEnvelope wraps the event with a new id, so redeliveries of the envelope can be recognised
+func NewEnvelope(producer, ownerID string, event proto.Message) (*events.EventEnvelope, error) {
+	payload, err := proto.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal event: %v", err)
+	}
+
+	return &events.EventEnvelope{
+		EventId:       uuid.NewString(),
+		EventType:     EventType(event),
+		SchemaVersion: EventSchemaVersion,
+		OccurredAt:    timestamppb.Now(),
+		Producer:      producer,
+		OwnerId:       ownerID,
+		Payload:       payload,
+	}, nil
+}
+
+// EventType is the name the event is published under, e.g. events.UserCreated
+func EventType(event proto.Message) string {
+	return string(proto.MessageName(event))
+}
+
+func EncodeEnvelope(envelope *events.EventEnvelope) ([]byte, error) {
+	body, err := proto.Marshal(envelope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal envelope: %v", err)
+	}
+	return body, nil
+}
+
+func DecodeEnvelope(body []byte) (*events.EventEnvelope, error) {
+	var envelope events.EventEnvelope
+	if err := proto.Unmarshal(body, &envelope); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal envelope: %v", err)
+	}
+	return &envelope, nil
+}
+
+// UnpackEvent decodes the payload of the envelope into event.
+// Envelopes of a newer schema version are decoded as well, fields this version does not know are ignored.
+func UnpackEvent(envelope *events.EventEnvelope, event proto.Message) error {
+	if envelope.GetEventType() != EventType(event) {
+		return fmt.Errorf("%w: %s", ErrUnexpectedEventType, envelope.GetEventType())
+	}
+
+	if envelope.GetSchemaVersion() > EventSchemaVersion {
+		log.Printf("event %s has schema version %d, reading it as version %d", envelope.GetEventId(), envelope.GetSchemaVersion(), EventSchemaVersion)
+	}
+
+	if err := proto.Unmarshal(envelope.GetPayload(), event); err != nil {
+		return fmt.Errorf("failed to unmarshal %s: %v", envelope.GetEventType(), err)
+	}
+	return nil
+}
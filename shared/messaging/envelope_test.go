@@ -0,0 +1,99 @@
+package messaging
+
+import (
+	"errors"
+	"go-clinet-locations/shared/proto/events"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"testing"
+)
+
+func TestEnvelope_RoundTrip(t *testing.T) {
+	envelope, err := NewEnvelope("user-service", "owner", &events.UserCreated{UserId: "owner", UserName: "mover"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	body, err := EncodeEnvelope(envelope)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	decoded, err := DecodeEnvelope(body)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if decoded.GetEventId() == "" || decoded.GetEventId() != envelope.GetEventId() {
+		t.Errorf("expected event id %q, got %q", envelope.GetEventId(), decoded.GetEventId())
+	}
+	if decoded.GetEventType() != "events.UserCreated" {
+		t.Errorf("expected event type events.UserCreated, got %s", decoded.GetEventType())
+	}
+	if decoded.GetSchemaVersion() != EventSchemaVersion {
+		t.Errorf("expected schema version %d, got %d", EventSchemaVersion, decoded.GetSchemaVersion())
+	}
+
+	var event events.UserCreated
+	if err := UnpackEvent(decoded, &event); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if event.GetUserName() != "mover" {
+		t.Errorf("expected user name mover, got %s", event.GetUserName())
+	}
+}
+
+func TestUnpackEvent(t *testing.T) {
+	payload, _ := proto.Marshal(&events.UserDeleted{UserId: "owner", UserName: "mover"})
+	// a field added by a future schema version
+	future := protowire.AppendString(protowire.AppendTag(payload, 99, protowire.BytesType), "unknown")
+
+	tests := []struct {
+		name        string
+		envelope    *events.EventEnvelope
+		expectedErr error
+		expectError bool
+	}{
+		{
+			name:     "current version",
+			envelope: &events.EventEnvelope{EventType: "events.UserDeleted", SchemaVersion: EventSchemaVersion, Payload: payload},
+		},
+		{
+			name:     "newer version with unknown fields",
+			envelope: &events.EventEnvelope{EventType: "events.UserDeleted", SchemaVersion: EventSchemaVersion + 1, Payload: future},
+		},
+		{
+			name:        "other event type",
+			envelope:    &events.EventEnvelope{EventType: "events.UserCreated", SchemaVersion: EventSchemaVersion, Payload: payload},
+			expectedErr: ErrUnexpectedEventType,
+			expectError: true,
+		},
+		{
+			name:        "corrupted payload",
+			envelope:    &events.EventEnvelope{EventType: "events.UserDeleted", SchemaVersion: EventSchemaVersion, Payload: []byte{0xff}},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var event events.UserDeleted
+			err := UnpackEvent(tt.envelope, &event)
+
+			if tt.expectError {
+				if err == nil {
+					t.Fatalf("expected error but got none")
+				}
+				if tt.expectedErr != nil && !errors.Is(err, tt.expectedErr) {
+					t.Errorf("expected %v, got %v", tt.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if event.GetUserName() != "mover" {
+				t.Errorf("expected user name mover, got %s", event.GetUserName())
+			}
+		})
+	}
+}
@@ -1,9 +1,31 @@
 package messaging
 
-const (
-	SaveUserLocationQueue = "save_user_location"
+import (
+	"fmt"
+	"go-clinet-locations/shared/contracts"
+	"time"
 )
 
 const (
-	RegisterLocationEventBind = "location.event.register"
+	SaveUserLocationQueue = "save_user_location"
 )
+
+// QueueBindings lists the routing keys of the user exchange every queue is bound to, the patterns may use the * and # wildcards
+var QueueBindings = map[string][]string{
+	// the deletions share the queue of the locations, so they are ordered after the locations of the user sent before them
+	SaveUserLocationQueue: {contracts.UserEventLocationRegistered, contracts.UserEventDeleted},
+}
+
+// RetryCountHeader counts how many times a message has been sent back to its queue after a failure
+const RetryCountHeader = "x-retry-count"
+
+// RetryQueue holds failed messages of the queue for wait. Every wait has its own queue with a fixed TTL,
+// so a message never waits behind one that waits longer.
+func RetryQueue(queueName string, wait time.Duration) string {
+	return fmt.Sprintf("%s.retry.%dms", queueName, wait.Milliseconds())
+}
+
+// DeadLetterQueue holds the messages of the queue that failed on every attempt
+func DeadLetterQueue(queueName string) string {
+	return queueName + ".dlq"
+}
@@ -0,0 +1,256 @@
+package messaging
+
+import (
+	"context"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go-clinet-locations/shared/proto/events"
+	"go-clinet-locations/shared/retry"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// inmemQueueSize is the number of messages a queue holds before publishing to it blocks
+const inmemQueueSize = 10000
+
+// InmemBroker is a topic exchange in the memory of the process for tests and local runs.
+// It routes with QueueBindings like the user exchange, retries failed messages without waiting
+// and keeps the messages that failed on every attempt as dead letters.
+type InmemBroker struct {
+	mu          sync.Mutex
+	bindings    map[string][]string
+	queues      map[string]chan amqp.Delivery
+	deadLetters map[string][]amqp.Delivery
+	// pending counts the routed messages that were not acknowledged or dead-lettered yet
+	pending int
+	idle    *sync.Cond
+	tags    atomic.Uint64
+
+	// RetryPolicy decides how many times a failed message is retried, the waits are ignored
+	RetryPolicy retry.Config
+}
+
+func NewInmemBroker() *InmemBroker {
+	b := &InmemBroker{
+		bindings:    make(map[string][]string),
+		queues:      make(map[string]chan amqp.Delivery),
+		deadLetters: make(map[string][]amqp.Delivery),
+		RetryPolicy: DefaultRetryPolicy(),
+	}
+	b.idle = sync.NewCond(&b.mu)
+
+	for queueName, routingKeys := range QueueBindings {
+		for _, routingKey := range routingKeys {
+			b.Bind(queueName, routingKey)
+		}
+	}
+	return b
+}
+
+// Bind routes the messages whose routing key matches the pattern to the queue
+func (b *InmemBroker) Bind(queueName, pattern string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.bindings[queueName] = append(b.bindings[queueName], pattern)
+	b.queue(queueName)
+}
+
+func (b *InmemBroker) PublishMessage(ctx context.Context, routingKey string, envelope *events.EventEnvelope) error {
+	msg, err := newPublishing(envelope)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	var queues []string
+	for queueName, patterns := range b.bindings {
+		for _, pattern := range patterns {
+			if topicMatches(pattern, routingKey) {
+				queues = append(queues, queueName)
+				break
+			}
+		}
+	}
+	b.mu.Unlock()
+
+	for _, queueName := range queues {
+		b.enqueue(queueName, amqp.Delivery{
+			Exchange:     UserExchange,
+			RoutingKey:   routingKey,
+			ContentType:  msg.ContentType,
+			Type:         msg.Type,
+			MessageId:    msg.MessageId,
+			AppId:        msg.AppId,
+			Timestamp:    msg.Timestamp,
+			Headers:      msg.Headers,
+			Body:         msg.Body,
+			DeliveryMode: msg.DeliveryMode,
+		})
+	}
+	return nil
+}
+
+// ConsumeMessages runs the handler on the messages of the queue until ctx is cancelled,
+// with the same worker and ordering options as RabbitMQ
+func (b *InmemBroker) ConsumeMessages(ctx context.Context, queueName string, handler MessageHandler, options ConsumerOptions) error {
+	b.mu.Lock()
+	msgs := b.queue(queueName)
+	b.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	c := consumer{ctx: ctx, cancel: cancel, queueName: queueName, handler: handler, options: options.withDefaults()}
+	go dispatch(c, msgs, b.retryOrPark)
+
+	return nil
+}
+
+// WaitIdle blocks until every published message was acknowledged or dead-lettered
+func (b *InmemBroker) WaitIdle() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for b.pending > 0 {
+		b.idle.Wait()
+	}
+}
+
+// DeadLetters returns the messages of the queue that failed on every attempt
+func (b *InmemBroker) DeadLetters(queueName string) []amqp.Delivery {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]amqp.Delivery(nil), b.deadLetters[queueName]...)
+}
+
+// queue returns the queue, declaring it on first use. The caller holds the lock.
+func (b *InmemBroker) queue(queueName string) chan amqp.Delivery {
+	if b.queues[queueName] == nil {
+		b.queues[queueName] = make(chan amqp.Delivery, inmemQueueSize)
+	}
+	return b.queues[queueName]
+}
+
+func (b *InmemBroker) enqueue(queueName string, msg amqp.Delivery) {
+	b.mu.Lock()
+	b.pending++
+	queue := b.queue(queueName)
+	b.mu.Unlock()
+
+	b.redeliver(queueName, queue, msg)
+}
+
+// redeliver puts a message that is already counted as pending back to the queue
+func (b *InmemBroker) redeliver(queueName string, queue chan amqp.Delivery, msg amqp.Delivery) {
+	msg.DeliveryTag = b.tags.Add(1)
+	msg.Acknowledger = &inmemAcknowledger{broker: b, queueName: queueName, msg: msg}
+	queue <- msg
+}
+
+// settle removes an acknowledged or dead-lettered message from the pending ones
+func (b *InmemBroker) settle() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.pending--
+	if b.pending == 0 {
+		b.idle.Broadcast()
+	}
+}
+
+// retryOrPark sends a failed message straight back to its queue until it ran out of retries
+func (b *InmemBroker) retryOrPark(ctx context.Context, queueName string, msg amqp.Delivery) {
+	retries := retryCount(msg.Headers)
+	if retries < b.RetryPolicy.MaxRetries {
+		headers := amqp.Table{}
+		for key, value := range msg.Headers {
+			headers[key] = value
+		}
+		headers[RetryCountHeader] = int32(retries + 1)
+
+		retried := msg
+		retried.Headers = headers
+		retried.Redelivered = false
+
+		// the worker retrying the message is draining the queue, waiting for room in a full queue would wait for itself
+		b.mu.Lock()
+		b.pending++
+		queue := b.queue(queueName)
+		b.mu.Unlock()
+		go b.redeliver(queueName, queue, retried)
+
+		if err := msg.Ack(false); err != nil {
+			log.Printf("ERROR: Failed to Ack message: %v", err)
+		}
+		return
+	}
+
+	log.Printf("Parking message in %s after %d retries", DeadLetterQueue(queueName), retries)
+	if err := msg.Nack(false, false); err != nil {
+		log.Printf("ERROR: Failed to Nack message: %v", err)
+	}
+}
+
+// inmemAcknowledger settles one delivery of the in-memory broker
+type inmemAcknowledger struct {
+	broker    *InmemBroker
+	queueName string
+	msg       amqp.Delivery
+}
+
+func (a *inmemAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.broker.settle()
+	return nil
+}
+
+func (a *inmemAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	if requeue {
+		msg := a.msg
+		msg.Redelivered = true
+
+		a.broker.mu.Lock()
+		queue := a.broker.queue(a.queueName)
+		a.broker.mu.Unlock()
+
+		go a.broker.redeliver(a.queueName, queue, msg)
+		return nil
+	}
+
+	a.broker.mu.Lock()
+	a.broker.deadLetters[a.queueName] = append(a.broker.deadLetters[a.queueName], a.msg)
+	a.broker.mu.Unlock()
+
+	a.broker.settle()
+	return nil
+}
+
+func (a *inmemAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+// topicMatches reports whether the routing key matches the binding pattern of a topic exchange,
+// * stands for exactly one word and # for zero or more words
+func topicMatches(pattern, routingKey string) bool {
+	return matchWords(strings.Split(pattern, "."), strings.Split(routingKey, "."))
+}
+
+func matchWords(pattern, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+
+	switch pattern[0] {
+	case "#":
+		for skip := 0; skip <= len(words); skip++ {
+			if matchWords(pattern[1:], words[skip:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(words) > 0 && matchWords(pattern[1:], words[1:])
+	default:
+		return len(words) > 0 && pattern[0] == words[0] && matchWords(pattern[1:], words[1:])
+	}
+}
@@ -0,0 +1,181 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go-clinet-locations/shared/proto/events"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTopicMatches(t *testing.T) {
+	tests := []struct {
+		pattern    string
+		routingKey string
+		expected   bool
+	}{
+		{pattern: "user.event.created", routingKey: "user.event.created", expected: true},
+		{pattern: "user.event.created", routingKey: "user.event.deleted", expected: false},
+		{pattern: "user.event.*", routingKey: "user.event.created", expected: true},
+		{pattern: "user.*", routingKey: "user.event.created", expected: false},
+		{pattern: "*.event.*", routingKey: "user.event.deleted", expected: true},
+		{pattern: "user.#", routingKey: "user.event.created", expected: true},
+		{pattern: "user.#", routingKey: "user", expected: true},
+		{pattern: "#", routingKey: "user.event.created", expected: true},
+		{pattern: "#.created", routingKey: "user.event.created", expected: true},
+		{pattern: "user.#.created", routingKey: "user.created", expected: true},
+		{pattern: "user.#.created", routingKey: "user.event.deleted", expected: false},
+		{pattern: "user.*.created", routingKey: "user.created", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.routingKey, func(t *testing.T) {
+			if result := topicMatches(tt.pattern, tt.routingKey); result != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestInmemBroker_Routing(t *testing.T) {
+	ctx := context.Background()
+	broker := NewInmemBroker()
+	broker.Bind("audit", "user.event.*")
+
+	var mu sync.Mutex
+	received := make(map[string][]string)
+	subscribe := func(queueName string) {
+		err := broker.ConsumeMessages(ctx, queueName, func(ctx context.Context, msg amqp.Delivery) error {
+			mu.Lock()
+			defer mu.Unlock()
+			received[queueName] = append(received[queueName], msg.Type)
+			return nil
+		}, DefaultConsumerOptions())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	subscribe(SaveUserLocationQueue)
+	subscribe("audit")
+
+	publish := func(routingKey string, event *events.UserCreated) {
+		envelope, err := NewEnvelope("test", "owner", event)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := broker.PublishMessage(ctx, routingKey, envelope); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	publish("user.event.location_registered", &events.UserCreated{})
+	publish("user.event.created", &events.UserCreated{})
+	publish("other.event", &events.UserCreated{})
+	broker.WaitIdle()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received[SaveUserLocationQueue]) != 1 {
+		t.Errorf("expected 1 message in %s, got %d", SaveUserLocationQueue, len(received[SaveUserLocationQueue]))
+	}
+	if len(received["audit"]) != 2 {
+		t.Errorf("expected 2 messages in audit, got %d", len(received["audit"]))
+	}
+}
+
+func TestInmemBroker_RetriesAndDeadLetters(t *testing.T) {
+	tests := []struct {
+		name               string
+		failures           int
+		expectedAttempts   int
+		expectedDeadLetter int
+	}{
+		{name: "succeeds at once", failures: 0, expectedAttempts: 1},
+		{name: "succeeds after retries", failures: 3, expectedAttempts: 4},
+		{name: "fails on every attempt", failures: 100, expectedAttempts: 6, expectedDeadLetter: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			broker := NewInmemBroker()
+
+			attempts := 0
+			err := broker.ConsumeMessages(ctx, SaveUserLocationQueue, func(ctx context.Context, msg amqp.Delivery) error {
+				attempts++
+				if attempts <= tt.failures {
+					return errors.New("database unavailable")
+				}
+				return nil
+			}, DefaultConsumerOptions())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			envelope, _ := NewEnvelope("test", "owner", &events.UserLocationRegistered{UserId: "owner"})
+			if err := broker.PublishMessage(ctx, "user.event.location_registered", envelope); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			broker.WaitIdle()
+
+			if attempts != tt.expectedAttempts {
+				t.Errorf("expected %d attempts, got %d", tt.expectedAttempts, attempts)
+			}
+			if deadLetters := broker.DeadLetters(SaveUserLocationQueue); len(deadLetters) != tt.expectedDeadLetter {
+				t.Errorf("expected %d dead letters, got %d", tt.expectedDeadLetter, len(deadLetters))
+			}
+		})
+	}
+}
+
+func TestInmemBroker_RetryIntoFullQueue(t *testing.T) {
+	ctx := context.Background()
+	broker := NewInmemBroker()
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	var attempts atomic.Int32
+	err := broker.ConsumeMessages(ctx, SaveUserLocationQueue, func(ctx context.Context, msg amqp.Delivery) error {
+		if attempts.Add(1) == 1 {
+			close(entered)
+			<-release
+			return errors.New("database unavailable")
+		}
+		return nil
+	}, ConsumerOptions{Workers: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	envelope, _ := NewEnvelope("test", "owner", &events.UserLocationRegistered{UserId: "owner"})
+	publish := func() {
+		if err := broker.PublishMessage(ctx, "user.event.location_registered", envelope); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// the only worker fails the first message once the queue is full
+	publish()
+	<-entered
+	for i := 0; i <= inmemQueueSize; i++ {
+		publish()
+	}
+	close(release)
+
+	idle := make(chan struct{})
+	go func() {
+		broker.WaitIdle()
+		close(idle)
+	}()
+	select {
+	case <-idle:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the retry not to block the worker")
+	}
+
+	if expected := int32(inmemQueueSize + 3); attempts.Load() != expected {
+		t.Errorf("expected %d attempts, got %d", expected, attempts.Load())
+	}
+}
@@ -0,0 +1,86 @@
+package messaging
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go-clinet-locations/shared/proto/events"
+	"go-clinet-locations/shared/types"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"time"
+)
+
+// The locations were published as JSON with the routing key location.event.register before the event envelope.
+// The legacy messages still queued or parked when upgrading are read for one more release, remove this file afterwards.
+
+const (
+	// LegacyRegisterLocationRoutingKey is the routing key of the legacy location messages
+	LegacyRegisterLocationRoutingKey = "location.event.register"
+	// LegacyContentType is the content type the legacy messages were published with
+	LegacyContentType = "text/plain"
+)
+
+// legacyRoutingKeys are unbound from the queues explicitly, new messages are never published with them
+var legacyRoutingKeys = []string{LegacyRegisterLocationRoutingKey}
+
+// legacyMessage is the JSON body of a legacy message
+type legacyMessage struct {
+	OwnerID string `json:"ownerId"`
+	Data    []byte `json:"data"`
+}
+
+// legacyUserLocation is the JSON in the data of a legacy message
+type legacyUserLocation struct {
+	UserId     string            `json:"userId"`
+	Coordinate *types.Coordinate `json:"coordinate"`
+}
+
+// DecodeMessage returns the envelope of the delivery. A legacy JSON message is converted to the envelope
+// of a UserLocationRegistered event, so the consumers handle it like the events published now.
+func DecodeMessage(msg amqp.Delivery) (*events.EventEnvelope, error) {
+	if msg.ContentType == LegacyContentType {
+		return decodeLegacyMessage(msg.Body)
+	}
+	return DecodeEnvelope(msg.Body)
+}
+
+func decodeLegacyMessage(body []byte) (*events.EventEnvelope, error) {
+	var legacy legacyMessage
+	if err := json.Unmarshal(body, &legacy); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal legacy message: %v", err)
+	}
+
+	var location legacyUserLocation
+	if err := json.Unmarshal(legacy.Data, &location); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal legacy location: %v", err)
+	}
+	if location.Coordinate == nil {
+		return nil, errors.New("legacy location without coordinate")
+	}
+
+	payload, err := proto.Marshal(&events.UserLocationRegistered{
+		UserId: location.UserId,
+		Coordinate: &events.Coordinate{
+			Latitude:  location.Coordinate.Latitude,
+			Longitude: location.Coordinate.Longitude,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal event: %v", err)
+	}
+
+	return &events.EventEnvelope{
+		// the legacy messages have no id, one derived from the body recognises a redelivery
+		EventId:       uuid.NewSHA1(uuid.NameSpaceOID, body).String(),
+		EventType:     EventType(&events.UserLocationRegistered{}),
+		SchemaVersion: EventSchemaVersion,
+		// nor the time they were sent, they are recorded when read as they were before
+		OccurredAt: timestamppb.New(time.Now()),
+		Producer:   "user-service",
+		OwnerId:    legacy.OwnerID,
+		Payload:    payload,
+	}, nil
+}
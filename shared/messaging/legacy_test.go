@@ -0,0 +1,69 @@
+package messaging
+
+import (
+	"encoding/json"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go-clinet-locations/shared/proto/events"
+	"testing"
+)
+
+// newLegacyBody returns the JSON body published for a location before the event envelope
+func newLegacyBody(t *testing.T, data string) []byte {
+	body, err := json.Marshal(map[string]any{"ownerId": "owner", "data": []byte(data)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return body
+}
+
+func TestDecodeMessage_Legacy(t *testing.T) {
+	body := newLegacyBody(t, `{"userId":"owner","coordinate":{"latitude":51.1,"longitude":17.03}}`)
+
+	envelope, err := DecodeMessage(amqp.Delivery{ContentType: LegacyContentType, Body: body})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if envelope.GetOwnerId() != "owner" {
+		t.Errorf("expected owner, got %q", envelope.GetOwnerId())
+	}
+	if envelope.GetOccurredAt() == nil {
+		t.Errorf("expected the time the message was read")
+	}
+
+	var event events.UserLocationRegistered
+	if err := UnpackEvent(envelope, &event); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if event.GetUserId() != "owner" || event.GetCoordinate().GetLatitude() != 51.1 || event.GetCoordinate().GetLongitude() != 17.03 {
+		t.Errorf("expected the location of owner at 51.1,17.03, got %v", event.String())
+	}
+
+	again, err := DecodeMessage(amqp.Delivery{ContentType: LegacyContentType, Body: body})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if again.GetEventId() != envelope.GetEventId() {
+		t.Errorf("expected a redelivery to keep event id %s, got %s", envelope.GetEventId(), again.GetEventId())
+	}
+}
+
+func TestDecodeMessage_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  amqp.Delivery
+	}{
+		{name: "legacy body not JSON", msg: amqp.Delivery{ContentType: LegacyContentType, Body: []byte("{")}},
+		{name: "legacy data not JSON", msg: amqp.Delivery{ContentType: LegacyContentType, Body: newLegacyBody(t, "{")}},
+		{name: "legacy location without coordinate", msg: amqp.Delivery{ContentType: LegacyContentType, Body: newLegacyBody(t, `{"userId":"owner"}`)}},
+		{name: "envelope not protobuf", msg: amqp.Delivery{ContentType: EnvelopeContentType, Body: []byte{0xff}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodeMessage(tt.msg); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package messaging
+
+import (
+	"context"
+	"go-clinet-locations/shared/proto/events"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRelayInterval = 1 * time.Second
+	relayBatchSize       = 100
+)
+
+// OutboxEvent is an event stored in the same write as the change that caused it.
+// The outbox relay publishes it afterwards, so the event is delivered at least once even when the broker is down.
+// Payload is an encoded events.EventEnvelope.
+type OutboxEvent struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	RoutingKey string             `bson:"routingKey"`
+	OwnerID    string             `bson:"ownerId"`
+	Payload    []byte             `bson:"payload"`
+	CreatedAt  time.Time          `bson:"createdAt"`
+	// Attempts and LastError describe the failed publishes, for troubleshooting
+	Attempts  int    `bson:"attempts"`
+	LastError string `bson:"lastError,omitempty"`
+}
+
+type OutboxRepository interface {
+	// PendingEvents returns up to limit undelivered events, oldest first
+	PendingEvents(ctx context.Context, limit int) ([]*OutboxEvent, error)
+	// DeleteEvent removes a delivered event
+	DeleteEvent(ctx context.Context, id primitive.ObjectID) error
+	// RecordFailure keeps the event for the next attempt and remembers why the publish failed
+	RecordFailure(ctx context.Context, id primitive.ObjectID, cause error) error
+}
+
+// NewOutboxEvent stores the encoded envelope, so every publish attempt of the event carries the same event id
+func NewOutboxEvent(routingKey string, envelope *events.EventEnvelope) (*OutboxEvent, error) {
+	payload, err := EncodeEnvelope(envelope)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OutboxEvent{
+		RoutingKey: routingKey,
+		OwnerID:    envelope.GetOwnerId(),
+		Payload:    payload,
+		CreatedAt:  time.Now(),
+	}, nil
+}
+
+// OutboxRelay publishes the events of the outbox and deletes them once the broker confirmed them.
+// An event is published again when the relay stops between the publish and the delete, so delivery is at least once.
+type OutboxRelay struct {
+	outbox    OutboxRepository
+	publisher Publisher
+	interval  time.Duration
+}
+
+func NewOutboxRelay(outbox OutboxRepository, publisher Publisher) *OutboxRelay {
+	return &OutboxRelay{
+		outbox:    outbox,
+		publisher: publisher,
+		interval:  defaultRelayInterval,
+	}
+}
+
+// Run relays the pending events every interval until the context is cancelled
+func (r *OutboxRelay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.RelayPending(ctx); err != nil {
+			log.Printf("ERROR: Failed to relay outbox events: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RelayPending publishes the pending events oldest first and returns how many were delivered.
+// It stops at the first failure, so the events of an owner are never published out of order.
+func (r *OutboxRelay) RelayPending(ctx context.Context) (int, error) {
+	delivered := 0
+	for {
+		events, err := r.outbox.PendingEvents(ctx, relayBatchSize)
+		if err != nil {
+			return delivered, err
+		}
+
+		for _, event := range events {
+			if err := r.publish(ctx, event); err != nil {
+				if recordErr := r.outbox.RecordFailure(ctx, event.ID, err); recordErr != nil {
+					log.Printf("ERROR: Failed to record outbox failure: %v", recordErr)
+				}
+				return delivered, err
+			}
+
+			if err := r.outbox.DeleteEvent(ctx, event.ID); err != nil {
+				return delivered, err
+			}
+			delivered++
+		}
+
+		if len(events) < relayBatchSize {
+			return delivered, nil
+		}
+	}
+}
+
+// publish returns once the broker confirmed the event
+func (r *OutboxRelay) publish(ctx context.Context, event *OutboxEvent) error {
+	envelope, err := DecodeEnvelope(event.Payload)
+	if err != nil {
+		return err
+	}
+
+	return r.publisher.PublishMessage(ctx, event.RoutingKey, envelope)
+}
+
+// InmemOutbox is an outbox in the memory of the process for tests and local runs
+type InmemOutbox struct {
+	mu     sync.Mutex
+	events []*OutboxEvent
+}
+
+func NewInmemOutbox() *InmemOutbox {
+	return &InmemOutbox{}
+}
+
+// Add appends the event to the outbox
+func (o *InmemOutbox) Add(event *OutboxEvent) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	event.ID = primitive.NewObjectID()
+	o.events = append(o.events, event)
+}
+
+// DropEvents removes the unpublished events of the owner
+func (o *InmemOutbox) DropEvents(ownerID string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	kept := o.events[:0]
+	for _, event := range o.events {
+		if event.OwnerID != ownerID {
+			kept = append(kept, event)
+		}
+	}
+	o.events = kept
+}
+
+func (o *InmemOutbox) PendingEvents(ctx context.Context, limit int) ([]*OutboxEvent, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	n := min(limit, len(o.events))
+	events := make([]*OutboxEvent, 0, n)
+	for _, event := range o.events[:n] {
+		copied := *event
+		events = append(events, &copied)
+	}
+	return events, nil
+}
+
+func (o *InmemOutbox) DeleteEvent(ctx context.Context, id primitive.ObjectID) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for i, event := range o.events {
+		if event.ID == id {
+			o.events = append(o.events[:i:i], o.events[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (o *InmemOutbox) RecordFailure(ctx context.Context, id primitive.ObjectID, cause error) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, event := range o.events {
+		if event.ID == id {
+			event.Attempts++
+			event.LastError = cause.Error()
+		}
+	}
+	return nil
+}
+
+// Events returns the events waiting in the outbox
+func (o *InmemOutbox) Events() []*OutboxEvent {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return append([]*OutboxEvent(nil), o.events...)
+}
@@ -0,0 +1,96 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"go-clinet-locations/shared/proto/events"
+	"testing"
+	"time"
+)
+
+// fakePublisher records the published envelopes and fails once failAt envelopes were published
+type fakePublisher struct {
+	published []*events.EventEnvelope
+	failAt    int
+}
+
+func (p *fakePublisher) PublishMessage(ctx context.Context, routingKey string, envelope *events.EventEnvelope) error {
+	if p.failAt >= 0 && len(p.published) == p.failAt {
+		return errors.New("broker unavailable")
+	}
+	p.published = append(p.published, envelope)
+	return nil
+}
+
+func TestOutboxRelay_RelayPending(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name              string
+		failAt            int
+		expectedDelivered int
+		expectError       bool
+	}{
+		{name: "every event delivered", failAt: -1, expectedDelivered: 3},
+		{name: "stops at the first failure", failAt: 1, expectedDelivered: 1, expectError: true},
+		{name: "broker down", failAt: 0, expectedDelivered: 0, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outbox := NewInmemOutbox()
+			for i := 0; i < 3; i++ {
+				envelope, err := NewEnvelope("test", "mover", &events.UserLocationRegistered{
+					UserId:     "mover",
+					Coordinate: &events.Coordinate{Latitude: 51.1 + float64(i)*0.01, Longitude: 17.0},
+				})
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				event, err := NewOutboxEvent("user.event.location_registered", envelope)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				outbox.Add(event)
+			}
+
+			publisher := &fakePublisher{failAt: tt.failAt}
+			relay := &OutboxRelay{outbox: outbox, publisher: publisher, interval: time.Second}
+
+			delivered, err := relay.RelayPending(ctx)
+			if tt.expectError && err == nil {
+				t.Errorf("expected error but got none")
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if delivered != tt.expectedDelivered {
+				t.Errorf("expected %d delivered events, got %d", tt.expectedDelivered, delivered)
+			}
+
+			// delivered events leave the outbox, the others wait for the next run
+			pending := outbox.Events()
+			if len(pending) != 3-tt.expectedDelivered {
+				t.Fatalf("expected %d events left in the outbox, got %d", 3-tt.expectedDelivered, len(pending))
+			}
+			if tt.expectError && (pending[0].Attempts != 1 || pending[0].LastError == "") {
+				t.Errorf("expected the failure to be recorded, got %d attempts and error %q", pending[0].Attempts, pending[0].LastError)
+			}
+
+			// events are published in the order they were written
+			for i, envelope := range publisher.published {
+				if envelope.GetOwnerId() != "mover" {
+					t.Errorf("expected owner mover, got %s", envelope.GetOwnerId())
+				}
+				var payload events.UserLocationRegistered
+				if err := UnpackEvent(envelope, &payload); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				expectedLatitude := 51.1 + float64(i)*0.01
+				if payload.GetCoordinate().GetLatitude() != expectedLatitude {
+					t.Errorf("expected latitude %.2f at position %d, got %.2f", expectedLatitude, i, payload.GetCoordinate().GetLatitude())
+				}
+			}
+		})
+	}
+}
@@ -2,126 +2,484 @@ package messaging
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"github.com/google/uuid"
 	amqp "github.com/rabbitmq/amqp091-go"
-	"go-clinet-locations/shared/contracts"
+	"go-clinet-locations/shared/proto/events"
+	"go-clinet-locations/shared/retry"
+	"io"
 	"log"
+	"sync"
+	"time"
 )
 
 const (
 	UserExchange = "user"
+	// RetryExchange routes failed messages to the retry queue of their queue, keyed by the queue name
+	RetryExchange = "user.retry"
+	// DeadLetterExchange routes rejected messages to the dead letter queue of their queue, keyed by the queue name.
+	// The queues are pointed at it by the user-dead-letters policy, see the README.
+	DeadLetterExchange = "user.dlx"
 )
 
 type RabbitMQ struct {
+	uri string
+
+	mu      sync.RWMutex
 	conn    *amqp.Connection
-	Channel *amqp.Channel
+	channel *amqp.Channel
+	// consumers are subscribed again after a reconnect
+	consumers []consumer
+	// consumerTags are the subscriptions on the current channel, they are cancelled on shutdown
+	consumerTags []string
+	// inflight counts the running dispatchers, each one returns after its workers finished their messages
+	inflight sync.WaitGroup
+	closed   bool
+
+	// dial and subscribe are the broker calls of a reconnect, connect and consume unless replaced in tests
+	dial      func() error
+	subscribe func(consumer) error
+
+	// RetryPolicy decides how many times a failed message is retried and how long it waits in the retry queue
+	RetryPolicy retry.Config
+	// ReconnectPolicy is the backoff between the attempts to reconnect after the broker went away
+	ReconnectPolicy retry.Config
+}
+
+// DefaultRetryPolicy retries a failed message 5 times, waiting from 1 second up to a minute between attempts
+func DefaultRetryPolicy() retry.Config {
+	return retry.Config{
+		MaxRetries:  5,
+		InitialWait: 1 * time.Second,
+		MaxWait:     1 * time.Minute,
+	}
+}
+
+// DefaultReconnectPolicy waits from 1 second up to 30 seconds between reconnect attempts
+func DefaultReconnectPolicy() retry.Config {
+	return retry.Config{
+		MaxRetries:  10,
+		InitialWait: 1 * time.Second,
+		MaxWait:     30 * time.Second,
+	}
 }
 
 func NewRabbitMQ(uri string) (*RabbitMQ, error) {
-	conn, err := amqp.Dial(uri)
-	if err != nil {
+	rmq := &RabbitMQ{
+		uri:             uri,
+		RetryPolicy:     DefaultRetryPolicy(),
+		ReconnectPolicy: DefaultReconnectPolicy(),
+	}
+	rmq.dial = rmq.connect
+	rmq.subscribe = rmq.consume
+
+	if err := rmq.connect(); err != nil {
 		return nil, err
 	}
+	return rmq, nil
+}
+
+// connect dials the broker, declares the topology and starts watching the new connection
+func (r *RabbitMQ) connect() error {
+	conn, err := amqp.Dial(r.uri)
+	if err != nil {
+		return err
+	}
 
 	ch, err := conn.Channel()
 	if err != nil {
 		conn.Close()
-		return nil, fmt.Errorf("failed to create channel: %v", err)
+		return fmt.Errorf("failed to create channel: %v", err)
 	}
 
-	rmq := &RabbitMQ{
-		conn:    conn,
-		Channel: ch,
+	// publisher confirms let every publish wait until the broker has taken responsibility for the message
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		conn.Close()
+		return fmt.Errorf("failed to enable publisher confirms: %v", err)
 	}
 
-	if err := rmq.setupExchangesAndQueues(); err != nil {
+	if err := setupExchangesAndQueues(ch, r.RetryPolicy); err != nil {
 		// Clean up if setup fails
-		rmq.Close()
-		return nil, fmt.Errorf("failed to setup exchanges and queues: %v", err)
+		ch.Close()
+		conn.Close()
+		return fmt.Errorf("failed to setup exchanges and queues: %v", err)
 	}
-	return rmq, nil
+
+	r.mu.Lock()
+	if r.closed {
+		// closed while reconnecting
+		r.mu.Unlock()
+		ch.Close()
+		conn.Close()
+		return nil
+	}
+	r.conn = conn
+	r.channel = ch
+	r.consumerTags = nil
+	r.mu.Unlock()
+
+	go r.watch(conn.NotifyClose(make(chan *amqp.Error, 1)), ch.NotifyClose(make(chan *amqp.Error, 1)), conn)
+
+	return nil
+}
+
+// watch waits until the connection or the channel is lost and reconnects, unless it was closed on purpose
+func (r *RabbitMQ) watch(connClosed, chClosed <-chan *amqp.Error, conn io.Closer) {
+	var reason *amqp.Error
+	select {
+	case reason = <-connClosed:
+	case reason = <-chClosed:
+		// a channel error leaves the connection open, it is replaced as a whole
+		conn.Close()
+	}
+
+	if r.isClosed() {
+		return
+	}
+	log.Printf("RabbitMQ connection lost: %v, reconnecting", reason)
+
+	r.reconnect()
+}
+
+// reconnect keeps trying with backoff until the broker is back and then resubscribes every consumer
+func (r *RabbitMQ) reconnect() {
+	for {
+		err := retry.WithBackoff(context.Background(), r.ReconnectPolicy, func() error {
+			if r.isClosed() {
+				return nil
+			}
+			return r.dial()
+		})
+		if err == nil {
+			break
+		}
+		log.Printf("ERROR: Failed to reconnect to RabbitMQ: %v", err)
+	}
+
+	if r.isClosed() {
+		return
+	}
+
+	r.mu.RLock()
+	consumers := append([]consumer(nil), r.consumers...)
+	r.mu.RUnlock()
+
+	for _, c := range consumers {
+		if err := r.subscribe(c); err != nil {
+			// closing the connection triggers another reconnect which subscribes again
+			log.Printf("ERROR: Failed to resubscribe to %s: %v", c.queueName, err)
+			r.currentConn().Close()
+			return
+		}
+	}
+	log.Printf("Reconnected to RabbitMQ, resubscribed %d consumers", len(consumers))
+}
+
+func (r *RabbitMQ) isClosed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.closed
+}
+
+func (r *RabbitMQ) currentConn() *amqp.Connection {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.conn
+}
+
+// currentChannel returns the channel of the current connection, it is replaced on every reconnect
+func (r *RabbitMQ) currentChannel() *amqp.Channel {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.channel
 }
 
 type MessageHandler func(context.Context, amqp.Delivery) error
 
-func (r *RabbitMQ) ConsumeMessages(queueName string, handler MessageHandler) error {
+// ConsumeMessages subscribes the handler to the queue, the subscription survives reconnects.
+// The handlers run with ctx, once it is cancelled no new messages are handed to them.
+// Shutdown cancels it as well when the handlers do not finish in time.
+func (r *RabbitMQ) ConsumeMessages(ctx context.Context, queueName string, handler MessageHandler, options ConsumerOptions) error {
+	ctx, cancel := context.WithCancel(ctx)
+	c := consumer{ctx: ctx, cancel: cancel, queueName: queueName, handler: handler, options: options.withDefaults()}
+	if err := r.consume(c); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.consumers = append(r.consumers, c)
+	r.mu.Unlock()
 
-	// Set prefetch count to 1 for fair dispatch
-	// This tells RabbitMQ not to give more than one message to a service at a time.
-	// The worker will only get the next message after it has acknowledged the previous one.
-	err := r.Channel.Qos(
-		1,     // prefetchCount: Limit to 1 unacknowledged message per consumer
-		0,     // prefetchSize: No specific limit on message size
-		false, // global: Apply prefetchCount to each consumer individually
+	return nil
+}
+
+func (r *RabbitMQ) consume(c consumer) error {
+	if c.ctx.Err() != nil {
+		// the consumer was stopped, it is not subscribed again after a reconnect
+		return nil
+	}
+
+	ch := r.currentChannel()
+
+	// the broker hands out at most prefetchCount unacknowledged messages to the consumer,
+	// the next message is sent once a worker acknowledged one
+	err := ch.Qos(
+		c.options.PrefetchCount, // prefetchCount
+		0,                       // prefetchSize: No specific limit on message size
+		false,                   // global: Apply prefetchCount to each consumer individually
 	)
 	if err != nil {
 		return fmt.Errorf("failed to set QoS: %v", err)
 	}
 
-	msgs, err := r.Channel.Consume(
-		queueName, // queue
-		"",        // consumer
-		false,     // auto-ack
-		false,     // exclusive
-		false,     // no-local
-		false,     // no-wait
-		nil,       // args
+	tag := c.queueName + "-" + uuid.NewString()
+	msgs, err := ch.Consume(
+		c.queueName, // queue
+		tag,         // consumer
+		false,       // auto-ack
+		false,       // exclusive
+		false,       // no-local
+		false,       // no-wait
+		nil,         // args
 	)
 	if err != nil {
 		return err
 	}
 
-	ctx := context.Background()
+	r.mu.Lock()
+	if r.closed {
+		// shut down while subscribing
+		r.mu.Unlock()
+		return ch.Cancel(tag, false)
+	}
+	r.consumerTags = append(r.consumerTags, tag)
+	r.inflight.Add(1)
+	r.mu.Unlock()
 
 	go func() {
-		for msg := range msgs {
-			log.Printf("Received a message: %s", msg.Body)
-
-			if err := handler(ctx, msg); err != nil {
-				log.Printf("ERROR: Failed to handle message: %v. Message body: %s", err, msg.Body)
-				// Nack the message. Set requeue to false to avoid immediate redelivery loops.
-				// Consider a dead-letter exchange (DLQ) or a more sophisticated retry mechanism for production.
-				if nackErr := msg.Nack(false, false); nackErr != nil {
-					log.Printf("ERROR: Failed to Nack message: %v", nackErr)
-				}
-
-				// Continue to the next message
-				continue
-			}
+		defer r.inflight.Done()
+		dispatch(c, msgs, r.retryOrPark)
+		// the deliveries are closed with the channel, the consumer is subscribed again after the reconnect
+		log.Printf("Consumer of %s stopped", c.queueName)
+	}()
+
+	return nil
+}
+
+// retryOrPark sends a failed message to the retry queue of its wait, which returns it to the queue once the wait is over.
+// After the last retry the message is rejected, so the dead letter exchange parks it in the dead letter queue.
+func (r *RabbitMQ) retryOrPark(ctx context.Context, queueName string, msg amqp.Delivery) {
+	retries := retryCount(msg.Headers)
 
-			// Only Ack if the handler succeeds
+	if retries < r.RetryPolicy.MaxRetries {
+		err := r.publishRetry(ctx, queueName, msg, retries+1)
+		if err == nil {
 			if ackErr := msg.Ack(false); ackErr != nil {
-				log.Printf("ERROR: Failed to Ack message: %v. Message body: %s", ackErr, msg.Body)
+				log.Printf("ERROR: Failed to Ack message: %v", ackErr)
 			}
+			return
+		}
+		log.Printf("ERROR: Failed to schedule retry of message: %v", err)
+	}
+
+	log.Printf("Parking message in %s after %d retries", DeadLetterQueue(queueName), retries)
+	if nackErr := msg.Nack(false, false); nackErr != nil {
+		log.Printf("ERROR: Failed to Nack message: %v", nackErr)
+	}
+}
+
+func (r *RabbitMQ) publishRetry(ctx context.Context, queueName string, msg amqp.Delivery, retries int) error {
+	headers := amqp.Table{}
+	for key, value := range msg.Headers {
+		headers[key] = value
+	}
+	headers[RetryCountHeader] = int32(retries)
 
+	// the retry queues of the policy are declared when connecting, this one is declared again
+	// in case the policy changed since, an unroutable retry would be lost
+	wait := r.RetryPolicy.Wait(retries)
+	if err := declareRetryQueue(r.currentChannel(), queueName, wait); err != nil {
+		return err
+	}
+
+	return r.publish(ctx,
+		RetryExchange,               // exchange
+		RetryQueue(queueName, wait), // routing key
+		amqp.Publishing{
+			ContentType:  msg.ContentType,
+			Headers:      headers,
+			Body:         msg.Body,
+			DeliveryMode: amqp.Persistent,
+		})
+}
+
+// retryWaits returns the distinct waits of the policy, each one has its own retry queue
+func retryWaits(policy retry.Config) []time.Duration {
+	var waits []time.Duration
+	for retries := 1; retries <= policy.MaxRetries; retries++ {
+		wait := policy.Wait(retries)
+		if len(waits) == 0 || waits[len(waits)-1] != wait {
+			waits = append(waits, wait)
 		}
-	}()
+	}
+	return waits
+}
 
-	return nil
+// retryCount reads the retry count header, messages that never failed have none
+func retryCount(headers amqp.Table) int {
+	switch count := headers[RetryCountHeader].(type) {
+	case int32:
+		return int(count)
+	case int64:
+		return int(count)
+	case int:
+		return count
+	default:
+		return 0
+	}
 }
 
-func (r *RabbitMQ) PublishMessage(ctx context.Context, routingKey string, message contracts.AmqpMessage) error {
+// DeadLetters returns up to limit messages parked in the dead letter queue of the queue.
+// The messages are only peeked at and stay in the dead letter queue.
+func (r *RabbitMQ) DeadLetters(queueName string, limit int) ([]amqp.Delivery, error) {
+	// the peeked messages are returned on a channel of their own, so the deliveries
+	// of the consumers on the shared channel are never touched
+	ch, err := r.currentConn().Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create channel: %v", err)
+	}
+	defer ch.Close()
+
+	var messages []amqp.Delivery
+	for len(messages) < limit {
+		msg, ok, err := ch.Get(DeadLetterQueue(queueName), false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get dead letter: %v", err)
+		}
+		if !ok {
+			break
+		}
+		messages = append(messages, msg)
+	}
+
+	for _, msg := range messages {
+		if err := msg.Nack(false, true); err != nil {
+			return nil, fmt.Errorf("failed to return dead letter: %v", err)
+		}
+	}
+
+	return messages, nil
+}
+
+// RedriveDeadLetters moves up to limit parked messages back to the queue with a fresh retry count
+// and returns how many were moved
+func (r *RabbitMQ) RedriveDeadLetters(ctx context.Context, queueName string, limit int) (int, error) {
+	redriven := 0
+	for redriven < limit {
+		msg, ok, err := r.currentChannel().Get(DeadLetterQueue(queueName), false)
+		if err != nil {
+			return redriven, fmt.Errorf("failed to get dead letter: %v", err)
+		}
+		if !ok {
+			break
+		}
+
+		headers := amqp.Table{}
+		for key, value := range msg.Headers {
+			if key != RetryCountHeader {
+				headers[key] = value
+			}
+		}
+
+		// the default exchange routes the message straight to the queue
+		err = r.publish(ctx, "", queueName, amqp.Publishing{
+			ContentType:  msg.ContentType,
+			Headers:      headers,
+			Body:         msg.Body,
+			DeliveryMode: amqp.Persistent,
+		})
+		if err != nil {
+			if nackErr := msg.Nack(false, true); nackErr != nil {
+				log.Printf("ERROR: Failed to return dead letter: %v", nackErr)
+			}
+			return redriven, fmt.Errorf("failed to redrive dead letter: %v", err)
+		}
+
+		if err := msg.Ack(false); err != nil {
+			return redriven, fmt.Errorf("failed to remove redriven dead letter: %v", err)
+		}
+		redriven++
+	}
+
+	return redriven, nil
+}
+
+// PublishMessage publishes the envelope to the user exchange
+func (r *RabbitMQ) PublishMessage(ctx context.Context, routingKey string, envelope *events.EventEnvelope) error {
 	log.Printf("Publishign message with routing key: %s", routingKey)
 
-	jsonMsg, err := json.Marshal(message)
+	msg, err := newPublishing(envelope)
 	if err != nil {
-		return fmt.Errorf("failed to marshal message: %v", err)
+		return err
 	}
-	return r.Channel.PublishWithContext(ctx,
+	return r.publish(ctx,
 		UserExchange, // exchange
 		routingKey,   // routing key
-		false,        // mandatory
-		false,        // immediate
-		amqp.Publishing{
-			ContentType:  "text/plain",
-			Body:         jsonMsg,
-			DeliveryMode: amqp.Persistent,
-		})
+		msg)
+}
+
+// newPublishing copies the metadata of the envelope to the message properties,
+// so the broker tooling can show it without decoding the body
+func newPublishing(envelope *events.EventEnvelope) (amqp.Publishing, error) {
+	body, err := EncodeEnvelope(envelope)
+	if err != nil {
+		return amqp.Publishing{}, err
+	}
+
+	return amqp.Publishing{
+		ContentType:  EnvelopeContentType,
+		Type:         envelope.GetEventType(),
+		MessageId:    envelope.GetEventId(),
+		AppId:        envelope.GetProducer(),
+		Timestamp:    envelope.GetOccurredAt().AsTime(),
+		Headers:      amqp.Table{OwnerIDHeader: envelope.GetOwnerId()},
+		Body:         body,
+		DeliveryMode: amqp.Persistent,
+	}, nil
+}
+
+// publish returns once the broker confirmed the message, a nil error means the message is stored
+func (r *RabbitMQ) publish(ctx context.Context, exchange, routingKey string, msg amqp.Publishing) error {
+	return publishConfirmed(ctx, r.currentChannel(), exchange, routingKey, msg)
+}
+
+// publishConfirmed publishes on a channel in confirm mode and waits for the confirmation
+func publishConfirmed(ctx context.Context, ch *amqp.Channel, exchange, routingKey string, msg amqp.Publishing) error {
+	confirmation, err := ch.PublishWithDeferredConfirmWithContext(ctx,
+		exchange,   // exchange
+		routingKey, // routing key
+		false,      // mandatory
+		false,      // immediate
+		msg,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to publish message: %v", err)
+	}
+
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to wait for publish confirmation: %v", err)
+	}
+	if !acked {
+		return fmt.Errorf("message was not confirmed by the broker")
+	}
+	return nil
 }
 
-func (r *RabbitMQ) setupExchangesAndQueues() error {
-	err := r.Channel.ExchangeDeclare(
+// setupExchangesAndQueues declares the whole topology, it runs again on every reconnect
+func setupExchangesAndQueues(ch *amqp.Channel, retryPolicy retry.Config) error {
+	err := ch.ExchangeDeclare(
 		UserExchange, // name
 		"topic",      // type
 		true,         // durable
@@ -134,20 +492,57 @@ func (r *RabbitMQ) setupExchangesAndQueues() error {
 		return fmt.Errorf("failed to decalare exchange: %s: %v", UserExchange, err)
 	}
 
-	if err := r.declareAndBindQueue(
-		SaveUserLocationQueue,
-		[]string{
-			RegisterLocationEventBind,
-		},
-		UserExchange,
-	); err != nil {
-		return err
+	for _, exchange := range []string{RetryExchange, DeadLetterExchange} {
+		if err := ch.ExchangeDeclare(
+			exchange, // name
+			"direct", // type
+			true,     // durable
+			false,    // auto-deleted
+			false,    // internal
+			false,    // no-wait
+			nil,      // arguments
+		); err != nil {
+			return fmt.Errorf("failed to decalare exchange: %s: %v", exchange, err)
+		}
+	}
+
+	for queueName, routingKeys := range QueueBindings {
+		if err := declareAndBindQueue(ch, queueName, routingKeys, UserExchange, retryWaits(retryPolicy)); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
-func (r *RabbitMQ) declareAndBindQueue(queueName string, messageTypes []string, exchange string) error {
-	q, err := r.Channel.QueueDeclare(
+
+// declareAndBindQueue declares the queue together with its retry and dead letter queues.
+// A message rejected by the consumer is dead-lettered to queueName.dlq, a message expiring
+// in one of the retry queues is dead-lettered back to the queue through the default exchange.
+func declareAndBindQueue(ch *amqp.Channel, queueName string, messageTypes []string, exchange string, retryWaits []time.Duration) error {
+	dlq, err := ch.QueueDeclare(
+		DeadLetterQueue(queueName), // name
+		true,                       // durable
+		false,                      // delete when unused
+		false,                      // exclusive
+		false,                      // no-wait
+		nil,                        // arguments
+	)
+	if err != nil {
+		return fmt.Errorf("failed to declare dead letter queue of %s: %v", queueName, err)
+	}
+	if err := ch.QueueBind(dlq.Name, queueName, DeadLetterExchange, false, nil); err != nil {
+		return fmt.Errorf("failed to bind dead letter queue of %s: %v", queueName, err)
+	}
+
+	for _, wait := range retryWaits {
+		if err := declareRetryQueue(ch, queueName, wait); err != nil {
+			return err
+		}
+	}
+
+	// the arguments of a declared queue cannot change, so the dead letter exchange
+	// is set by the user-dead-letters policy instead of a queue argument
+	q, err := ch.QueueDeclare(
 		queueName, // name
 		true,      // durable
 		false,     // delete when unused
@@ -156,11 +551,11 @@ func (r *RabbitMQ) declareAndBindQueue(queueName string, messageTypes []string,
 		nil,       // arguments
 	)
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("failed to declare queue %s: %v", queueName, err)
 	}
 
 	for _, msg := range messageTypes {
-		if err := r.Channel.QueueBind(
+		if err := ch.QueueBind(
 			q.Name,   // queue name
 			msg,      // routing key
 			exchange, // exchange
@@ -171,14 +566,87 @@ func (r *RabbitMQ) declareAndBindQueue(queueName string, messageTypes []string,
 		}
 	}
 
+	// unbinding a key that was never bound is a no-op
+	for _, routingKey := range legacyRoutingKeys {
+		if err := ch.QueueUnbind(q.Name, routingKey, exchange, nil); err != nil {
+			return fmt.Errorf("failed to unbind %s from %s: %v", queueName, routingKey, err)
+		}
+	}
+
 	return nil
 }
 
+// declareRetryQueue declares the retry queue of the wait. Its messages expire all after the same time,
+// so they leave in the order they came in and are dead-lettered back to the queue.
+func declareRetryQueue(ch *amqp.Channel, queueName string, wait time.Duration) error {
+	retryQueue, err := ch.QueueDeclare(
+		RetryQueue(queueName, wait), // name
+		true,                        // durable
+		false,                       // delete when unused
+		false,                       // exclusive
+		false,                       // no-wait
+		amqp.Table{
+			"x-message-ttl":             wait.Milliseconds(),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": queueName,
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to declare retry queue of %s: %v", queueName, err)
+	}
+	if err := ch.QueueBind(retryQueue.Name, retryQueue.Name, RetryExchange, false, nil); err != nil {
+		return fmt.Errorf("failed to bind retry queue of %s: %v", queueName, err)
+	}
+	return nil
+}
+
+// Shutdown stops taking messages and waits until the handlers finished the messages they hold,
+// including the ones prefetched before the subscriptions were cancelled. When ctx is done first
+// the handlers are cancelled and the unfinished messages are redelivered once the channel is closed.
+func (r *RabbitMQ) Shutdown(ctx context.Context) error {
+	r.mu.Lock()
+	r.closed = true
+	ch := r.channel
+	tags := r.consumerTags
+	consumers := r.consumers
+	r.mu.Unlock()
+
+	for _, tag := range tags {
+		if err := ch.Cancel(tag, false); err != nil {
+			log.Printf("ERROR: Failed to cancel consumer %s: %v", tag, err)
+		}
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		r.inflight.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		for _, c := range consumers {
+			c.cancel()
+		}
+		err = fmt.Errorf("in-flight messages not finished before the deadline: %v", ctx.Err())
+	}
+
+	r.Close()
+	return err
+}
+
+// Close closes the channel and then its connection without waiting for the handlers, see Shutdown
 func (r *RabbitMQ) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closed = true
+	if r.channel != nil {
+		r.channel.Close()
+	}
 	if r.conn != nil {
 		r.conn.Close()
 	}
-	if r.Channel != nil {
-		r.Channel.Close()
-	}
 }
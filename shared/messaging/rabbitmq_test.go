@@ -0,0 +1,169 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"go-clinet-locations/shared/retry"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestRetryCount(t *testing.T) {
+	tests := []struct {
+		name     string
+		headers  amqp.Table
+		expected int
+	}{
+		{name: "no headers", headers: nil, expected: 0},
+		{name: "header missing", headers: amqp.Table{"other": "value"}, expected: 0},
+		{name: "int32 header as published", headers: amqp.Table{RetryCountHeader: int32(2)}, expected: 2},
+		{name: "int64 header", headers: amqp.Table{RetryCountHeader: int64(3)}, expected: 3},
+		{name: "unexpected type", headers: amqp.Table{RetryCountHeader: "3"}, expected: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if count := retryCount(tt.headers); count != tt.expected {
+				t.Errorf("expected %d, got %d", tt.expected, count)
+			}
+		})
+	}
+}
+
+func TestRetryWaits(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   retry.Config
+		expected []time.Duration
+	}{
+		{
+			name:     "default policy",
+			policy:   DefaultRetryPolicy(),
+			expected: []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second},
+		},
+		{
+			name:     "waits capped by MaxWait share a queue",
+			policy:   retry.Config{MaxRetries: 4, InitialWait: time.Second, MaxWait: 2 * time.Second},
+			expected: []time.Duration{time.Second, 2 * time.Second},
+		},
+		{
+			name:   "no retries",
+			policy: retry.Config{MaxRetries: 0, InitialWait: time.Second, MaxWait: time.Minute},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			waits := retryWaits(tt.policy)
+			if len(waits) != len(tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, waits)
+			}
+			for i := range waits {
+				if waits[i] != tt.expected[i] {
+					t.Errorf("expected %v, got %v", tt.expected, waits)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryQueue(t *testing.T) {
+	if name := RetryQueue(SaveUserLocationQueue, 1500*time.Millisecond); name != SaveUserLocationQueue+".retry.1500ms" {
+		t.Errorf("expected %s.retry.1500ms, got %s", SaveUserLocationQueue, name)
+	}
+}
+
+// fakeConn records whether watch closed the connection
+type fakeConn struct {
+	closed bool
+}
+
+func (f *fakeConn) Close() error {
+	f.closed = true
+	return nil
+}
+
+func TestRabbitMQ_WatchReconnects(t *testing.T) {
+	tests := []struct {
+		name string
+		// channelError closes the channel instead of the connection
+		channelError bool
+		// failedDials is the number of dials failing before the broker is back
+		failedDials int
+		// shutdown closes the client before the connection is lost
+		shutdown bool
+		// closeWhileDialing closes the client while it reconnects
+		closeWhileDialing bool
+		expectedDials     int
+		expectedSubscribe int
+	}{
+		{name: "connection lost", expectedDials: 1, expectedSubscribe: 1},
+		{name: "channel error", channelError: true, expectedDials: 1, expectedSubscribe: 1},
+		{name: "broker back after two attempts", failedDials: 2, expectedDials: 3, expectedSubscribe: 1},
+		{name: "lost after shutdown", shutdown: true, expectedDials: 0, expectedSubscribe: 0},
+		{name: "closed while reconnecting", closeWhileDialing: true, expectedDials: 1, expectedSubscribe: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &RabbitMQ{
+				ReconnectPolicy: retry.Config{MaxRetries: 5, InitialWait: time.Millisecond, MaxWait: time.Millisecond},
+			}
+
+			dials := 0
+			r.dial = func() error {
+				dials++
+				if tt.closeWhileDialing {
+					r.Close()
+				}
+				if dials <= tt.failedDials {
+					return errors.New("connection refused")
+				}
+				return nil
+			}
+			subscribed := make(map[string]int)
+			r.subscribe = func(c consumer) error {
+				subscribed[c.queueName]++
+				return nil
+			}
+
+			for _, queueName := range []string{"first", "second"} {
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				r.consumers = append(r.consumers, consumer{ctx: ctx, cancel: cancel, queueName: queueName})
+			}
+
+			if tt.shutdown {
+				if err := r.Shutdown(context.Background()); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			connClosed := make(chan *amqp.Error, 1)
+			chClosed := make(chan *amqp.Error, 1)
+			reason := &amqp.Error{Code: amqp.ConnectionForced, Reason: "broker restarted"}
+			if tt.channelError {
+				chClosed <- reason
+			} else {
+				connClosed <- reason
+			}
+			conn := &fakeConn{}
+
+			r.watch(connClosed, chClosed, conn)
+
+			if dials != tt.expectedDials {
+				t.Errorf("expected %d dials, got %d", tt.expectedDials, dials)
+			}
+			for _, queueName := range []string{"first", "second"} {
+				if subscribed[queueName] != tt.expectedSubscribe {
+					t.Errorf("expected %s to be subscribed %d times, got %d", queueName, tt.expectedSubscribe, subscribed[queueName])
+				}
+			}
+			if conn.closed != tt.channelError {
+				t.Errorf("expected the connection closed to be %v, got %v", tt.channelError, conn.closed)
+			}
+		})
+	}
+}
@@ -237,6 +237,229 @@ func (x *Coordinate) GetLongitude() float64 {
 	return 0
 }
 
+type ErasureReportRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	UserId        string                 `protobuf:"bytes,1,opt,name=userId,proto3" json:"userId,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ErasureReportRequest) Reset() {
+	*x = ErasureReportRequest{}
+	mi := &file_location_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ErasureReportRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ErasureReportRequest) ProtoMessage() {}
+
+func (x *ErasureReportRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_location_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ErasureReportRequest.ProtoReflect.Descriptor instead.
+func (*ErasureReportRequest) Descriptor() ([]byte, []int) {
+	return file_location_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ErasureReportRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+// ErasureReportResponse confirms the location history of a deleted user was erased
+type ErasureReportResponse struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	UserId string                 `protobuf:"bytes,1,opt,name=userId,proto3" json:"userId,omitempty"`
+	// RFC 3339 time the history was erased
+	ErasedAt string `protobuf:"bytes,2,opt,name=erasedAt,proto3" json:"erasedAt,omitempty"`
+	// removedLocations is the number of stored fixes that were deleted
+	RemovedLocations int64 `protobuf:"varint,3,opt,name=removedLocations,proto3" json:"removedLocations,omitempty"`
+	// droppedLocations is the number of fixes that arrived after the erasure and were not stored
+	DroppedLocations int64 `protobuf:"varint,4,opt,name=droppedLocations,proto3" json:"droppedLocations,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *ErasureReportResponse) Reset() {
+	*x = ErasureReportResponse{}
+	mi := &file_location_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ErasureReportResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ErasureReportResponse) ProtoMessage() {}
+
+func (x *ErasureReportResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_location_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ErasureReportResponse.ProtoReflect.Descriptor instead.
+func (*ErasureReportResponse) Descriptor() ([]byte, []int) {
+	return file_location_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ErasureReportResponse) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *ErasureReportResponse) GetErasedAt() string {
+	if x != nil {
+		return x.ErasedAt
+	}
+	return ""
+}
+
+func (x *ErasureReportResponse) GetRemovedLocations() int64 {
+	if x != nil {
+		return x.RemovedLocations
+	}
+	return 0
+}
+
+func (x *ErasureReportResponse) GetDroppedLocations() int64 {
+	if x != nil {
+		return x.DroppedLocations
+	}
+	return 0
+}
+
+// ExportHistoryRequest selects the fixes taken from startDate inclusive to endDate exclusive,
+// both are RFC 3339 and the range is open on the side that is empty
+type ExportHistoryRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	UserId        string                 `protobuf:"bytes,1,opt,name=userId,proto3" json:"userId,omitempty"`
+	StartDate     string                 `protobuf:"bytes,2,opt,name=startDate,proto3" json:"startDate,omitempty"`
+	EndDate       string                 `protobuf:"bytes,3,opt,name=endDate,proto3" json:"endDate,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ExportHistoryRequest) Reset() {
+	*x = ExportHistoryRequest{}
+	mi := &file_location_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ExportHistoryRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExportHistoryRequest) ProtoMessage() {}
+
+func (x *ExportHistoryRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_location_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExportHistoryRequest.ProtoReflect.Descriptor instead.
+func (*ExportHistoryRequest) Descriptor() ([]byte, []int) {
+	return file_location_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ExportHistoryRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *ExportHistoryRequest) GetStartDate() string {
+	if x != nil {
+		return x.StartDate
+	}
+	return ""
+}
+
+func (x *ExportHistoryRequest) GetEndDate() string {
+	if x != nil {
+		return x.EndDate
+	}
+	return ""
+}
+
+type ExportHistoryResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// records have an RFC 3339 timestamp
+	Records       []*LocationRecord `protobuf:"bytes,1,rep,name=records,proto3" json:"records,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ExportHistoryResponse) Reset() {
+	*x = ExportHistoryResponse{}
+	mi := &file_location_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ExportHistoryResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExportHistoryResponse) ProtoMessage() {}
+
+func (x *ExportHistoryResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_location_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExportHistoryResponse.ProtoReflect.Descriptor instead.
+func (*ExportHistoryResponse) Descriptor() ([]byte, []int) {
+	return file_location_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *ExportHistoryResponse) GetRecords() []*LocationRecord {
+	if x != nil {
+		return x.Records
+	}
+	return nil
+}
+
 var File_location_proto protoreflect.FileDescriptor
 
 const file_location_proto_rawDesc = "" +
@@ -257,9 +480,24 @@ const file_location_proto_rawDesc = "" +
 	"\n" +
 	"Coordinate\x12\x1a\n" +
 	"\blatitude\x18\x01 \x01(\x01R\blatitude\x12\x1c\n" +
-	"\tlongitude\x18\x02 \x01(\x01R\tlongitude2o\n" +
+	"\tlongitude\x18\x02 \x01(\x01R\tlongitude\".\n" +
+	"\x14ErasureReportRequest\x12\x16\n" +
+	"\x06userId\x18\x01 \x01(\tR\x06userId\"\xa3\x01\n" +
+	"\x15ErasureReportResponse\x12\x16\n" +
+	"\x06userId\x18\x01 \x01(\tR\x06userId\x12\x1a\n" +
+	"\berasedAt\x18\x02 \x01(\tR\berasedAt\x12*\n" +
+	"\x10removedLocations\x18\x03 \x01(\x03R\x10removedLocations\x12*\n" +
+	"\x10droppedLocations\x18\x04 \x01(\x03R\x10droppedLocations\"f\n" +
+	"\x14ExportHistoryRequest\x12\x16\n" +
+	"\x06userId\x18\x01 \x01(\tR\x06userId\x12\x1c\n" +
+	"\tstartDate\x18\x02 \x01(\tR\tstartDate\x12\x18\n" +
+	"\aendDate\x18\x03 \x01(\tR\aendDate\"K\n" +
+	"\x15ExportHistoryResponse\x122\n" +
+	"\arecords\x18\x01 \x03(\v2\x18.location.LocationRecordR\arecords2\x98\x02\n" +
 	"\x0fLocationService\x12\\\n" +
-	"\x11CalculateDistance\x12\".location.CalculateDistanceRequest\x1a#.location.CalculateDistanceResponseB\x17Z\x15shared/proto/locationb\x06proto3"
+	"\x11CalculateDistance\x12\".location.CalculateDistanceRequest\x1a#.location.CalculateDistanceResponse\x12S\n" +
+	"\x10GetErasureReport\x12\x1e.location.ErasureReportRequest\x1a\x1f.location.ErasureReportResponse\x12R\n" +
+	"\rExportHistory\x12\x1e.location.ExportHistoryRequest\x1a\x1f.location.ExportHistoryResponse0\x01B\x17Z\x15shared/proto/locationb\x06proto3"
 
 var (
 	file_location_proto_rawDescOnce sync.Once
@@ -273,23 +511,32 @@ func file_location_proto_rawDescGZIP() []byte {
 	return file_location_proto_rawDescData
 }
 
-var file_location_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_location_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
 var file_location_proto_goTypes = []any{
 	(*CalculateDistanceRequest)(nil),  // 0: location.CalculateDistanceRequest
 	(*CalculateDistanceResponse)(nil), // 1: location.CalculateDistanceResponse
 	(*LocationRecord)(nil),            // 2: location.LocationRecord
 	(*Coordinate)(nil),                // 3: location.Coordinate
+	(*ErasureReportRequest)(nil),      // 4: location.ErasureReportRequest
+	(*ErasureReportResponse)(nil),     // 5: location.ErasureReportResponse
+	(*ExportHistoryRequest)(nil),      // 6: location.ExportHistoryRequest
+	(*ExportHistoryResponse)(nil),     // 7: location.ExportHistoryResponse
 }
 var file_location_proto_depIdxs = []int32{
 	2, // 0: location.CalculateDistanceResponse.history:type_name -> location.LocationRecord
 	3, // 1: location.LocationRecord.coordinate:type_name -> location.Coordinate
-	0, // 2: location.LocationService.CalculateDistance:input_type -> location.CalculateDistanceRequest
-	1, // 3: location.LocationService.CalculateDistance:output_type -> location.CalculateDistanceResponse
-	3, // [3:4] is the sub-list for method output_type
-	2, // [2:3] is the sub-list for method input_type
-	2, // [2:2] is the sub-list for extension type_name
-	2, // [2:2] is the sub-list for extension extendee
-	0, // [0:2] is the sub-list for field type_name
+	2, // 2: location.ExportHistoryResponse.records:type_name -> location.LocationRecord
+	0, // 3: location.LocationService.CalculateDistance:input_type -> location.CalculateDistanceRequest
+	4, // 4: location.LocationService.GetErasureReport:input_type -> location.ErasureReportRequest
+	6, // 5: location.LocationService.ExportHistory:input_type -> location.ExportHistoryRequest
+	1, // 6: location.LocationService.CalculateDistance:output_type -> location.CalculateDistanceResponse
+	5, // 7: location.LocationService.GetErasureReport:output_type -> location.ErasureReportResponse
+	7, // 8: location.LocationService.ExportHistory:output_type -> location.ExportHistoryResponse
+	6, // [6:9] is the sub-list for method output_type
+	3, // [3:6] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
 }
 
 func init() { file_location_proto_init() }
@@ -303,7 +550,7 @@ func file_location_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_location_proto_rawDesc), len(file_location_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   4,
+			NumMessages:   8,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -20,6 +20,8 @@ const _ = grpc.SupportPackageIsVersion9
 
 const (
 	LocationService_CalculateDistance_FullMethodName = "/location.LocationService/CalculateDistance"
+	LocationService_GetErasureReport_FullMethodName  = "/location.LocationService/GetErasureReport"
+	LocationService_ExportHistory_FullMethodName     = "/location.LocationService/ExportHistory"
 )
 
 // LocationServiceClient is the client API for LocationService service.
@@ -27,6 +29,9 @@ const (
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type LocationServiceClient interface {
 	CalculateDistance(ctx context.Context, in *CalculateDistanceRequest, opts ...grpc.CallOption) (*CalculateDistanceResponse, error)
+	GetErasureReport(ctx context.Context, in *ErasureReportRequest, opts ...grpc.CallOption) (*ErasureReportResponse, error)
+	// ExportHistory streams the fixes of the user oldest first, in chunks
+	ExportHistory(ctx context.Context, in *ExportHistoryRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ExportHistoryResponse], error)
 }
 
 type locationServiceClient struct {
@@ -47,11 +52,43 @@ func (c *locationServiceClient) CalculateDistance(ctx context.Context, in *Calcu
 	return out, nil
 }
 
+func (c *locationServiceClient) GetErasureReport(ctx context.Context, in *ErasureReportRequest, opts ...grpc.CallOption) (*ErasureReportResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ErasureReportResponse)
+	err := c.cc.Invoke(ctx, LocationService_GetErasureReport_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *locationServiceClient) ExportHistory(ctx context.Context, in *ExportHistoryRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ExportHistoryResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &LocationService_ServiceDesc.Streams[0], LocationService_ExportHistory_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ExportHistoryRequest, ExportHistoryResponse]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type LocationService_ExportHistoryClient = grpc.ServerStreamingClient[ExportHistoryResponse]
+
 // LocationServiceServer is the server API for LocationService service.
 // All implementations must embed UnimplementedLocationServiceServer
 // for forward compatibility.
 type LocationServiceServer interface {
 	CalculateDistance(context.Context, *CalculateDistanceRequest) (*CalculateDistanceResponse, error)
+	GetErasureReport(context.Context, *ErasureReportRequest) (*ErasureReportResponse, error)
+	// ExportHistory streams the fixes of the user oldest first, in chunks
+	ExportHistory(*ExportHistoryRequest, grpc.ServerStreamingServer[ExportHistoryResponse]) error
 	mustEmbedUnimplementedLocationServiceServer()
 }
 
@@ -65,6 +102,12 @@ type UnimplementedLocationServiceServer struct{}
 func (UnimplementedLocationServiceServer) CalculateDistance(context.Context, *CalculateDistanceRequest) (*CalculateDistanceResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method CalculateDistance not implemented")
 }
+func (UnimplementedLocationServiceServer) GetErasureReport(context.Context, *ErasureReportRequest) (*ErasureReportResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetErasureReport not implemented")
+}
+func (UnimplementedLocationServiceServer) ExportHistory(*ExportHistoryRequest, grpc.ServerStreamingServer[ExportHistoryResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method ExportHistory not implemented")
+}
 func (UnimplementedLocationServiceServer) mustEmbedUnimplementedLocationServiceServer() {}
 func (UnimplementedLocationServiceServer) testEmbeddedByValue()                         {}
 
@@ -104,6 +147,35 @@ func _LocationService_CalculateDistance_Handler(srv interface{}, ctx context.Con
 	return interceptor(ctx, in, info, handler)
 }
 
+func _LocationService_GetErasureReport_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ErasureReportRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LocationServiceServer).GetErasureReport(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LocationService_GetErasureReport_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LocationServiceServer).GetErasureReport(ctx, req.(*ErasureReportRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LocationService_ExportHistory_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ExportHistoryRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(LocationServiceServer).ExportHistory(m, &grpc.GenericServerStream[ExportHistoryRequest, ExportHistoryResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type LocationService_ExportHistoryServer = grpc.ServerStreamingServer[ExportHistoryResponse]
+
 // LocationService_ServiceDesc is the grpc.ServiceDesc for LocationService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -115,7 +187,17 @@ var LocationService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "CalculateDistance",
 			Handler:    _LocationService_CalculateDistance_Handler,
 		},
+		{
+			MethodName: "GetErasureReport",
+			Handler:    _LocationService_GetErasureReport_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ExportHistory",
+			Handler:       _LocationService_ExportHistory_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "location.proto",
 }
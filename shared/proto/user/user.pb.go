@@ -22,10 +22,12 @@ const (
 )
 
 type User struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	ID            string                 `protobuf:"bytes,1,opt,name=ID,proto3" json:"ID,omitempty"`
-	UserName      string                 `protobuf:"bytes,2,opt,name=userName,proto3" json:"userName,omitempty"`
-	Coordinate    *Coordinate            `protobuf:"bytes,3,opt,name=coordinate,proto3" json:"coordinate,omitempty"`
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	ID         string                 `protobuf:"bytes,1,opt,name=ID,proto3" json:"ID,omitempty"`
+	UserName   string                 `protobuf:"bytes,2,opt,name=userName,proto3" json:"userName,omitempty"`
+	Coordinate *Coordinate            `protobuf:"bytes,3,opt,name=coordinate,proto3" json:"coordinate,omitempty"`
+	// distance in km from the search centre, only set on search results
+	Distance      float64 `protobuf:"fixed64,4,opt,name=distance,proto3" json:"distance,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -81,6 +83,13 @@ func (x *User) GetCoordinate() *Coordinate {
 	return nil
 }
 
+func (x *User) GetDistance() float64 {
+	if x != nil {
+		return x.Distance
+	}
+	return 0
+}
+
 type Coordinate struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Latitude      float64                `protobuf:"fixed64,1,opt,name=latitude,proto3" json:"latitude,omitempty"`
@@ -178,9 +187,11 @@ func (x *CreateUserResponse) GetUser() *User {
 }
 
 type UpdateUserRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	UserName      string                 `protobuf:"bytes,1,opt,name=userName,proto3" json:"userName,omitempty"`
-	Coordinate    *Coordinate            `protobuf:"bytes,2,opt,name=coordinate,proto3" json:"coordinate,omitempty"`
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	UserName   string                 `protobuf:"bytes,1,opt,name=userName,proto3" json:"userName,omitempty"`
+	Coordinate *Coordinate            `protobuf:"bytes,2,opt,name=coordinate,proto3" json:"coordinate,omitempty"`
+	// RFC 3339 time the device took the fix, the time of consumption is used when empty
+	RecordedAt    string `protobuf:"bytes,3,opt,name=recordedAt,proto3" json:"recordedAt,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -229,6 +240,13 @@ func (x *UpdateUserRequest) GetCoordinate() *Coordinate {
 	return nil
 }
 
+func (x *UpdateUserRequest) GetRecordedAt() string {
+	if x != nil {
+		return x.RecordedAt
+	}
+	return ""
+}
+
 type UpdateUserResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	User          *User                  `protobuf:"bytes,1,opt,name=user,proto3" json:"user,omitempty"`
@@ -274,9 +292,22 @@ func (x *UpdateUserResponse) GetUser() *User {
 }
 
 type SearchUsersRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Coordinate    *Coordinate            `protobuf:"bytes,1,opt,name=coordinate,proto3" json:"coordinate,omitempty"`
-	Radius        float32                `protobuf:"fixed32,2,opt,name=radius,proto3" json:"radius,omitempty"`
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// coordinate and radius describe the circle searched when no area is set
+	Coordinate *Coordinate `protobuf:"bytes,1,opt,name=coordinate,proto3" json:"coordinate,omitempty"`
+	Radius     float32     `protobuf:"fixed32,2,opt,name=radius,proto3" json:"radius,omitempty"`
+	// pageSize limits the number of users returned, the service applies a default when empty
+	PageSize int32 `protobuf:"varint,3,opt,name=pageSize,proto3" json:"pageSize,omitempty"`
+	// pageToken is the opaque nextPageToken returned by the previous page
+	PageToken string `protobuf:"bytes,4,opt,name=pageToken,proto3" json:"pageToken,omitempty"`
+	// sort is either "distance" (default, nearest first) or "userName"
+	Sort string `protobuf:"bytes,5,opt,name=sort,proto3" json:"sort,omitempty"`
+	// Types that are valid to be assigned to Area:
+	//
+	//	*SearchUsersRequest_Circle
+	//	*SearchUsersRequest_Bbox
+	//	*SearchUsersRequest_Polygon
+	Area          isSearchUsersRequest_Area `protobuf_oneof:"area"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -325,16 +356,246 @@ func (x *SearchUsersRequest) GetRadius() float32 {
 	return 0
 }
 
-type SearchUsersResponse struct {
+func (x *SearchUsersRequest) GetPageSize() int32 {
+	if x != nil {
+		return x.PageSize
+	}
+	return 0
+}
+
+func (x *SearchUsersRequest) GetPageToken() string {
+	if x != nil {
+		return x.PageToken
+	}
+	return ""
+}
+
+func (x *SearchUsersRequest) GetSort() string {
+	if x != nil {
+		return x.Sort
+	}
+	return ""
+}
+
+func (x *SearchUsersRequest) GetArea() isSearchUsersRequest_Area {
+	if x != nil {
+		return x.Area
+	}
+	return nil
+}
+
+func (x *SearchUsersRequest) GetCircle() *Circle {
+	if x != nil {
+		if x, ok := x.Area.(*SearchUsersRequest_Circle); ok {
+			return x.Circle
+		}
+	}
+	return nil
+}
+
+func (x *SearchUsersRequest) GetBbox() *BoundingBox {
+	if x != nil {
+		if x, ok := x.Area.(*SearchUsersRequest_Bbox); ok {
+			return x.Bbox
+		}
+	}
+	return nil
+}
+
+func (x *SearchUsersRequest) GetPolygon() *Polygon {
+	if x != nil {
+		if x, ok := x.Area.(*SearchUsersRequest_Polygon); ok {
+			return x.Polygon
+		}
+	}
+	return nil
+}
+
+type isSearchUsersRequest_Area interface {
+	isSearchUsersRequest_Area()
+}
+
+type SearchUsersRequest_Circle struct {
+	Circle *Circle `protobuf:"bytes,6,opt,name=circle,proto3,oneof"`
+}
+
+type SearchUsersRequest_Bbox struct {
+	Bbox *BoundingBox `protobuf:"bytes,7,opt,name=bbox,proto3,oneof"`
+}
+
+type SearchUsersRequest_Polygon struct {
+	Polygon *Polygon `protobuf:"bytes,8,opt,name=polygon,proto3,oneof"`
+}
+
+func (*SearchUsersRequest_Circle) isSearchUsersRequest_Area() {}
+
+func (*SearchUsersRequest_Bbox) isSearchUsersRequest_Area() {}
+
+func (*SearchUsersRequest_Polygon) isSearchUsersRequest_Area() {}
+
+type Circle struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Center *Coordinate            `protobuf:"bytes,1,opt,name=center,proto3" json:"center,omitempty"`
+	// radius in km
+	Radius        float64 `protobuf:"fixed64,2,opt,name=radius,proto3" json:"radius,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Circle) Reset() {
+	*x = Circle{}
+	mi := &file_user_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Circle) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Circle) ProtoMessage() {}
+
+func (x *Circle) ProtoReflect() protoreflect.Message {
+	mi := &file_user_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Circle.ProtoReflect.Descriptor instead.
+func (*Circle) Descriptor() ([]byte, []int) {
+	return file_user_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *Circle) GetCenter() *Coordinate {
+	if x != nil {
+		return x.Center
+	}
+	return nil
+}
+
+func (x *Circle) GetRadius() float64 {
+	if x != nil {
+		return x.Radius
+	}
+	return 0
+}
+
+// BoundingBox crosses the antimeridian when southWest is east of northEast
+type BoundingBox struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Users         []*User                `protobuf:"bytes,1,rep,name=users,proto3" json:"users,omitempty"`
+	SouthWest     *Coordinate            `protobuf:"bytes,1,opt,name=southWest,proto3" json:"southWest,omitempty"`
+	NorthEast     *Coordinate            `protobuf:"bytes,2,opt,name=northEast,proto3" json:"northEast,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BoundingBox) Reset() {
+	*x = BoundingBox{}
+	mi := &file_user_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BoundingBox) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BoundingBox) ProtoMessage() {}
+
+func (x *BoundingBox) ProtoReflect() protoreflect.Message {
+	mi := &file_user_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BoundingBox.ProtoReflect.Descriptor instead.
+func (*BoundingBox) Descriptor() ([]byte, []int) {
+	return file_user_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *BoundingBox) GetSouthWest() *Coordinate {
+	if x != nil {
+		return x.SouthWest
+	}
+	return nil
+}
+
+func (x *BoundingBox) GetNorthEast() *Coordinate {
+	if x != nil {
+		return x.NorthEast
+	}
+	return nil
+}
+
+// Polygon is a single ring without holes, distances are measured from the average of its vertices
+type Polygon struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Vertices      []*Coordinate          `protobuf:"bytes,1,rep,name=vertices,proto3" json:"vertices,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Polygon) Reset() {
+	*x = Polygon{}
+	mi := &file_user_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Polygon) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Polygon) ProtoMessage() {}
+
+func (x *Polygon) ProtoReflect() protoreflect.Message {
+	mi := &file_user_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Polygon.ProtoReflect.Descriptor instead.
+func (*Polygon) Descriptor() ([]byte, []int) {
+	return file_user_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *Polygon) GetVertices() []*Coordinate {
+	if x != nil {
+		return x.Vertices
+	}
+	return nil
+}
+
+type SearchUsersResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Users []*User                `protobuf:"bytes,1,rep,name=users,proto3" json:"users,omitempty"`
+	// nextPageToken is empty when there are no more results
+	NextPageToken string `protobuf:"bytes,2,opt,name=nextPageToken,proto3" json:"nextPageToken,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *SearchUsersResponse) Reset() {
 	*x = SearchUsersResponse{}
-	mi := &file_user_proto_msgTypes[6]
+	mi := &file_user_proto_msgTypes[9]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -346,7 +607,7 @@ func (x *SearchUsersResponse) String() string {
 func (*SearchUsersResponse) ProtoMessage() {}
 
 func (x *SearchUsersResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_user_proto_msgTypes[6]
+	mi := &file_user_proto_msgTypes[9]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -359,7 +620,7 @@ func (x *SearchUsersResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use SearchUsersResponse.ProtoReflect.Descriptor instead.
 func (*SearchUsersResponse) Descriptor() ([]byte, []int) {
-	return file_user_proto_rawDescGZIP(), []int{6}
+	return file_user_proto_rawDescGZIP(), []int{9}
 }
 
 func (x *SearchUsersResponse) GetUsers() []*User {
@@ -369,47 +630,507 @@ func (x *SearchUsersResponse) GetUsers() []*User {
 	return nil
 }
 
+func (x *SearchUsersResponse) GetNextPageToken() string {
+	if x != nil {
+		return x.NextPageToken
+	}
+	return ""
+}
+
+type NearestUsersRequest struct {
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	Coordinate *Coordinate            `protobuf:"bytes,1,opt,name=coordinate,proto3" json:"coordinate,omitempty"`
+	// k is the number of users to return, the service applies a default when empty
+	K             int32 `protobuf:"varint,2,opt,name=k,proto3" json:"k,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *NearestUsersRequest) Reset() {
+	*x = NearestUsersRequest{}
+	mi := &file_user_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *NearestUsersRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NearestUsersRequest) ProtoMessage() {}
+
+func (x *NearestUsersRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_user_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NearestUsersRequest.ProtoReflect.Descriptor instead.
+func (*NearestUsersRequest) Descriptor() ([]byte, []int) {
+	return file_user_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *NearestUsersRequest) GetCoordinate() *Coordinate {
+	if x != nil {
+		return x.Coordinate
+	}
+	return nil
+}
+
+func (x *NearestUsersRequest) GetK() int32 {
+	if x != nil {
+		return x.K
+	}
+	return 0
+}
+
+type NearestUsersResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// users ordered nearest first, with distance set
+	Users         []*User `protobuf:"bytes,1,rep,name=users,proto3" json:"users,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *NearestUsersResponse) Reset() {
+	*x = NearestUsersResponse{}
+	mi := &file_user_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *NearestUsersResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NearestUsersResponse) ProtoMessage() {}
+
+func (x *NearestUsersResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_user_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NearestUsersResponse.ProtoReflect.Descriptor instead.
+func (*NearestUsersResponse) Descriptor() ([]byte, []int) {
+	return file_user_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *NearestUsersResponse) GetUsers() []*User {
+	if x != nil {
+		return x.Users
+	}
+	return nil
+}
+
+// GetUserRequest identifies the user by exactly one of ID and userName
+type GetUserRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ID            string                 `protobuf:"bytes,1,opt,name=ID,proto3" json:"ID,omitempty"`
+	UserName      string                 `protobuf:"bytes,2,opt,name=userName,proto3" json:"userName,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetUserRequest) Reset() {
+	*x = GetUserRequest{}
+	mi := &file_user_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetUserRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetUserRequest) ProtoMessage() {}
+
+func (x *GetUserRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_user_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetUserRequest.ProtoReflect.Descriptor instead.
+func (*GetUserRequest) Descriptor() ([]byte, []int) {
+	return file_user_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *GetUserRequest) GetID() string {
+	if x != nil {
+		return x.ID
+	}
+	return ""
+}
+
+func (x *GetUserRequest) GetUserName() string {
+	if x != nil {
+		return x.UserName
+	}
+	return ""
+}
+
+type GetUserResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	User          *User                  `protobuf:"bytes,1,opt,name=user,proto3" json:"user,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetUserResponse) Reset() {
+	*x = GetUserResponse{}
+	mi := &file_user_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetUserResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetUserResponse) ProtoMessage() {}
+
+func (x *GetUserResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_user_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetUserResponse.ProtoReflect.Descriptor instead.
+func (*GetUserResponse) Descriptor() ([]byte, []int) {
+	return file_user_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *GetUserResponse) GetUser() *User {
+	if x != nil {
+		return x.User
+	}
+	return nil
+}
+
+// ListUsersRequest pages through every user ordered by userName
+type ListUsersRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// pageSize limits the number of users returned, the service applies a default when empty
+	PageSize int32 `protobuf:"varint,1,opt,name=pageSize,proto3" json:"pageSize,omitempty"`
+	// pageToken is the opaque nextPageToken returned by the previous page
+	PageToken     string `protobuf:"bytes,2,opt,name=pageToken,proto3" json:"pageToken,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListUsersRequest) Reset() {
+	*x = ListUsersRequest{}
+	mi := &file_user_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListUsersRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListUsersRequest) ProtoMessage() {}
+
+func (x *ListUsersRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_user_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListUsersRequest.ProtoReflect.Descriptor instead.
+func (*ListUsersRequest) Descriptor() ([]byte, []int) {
+	return file_user_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *ListUsersRequest) GetPageSize() int32 {
+	if x != nil {
+		return x.PageSize
+	}
+	return 0
+}
+
+func (x *ListUsersRequest) GetPageToken() string {
+	if x != nil {
+		return x.PageToken
+	}
+	return ""
+}
+
+type ListUsersResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Users []*User                `protobuf:"bytes,1,rep,name=users,proto3" json:"users,omitempty"`
+	// nextPageToken is empty when there are no more users
+	NextPageToken string `protobuf:"bytes,2,opt,name=nextPageToken,proto3" json:"nextPageToken,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListUsersResponse) Reset() {
+	*x = ListUsersResponse{}
+	mi := &file_user_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListUsersResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListUsersResponse) ProtoMessage() {}
+
+func (x *ListUsersResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_user_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListUsersResponse.ProtoReflect.Descriptor instead.
+func (*ListUsersResponse) Descriptor() ([]byte, []int) {
+	return file_user_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *ListUsersResponse) GetUsers() []*User {
+	if x != nil {
+		return x.Users
+	}
+	return nil
+}
+
+func (x *ListUsersResponse) GetNextPageToken() string {
+	if x != nil {
+		return x.NextPageToken
+	}
+	return ""
+}
+
+// DeleteUserRequest identifies the user by exactly one of ID and userName
+type DeleteUserRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ID            string                 `protobuf:"bytes,1,opt,name=ID,proto3" json:"ID,omitempty"`
+	UserName      string                 `protobuf:"bytes,2,opt,name=userName,proto3" json:"userName,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteUserRequest) Reset() {
+	*x = DeleteUserRequest{}
+	mi := &file_user_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteUserRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteUserRequest) ProtoMessage() {}
+
+func (x *DeleteUserRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_user_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteUserRequest.ProtoReflect.Descriptor instead.
+func (*DeleteUserRequest) Descriptor() ([]byte, []int) {
+	return file_user_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *DeleteUserRequest) GetID() string {
+	if x != nil {
+		return x.ID
+	}
+	return ""
+}
+
+func (x *DeleteUserRequest) GetUserName() string {
+	if x != nil {
+		return x.UserName
+	}
+	return ""
+}
+
+type DeleteUserResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// user is the removed user
+	User          *User `protobuf:"bytes,1,opt,name=user,proto3" json:"user,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteUserResponse) Reset() {
+	*x = DeleteUserResponse{}
+	mi := &file_user_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteUserResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteUserResponse) ProtoMessage() {}
+
+func (x *DeleteUserResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_user_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteUserResponse.ProtoReflect.Descriptor instead.
+func (*DeleteUserResponse) Descriptor() ([]byte, []int) {
+	return file_user_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *DeleteUserResponse) GetUser() *User {
+	if x != nil {
+		return x.User
+	}
+	return nil
+}
+
 var File_user_proto protoreflect.FileDescriptor
 
 const file_user_proto_rawDesc = "" +
 	"\n" +
 	"\n" +
-	"user.proto\x12\x04user\"d\n" +
+	"user.proto\x12\x04user\"\x80\x01\n" +
 	"\x04User\x12\x0e\n" +
 	"\x02ID\x18\x01 \x01(\tR\x02ID\x12\x1a\n" +
 	"\buserName\x18\x02 \x01(\tR\buserName\x120\n" +
 	"\n" +
 	"coordinate\x18\x03 \x01(\v2\x10.user.CoordinateR\n" +
-	"coordinate\"F\n" +
+	"coordinate\x12\x1a\n" +
+	"\bdistance\x18\x04 \x01(\x01R\bdistance\"F\n" +
 	"\n" +
 	"Coordinate\x12\x1a\n" +
 	"\blatitude\x18\x01 \x01(\x01R\blatitude\x12\x1c\n" +
 	"\tlongitude\x18\x02 \x01(\x01R\tlongitude\"4\n" +
 	"\x12CreateUserResponse\x12\x1e\n" +
 	"\x04user\x18\x01 \x01(\v2\n" +
-	".user.UserR\x04user\"a\n" +
+	".user.UserR\x04user\"\x81\x01\n" +
 	"\x11UpdateUserRequest\x12\x1a\n" +
 	"\buserName\x18\x01 \x01(\tR\buserName\x120\n" +
 	"\n" +
 	"coordinate\x18\x02 \x01(\v2\x10.user.CoordinateR\n" +
-	"coordinate\"4\n" +
+	"coordinate\x12\x1e\n" +
+	"\n" +
+	"recordedAt\x18\x03 \x01(\tR\n" +
+	"recordedAt\"4\n" +
 	"\x12UpdateUserResponse\x12\x1e\n" +
 	"\x04user\x18\x01 \x01(\v2\n" +
-	".user.UserR\x04user\"^\n" +
+	".user.UserR\x04user\"\xb0\x02\n" +
 	"\x12SearchUsersRequest\x120\n" +
 	"\n" +
 	"coordinate\x18\x01 \x01(\v2\x10.user.CoordinateR\n" +
 	"coordinate\x12\x16\n" +
-	"\x06radius\x18\x02 \x01(\x02R\x06radius\"7\n" +
+	"\x06radius\x18\x02 \x01(\x02R\x06radius\x12\x1a\n" +
+	"\bpageSize\x18\x03 \x01(\x05R\bpageSize\x12\x1c\n" +
+	"\tpageToken\x18\x04 \x01(\tR\tpageToken\x12\x12\n" +
+	"\x04sort\x18\x05 \x01(\tR\x04sort\x12&\n" +
+	"\x06circle\x18\x06 \x01(\v2\f.user.CircleH\x00R\x06circle\x12'\n" +
+	"\x04bbox\x18\a \x01(\v2\x11.user.BoundingBoxH\x00R\x04bbox\x12)\n" +
+	"\apolygon\x18\b \x01(\v2\r.user.PolygonH\x00R\apolygonB\x06\n" +
+	"\x04area\"J\n" +
+	"\x06Circle\x12(\n" +
+	"\x06center\x18\x01 \x01(\v2\x10.user.CoordinateR\x06center\x12\x16\n" +
+	"\x06radius\x18\x02 \x01(\x01R\x06radius\"m\n" +
+	"\vBoundingBox\x12.\n" +
+	"\tsouthWest\x18\x01 \x01(\v2\x10.user.CoordinateR\tsouthWest\x12.\n" +
+	"\tnorthEast\x18\x02 \x01(\v2\x10.user.CoordinateR\tnorthEast\"7\n" +
+	"\aPolygon\x12,\n" +
+	"\bvertices\x18\x01 \x03(\v2\x10.user.CoordinateR\bvertices\"]\n" +
 	"\x13SearchUsersResponse\x12 \n" +
 	"\x05users\x18\x01 \x03(\v2\n" +
-	".user.UserR\x05users2\xd3\x01\n" +
+	".user.UserR\x05users\x12$\n" +
+	"\rnextPageToken\x18\x02 \x01(\tR\rnextPageToken\"U\n" +
+	"\x13NearestUsersRequest\x120\n" +
+	"\n" +
+	"coordinate\x18\x01 \x01(\v2\x10.user.CoordinateR\n" +
+	"coordinate\x12\f\n" +
+	"\x01k\x18\x02 \x01(\x05R\x01k\"8\n" +
+	"\x14NearestUsersResponse\x12 \n" +
+	"\x05users\x18\x01 \x03(\v2\n" +
+	".user.UserR\x05users\"<\n" +
+	"\x0eGetUserRequest\x12\x0e\n" +
+	"\x02ID\x18\x01 \x01(\tR\x02ID\x12\x1a\n" +
+	"\buserName\x18\x02 \x01(\tR\buserName\"1\n" +
+	"\x0fGetUserResponse\x12\x1e\n" +
+	"\x04user\x18\x01 \x01(\v2\n" +
+	".user.UserR\x04user\"L\n" +
+	"\x10ListUsersRequest\x12\x1a\n" +
+	"\bpageSize\x18\x01 \x01(\x05R\bpageSize\x12\x1c\n" +
+	"\tpageToken\x18\x02 \x01(\tR\tpageToken\"[\n" +
+	"\x11ListUsersResponse\x12 \n" +
+	"\x05users\x18\x01 \x03(\v2\n" +
+	".user.UserR\x05users\x12$\n" +
+	"\rnextPageToken\x18\x02 \x01(\tR\rnextPageToken\"?\n" +
+	"\x11DeleteUserRequest\x12\x0e\n" +
+	"\x02ID\x18\x01 \x01(\tR\x02ID\x12\x1a\n" +
+	"\buserName\x18\x02 \x01(\tR\buserName\"4\n" +
+	"\x12DeleteUserResponse\x12\x1e\n" +
+	"\x04user\x18\x01 \x01(\v2\n" +
+	".user.UserR\x04user2\xd1\x03\n" +
 	"\vUserService\x12?\n" +
 	"\n" +
 	"CreateUser\x12\x17.user.UpdateUserRequest\x1a\x18.user.CreateUserResponse\x12?\n" +
 	"\n" +
 	"UpdateUser\x12\x17.user.UpdateUserRequest\x1a\x18.user.UpdateUserResponse\x12B\n" +
-	"\vSearchUsers\x12\x18.user.SearchUsersRequest\x1a\x19.user.SearchUsersResponseB\x18Z\x16shared/proto/user;userb\x06proto3"
+	"\vSearchUsers\x12\x18.user.SearchUsersRequest\x1a\x19.user.SearchUsersResponse\x12E\n" +
+	"\fNearestUsers\x12\x19.user.NearestUsersRequest\x1a\x1a.user.NearestUsersResponse\x126\n" +
+	"\aGetUser\x12\x14.user.GetUserRequest\x1a\x15.user.GetUserResponse\x12<\n" +
+	"\tListUsers\x12\x16.user.ListUsersRequest\x1a\x17.user.ListUsersResponse\x12?\n" +
+	"\n" +
+	"DeleteUser\x12\x17.user.DeleteUserRequest\x1a\x18.user.DeleteUserResponseB\x18Z\x16shared/proto/user;userb\x06proto3"
 
 var (
 	file_user_proto_rawDescOnce sync.Once
@@ -423,34 +1144,65 @@ func file_user_proto_rawDescGZIP() []byte {
 	return file_user_proto_rawDescData
 }
 
-var file_user_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_user_proto_msgTypes = make([]protoimpl.MessageInfo, 18)
 var file_user_proto_goTypes = []any{
-	(*User)(nil),                // 0: user.User
-	(*Coordinate)(nil),          // 1: user.Coordinate
-	(*CreateUserResponse)(nil),  // 2: user.CreateUserResponse
-	(*UpdateUserRequest)(nil),   // 3: user.UpdateUserRequest
-	(*UpdateUserResponse)(nil),  // 4: user.UpdateUserResponse
-	(*SearchUsersRequest)(nil),  // 5: user.SearchUsersRequest
-	(*SearchUsersResponse)(nil), // 6: user.SearchUsersResponse
+	(*User)(nil),                 // 0: user.User
+	(*Coordinate)(nil),           // 1: user.Coordinate
+	(*CreateUserResponse)(nil),   // 2: user.CreateUserResponse
+	(*UpdateUserRequest)(nil),    // 3: user.UpdateUserRequest
+	(*UpdateUserResponse)(nil),   // 4: user.UpdateUserResponse
+	(*SearchUsersRequest)(nil),   // 5: user.SearchUsersRequest
+	(*Circle)(nil),               // 6: user.Circle
+	(*BoundingBox)(nil),          // 7: user.BoundingBox
+	(*Polygon)(nil),              // 8: user.Polygon
+	(*SearchUsersResponse)(nil),  // 9: user.SearchUsersResponse
+	(*NearestUsersRequest)(nil),  // 10: user.NearestUsersRequest
+	(*NearestUsersResponse)(nil), // 11: user.NearestUsersResponse
+	(*GetUserRequest)(nil),       // 12: user.GetUserRequest
+	(*GetUserResponse)(nil),      // 13: user.GetUserResponse
+	(*ListUsersRequest)(nil),     // 14: user.ListUsersRequest
+	(*ListUsersResponse)(nil),    // 15: user.ListUsersResponse
+	(*DeleteUserRequest)(nil),    // 16: user.DeleteUserRequest
+	(*DeleteUserResponse)(nil),   // 17: user.DeleteUserResponse
 }
 var file_user_proto_depIdxs = []int32{
-	1, // 0: user.User.coordinate:type_name -> user.Coordinate
-	0, // 1: user.CreateUserResponse.user:type_name -> user.User
-	1, // 2: user.UpdateUserRequest.coordinate:type_name -> user.Coordinate
-	0, // 3: user.UpdateUserResponse.user:type_name -> user.User
-	1, // 4: user.SearchUsersRequest.coordinate:type_name -> user.Coordinate
-	0, // 5: user.SearchUsersResponse.users:type_name -> user.User
-	3, // 6: user.UserService.CreateUser:input_type -> user.UpdateUserRequest
-	3, // 7: user.UserService.UpdateUser:input_type -> user.UpdateUserRequest
-	5, // 8: user.UserService.SearchUsers:input_type -> user.SearchUsersRequest
-	2, // 9: user.UserService.CreateUser:output_type -> user.CreateUserResponse
-	4, // 10: user.UserService.UpdateUser:output_type -> user.UpdateUserResponse
-	6, // 11: user.UserService.SearchUsers:output_type -> user.SearchUsersResponse
-	9, // [9:12] is the sub-list for method output_type
-	6, // [6:9] is the sub-list for method input_type
-	6, // [6:6] is the sub-list for extension type_name
-	6, // [6:6] is the sub-list for extension extendee
-	0, // [0:6] is the sub-list for field type_name
+	1,  // 0: user.User.coordinate:type_name -> user.Coordinate
+	0,  // 1: user.CreateUserResponse.user:type_name -> user.User
+	1,  // 2: user.UpdateUserRequest.coordinate:type_name -> user.Coordinate
+	0,  // 3: user.UpdateUserResponse.user:type_name -> user.User
+	1,  // 4: user.SearchUsersRequest.coordinate:type_name -> user.Coordinate
+	6,  // 5: user.SearchUsersRequest.circle:type_name -> user.Circle
+	7,  // 6: user.SearchUsersRequest.bbox:type_name -> user.BoundingBox
+	8,  // 7: user.SearchUsersRequest.polygon:type_name -> user.Polygon
+	1,  // 8: user.Circle.center:type_name -> user.Coordinate
+	1,  // 9: user.BoundingBox.southWest:type_name -> user.Coordinate
+	1,  // 10: user.BoundingBox.northEast:type_name -> user.Coordinate
+	1,  // 11: user.Polygon.vertices:type_name -> user.Coordinate
+	0,  // 12: user.SearchUsersResponse.users:type_name -> user.User
+	1,  // 13: user.NearestUsersRequest.coordinate:type_name -> user.Coordinate
+	0,  // 14: user.NearestUsersResponse.users:type_name -> user.User
+	0,  // 15: user.GetUserResponse.user:type_name -> user.User
+	0,  // 16: user.ListUsersResponse.users:type_name -> user.User
+	0,  // 17: user.DeleteUserResponse.user:type_name -> user.User
+	3,  // 18: user.UserService.CreateUser:input_type -> user.UpdateUserRequest
+	3,  // 19: user.UserService.UpdateUser:input_type -> user.UpdateUserRequest
+	5,  // 20: user.UserService.SearchUsers:input_type -> user.SearchUsersRequest
+	10, // 21: user.UserService.NearestUsers:input_type -> user.NearestUsersRequest
+	12, // 22: user.UserService.GetUser:input_type -> user.GetUserRequest
+	14, // 23: user.UserService.ListUsers:input_type -> user.ListUsersRequest
+	16, // 24: user.UserService.DeleteUser:input_type -> user.DeleteUserRequest
+	2,  // 25: user.UserService.CreateUser:output_type -> user.CreateUserResponse
+	4,  // 26: user.UserService.UpdateUser:output_type -> user.UpdateUserResponse
+	9,  // 27: user.UserService.SearchUsers:output_type -> user.SearchUsersResponse
+	11, // 28: user.UserService.NearestUsers:output_type -> user.NearestUsersResponse
+	13, // 29: user.UserService.GetUser:output_type -> user.GetUserResponse
+	15, // 30: user.UserService.ListUsers:output_type -> user.ListUsersResponse
+	17, // 31: user.UserService.DeleteUser:output_type -> user.DeleteUserResponse
+	25, // [25:32] is the sub-list for method output_type
+	18, // [18:25] is the sub-list for method input_type
+	18, // [18:18] is the sub-list for extension type_name
+	18, // [18:18] is the sub-list for extension extendee
+	0,  // [0:18] is the sub-list for field type_name
 }
 
 func init() { file_user_proto_init() }
@@ -458,13 +1210,18 @@ func file_user_proto_init() {
 	if File_user_proto != nil {
 		return
 	}
+	file_user_proto_msgTypes[5].OneofWrappers = []any{
+		(*SearchUsersRequest_Circle)(nil),
+		(*SearchUsersRequest_Bbox)(nil),
+		(*SearchUsersRequest_Polygon)(nil),
+	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_user_proto_rawDesc), len(file_user_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   7,
+			NumMessages:   18,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
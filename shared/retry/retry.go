@@ -56,3 +56,16 @@ func WithBackoff(ctx context.Context, cfg Config, operation func() error) error
 
 	return err
 }
+
+// Wait returns the backoff before the given retry, counted from 1, following the same
+// exponential schedule as WithBackoff
+func (cfg Config) Wait(retry int) time.Duration {
+	wait := cfg.InitialWait
+	for i := 1; i < retry && wait < cfg.MaxWait; i++ {
+		wait *= 2
+	}
+	if wait > cfg.MaxWait {
+		wait = cfg.MaxWait
+	}
+	return wait
+}
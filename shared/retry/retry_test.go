@@ -0,0 +1,34 @@
+package retry
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConfig_Wait(t *testing.T) {
+	cfg := Config{
+		MaxRetries:  5,
+		InitialWait: 1 * time.Second,
+		MaxWait:     5 * time.Second,
+	}
+
+	tests := []struct {
+		name     string
+		retry    int
+		expected time.Duration
+	}{
+		{name: "first retry", retry: 1, expected: 1 * time.Second},
+		{name: "second retry doubles", retry: 2, expected: 2 * time.Second},
+		{name: "third retry doubles again", retry: 3, expected: 4 * time.Second},
+		{name: "capped at max wait", retry: 4, expected: 5 * time.Second},
+		{name: "stays capped", retry: 50, expected: 5 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if wait := cfg.Wait(tt.retry); wait != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, wait)
+			}
+		})
+	}
+}
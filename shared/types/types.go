@@ -14,8 +14,3 @@ type Coordinate struct {
 	Latitude  float64 `json:"latitude" bson:"latitude"`
 	Longitude float64 `json:"longitude" bson:"longitude"`
 }
-
-type UserLocation struct {
-	UserId     string      `json:"userId"`
-	Coordinate *Coordinate `json:"coordinate"`
-}
@@ -5,10 +5,12 @@ import (
 	"math"
 )
 
+// EarthRadius is Earth's radius in kilometers
+const EarthRadius = 6371
+
 // calculateDistance computes the distance between two coordinates using the Haversine formula.
 
 func CalculateDistance(coord1, coord2 *types.Coordinate) float64 {
-	const earthRadius = 6371 // Earth's radius in kilometers
 
 	lat1, lon1 := degreesToRadians(coord1.Latitude), degreesToRadians(coord1.Longitude)
 	lat2, lon2 := degreesToRadians(coord2.Latitude), degreesToRadians(coord2.Longitude)
@@ -21,7 +23,7 @@ func CalculateDistance(coord1, coord2 *types.Coordinate) float64 {
 
 	c := 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
 
-	return earthRadius * c
+	return EarthRadius * c
 }
 
 // degreesToRadians converts degrees to radians.
@@ -0,0 +1,138 @@
+package util
+
+import (
+	"go-clinet-locations/shared/types"
+	"math"
+)
+
+// PointInPolygon reports whether the point lies inside the polygon. The edges are great circle arcs,
+// as in a GeoJSON polygon searched by MongoDB, so polygons crossing the antimeridian work as well.
+// The polygon may be given either closed (last vertex equal to the first one) or open.
+// A polygon that does not fit in the hemisphere around its center contains nothing.
+func PointInPolygon(point *types.Coordinate, polygon []*types.Coordinate) bool {
+	if len(polygon) < 3 {
+		return false
+	}
+
+	// the gnomonic projection maps great circles to straight lines, so ray casting on the
+	// projected vertices follows the edges on the sphere
+	projection := newGnomonicProjection(PolygonCenter(polygon))
+
+	projected := make([]*types.Coordinate, 0, len(polygon))
+	for _, vertex := range polygon {
+		p, ok := projection.project(vertex)
+		if !ok {
+			return false
+		}
+		projected = append(projected, p)
+	}
+
+	p, ok := projection.project(point)
+	if !ok {
+		return false
+	}
+
+	return pointInPlanarPolygon(p, projected)
+}
+
+// PolygonCenter returns the point on the sphere in the direction of the mean of the vertices
+func PolygonCenter(polygon []*types.Coordinate) *types.Coordinate {
+	var sum vector
+	for _, vertex := range polygon {
+		sum = sum.add(toVector(vertex))
+	}
+
+	return &types.Coordinate{
+		Latitude:  math.Atan2(sum.z, math.Hypot(sum.x, sum.y)) * 180 / math.Pi,
+		Longitude: math.Atan2(sum.y, sum.x) * 180 / math.Pi,
+	}
+}
+
+// pointInPlanarPolygon is the ray casting algorithm with longitude as x and latitude as y
+func pointInPlanarPolygon(point *types.Coordinate, polygon []*types.Coordinate) bool {
+	inside := false
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		a, b := polygon[i], polygon[j]
+
+		// count the edges crossed by a ray going east from the point
+		if (a.Latitude > point.Latitude) != (b.Latitude > point.Latitude) {
+			crossing := a.Longitude + (point.Latitude-a.Latitude)*(b.Longitude-a.Longitude)/(b.Latitude-a.Latitude)
+			if point.Longitude < crossing {
+				inside = !inside
+			}
+		}
+	}
+
+	return inside
+}
+
+// vector is a point on the unit sphere
+type vector struct {
+	x, y, z float64
+}
+
+func toVector(c *types.Coordinate) vector {
+	lat, lon := degreesToRadians(c.Latitude), degreesToRadians(c.Longitude)
+	return vector{x: math.Cos(lat) * math.Cos(lon), y: math.Cos(lat) * math.Sin(lon), z: math.Sin(lat)}
+}
+
+func (v vector) add(o vector) vector {
+	return vector{x: v.x + o.x, y: v.y + o.y, z: v.z + o.z}
+}
+
+func (v vector) dot(o vector) float64 {
+	return v.x*o.x + v.y*o.y + v.z*o.z
+}
+
+func (v vector) cross(o vector) vector {
+	return vector{x: v.y*o.z - v.z*o.y, y: v.z*o.x - v.x*o.z, z: v.x*o.y - v.y*o.x}
+}
+
+func (v vector) normalize() vector {
+	length := math.Sqrt(v.dot(v))
+	return vector{x: v.x / length, y: v.y / length, z: v.z / length}
+}
+
+// gnomonicProjection projects the hemisphere around center onto the plane tangent to it
+type gnomonicProjection struct {
+	center, east, north vector
+}
+
+func newGnomonicProjection(center *types.Coordinate) gnomonicProjection {
+	c := toVector(center)
+
+	east := vector{z: 1}.cross(c)
+	if east.dot(east) < 1e-24 {
+		// at a pole any direction can be east
+		east = vector{y: 1}
+	}
+	east = east.normalize()
+
+	return gnomonicProjection{center: c, east: east, north: c.cross(east)}
+}
+
+// project returns the planar coordinates of the point, false when it is not in the hemisphere
+func (g gnomonicProjection) project(point *types.Coordinate) (*types.Coordinate, bool) {
+	v := toVector(point)
+
+	d := v.dot(g.center)
+	if d <= 0 {
+		return nil, false
+	}
+
+	return &types.Coordinate{Longitude: v.dot(g.east) / d, Latitude: v.dot(g.north) / d}, true
+}
+
+// PointInBoundingBox reports whether the point lies inside the box, edges included.
+// A box whose west edge is east of its east edge crosses the antimeridian.
+func PointInBoundingBox(point, southWest, northEast *types.Coordinate) bool {
+	if point.Latitude < southWest.Latitude || point.Latitude > northEast.Latitude {
+		return false
+	}
+
+	if southWest.Longitude <= northEast.Longitude {
+		return point.Longitude >= southWest.Longitude && point.Longitude <= northEast.Longitude
+	}
+
+	return point.Longitude >= southWest.Longitude || point.Longitude <= northEast.Longitude
+}
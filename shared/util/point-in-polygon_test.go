@@ -0,0 +1,180 @@
+package util
+
+import (
+	"go-clinet-locations/shared/types"
+	"testing"
+)
+
+func TestPointInPolygon(t *testing.T) {
+	// rough outline of the Wroclaw old town
+	oldTown := []*types.Coordinate{
+		{Latitude: 51.1140, Longitude: 17.0230},
+		{Latitude: 51.1140, Longitude: 17.0420},
+		{Latitude: 51.1050, Longitude: 17.0420},
+		{Latitude: 51.1050, Longitude: 17.0230},
+		{Latitude: 51.1140, Longitude: 17.0230},
+	}
+
+	// U shaped polygon, the gap between the arms is outside
+	uShape := []*types.Coordinate{
+		{Latitude: 0, Longitude: 0},
+		{Latitude: 0, Longitude: 3},
+		{Latitude: 3, Longitude: 3},
+		{Latitude: 3, Longitude: 2},
+		{Latitude: 1, Longitude: 2},
+		{Latitude: 1, Longitude: 1},
+		{Latitude: 3, Longitude: 1},
+		{Latitude: 3, Longitude: 0},
+	}
+
+	// the long edges follow great circles, at the middle meridian they reach about 74N and 67N
+	northernBand := []*types.Coordinate{
+		{Latitude: 60, Longitude: -60},
+		{Latitude: 60, Longitude: 60},
+		{Latitude: 50, Longitude: 60},
+		{Latitude: 50, Longitude: -60},
+	}
+
+	antimeridian := []*types.Coordinate{
+		{Latitude: -1, Longitude: 179},
+		{Latitude: -1, Longitude: -179},
+		{Latitude: 1, Longitude: -179},
+		{Latitude: 1, Longitude: 179},
+	}
+
+	tests := []struct {
+		name     string
+		point    *types.Coordinate
+		polygon  []*types.Coordinate
+		expected bool
+	}{
+		{
+			name:     "point inside closed polygon",
+			point:    &types.Coordinate{Latitude: 51.1100, Longitude: 17.0320},
+			polygon:  oldTown,
+			expected: true,
+		},
+		{
+			name:     "point outside closed polygon",
+			point:    &types.Coordinate{Latitude: 51.11822470712269, Longitude: 16.990711729269563},
+			polygon:  oldTown,
+			expected: false,
+		},
+		{
+			name:     "point inside open polygon",
+			point:    &types.Coordinate{Latitude: 51.1100, Longitude: 17.0320},
+			polygon:  oldTown[:4],
+			expected: true,
+		},
+		{
+			name:     "point inside the arm of a concave polygon",
+			point:    &types.Coordinate{Latitude: 2, Longitude: 0.5},
+			polygon:  uShape,
+			expected: true,
+		},
+		{
+			name:     "point in the gap of a concave polygon",
+			point:    &types.Coordinate{Latitude: 2, Longitude: 1.5},
+			polygon:  uShape,
+			expected: false,
+		},
+		{
+			name:     "point north of the corners, between the bulging edges",
+			point:    &types.Coordinate{Latitude: 70, Longitude: 0},
+			polygon:  northernBand,
+			expected: true,
+		},
+		{
+			name:     "point between the corners, south of the bulging edges",
+			point:    &types.Coordinate{Latitude: 55, Longitude: 0},
+			polygon:  northernBand,
+			expected: false,
+		},
+		{
+			name:     "polygon crossing the antimeridian - inside",
+			point:    &types.Coordinate{Latitude: 0, Longitude: -179.5},
+			polygon:  antimeridian,
+			expected: true,
+		},
+		{
+			name:     "polygon crossing the antimeridian - outside",
+			point:    &types.Coordinate{Latitude: 0, Longitude: 0},
+			polygon:  antimeridian,
+			expected: false,
+		},
+		{
+			name:     "point on the far side of the globe",
+			point:    &types.Coordinate{Latitude: -51.1100, Longitude: -162.968},
+			polygon:  oldTown,
+			expected: false,
+		},
+		{
+			name:     "degenerate polygon",
+			point:    &types.Coordinate{Latitude: 0, Longitude: 0},
+			polygon:  oldTown[:2],
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := PointInPolygon(tt.point, tt.polygon); result != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestPointInBoundingBox(t *testing.T) {
+	tests := []struct {
+		name      string
+		point     *types.Coordinate
+		southWest *types.Coordinate
+		northEast *types.Coordinate
+		expected  bool
+	}{
+		{
+			name:      "point inside",
+			point:     &types.Coordinate{Latitude: 51.11, Longitude: 17.03},
+			southWest: &types.Coordinate{Latitude: 51.0, Longitude: 16.9},
+			northEast: &types.Coordinate{Latitude: 51.2, Longitude: 17.1},
+			expected:  true,
+		},
+		{
+			name:      "point on the edge",
+			point:     &types.Coordinate{Latitude: 51.0, Longitude: 17.0},
+			southWest: &types.Coordinate{Latitude: 51.0, Longitude: 16.9},
+			northEast: &types.Coordinate{Latitude: 51.2, Longitude: 17.1},
+			expected:  true,
+		},
+		{
+			name:      "point north of the box",
+			point:     &types.Coordinate{Latitude: 52.23, Longitude: 17.0},
+			southWest: &types.Coordinate{Latitude: 51.0, Longitude: 16.9},
+			northEast: &types.Coordinate{Latitude: 51.2, Longitude: 17.1},
+			expected:  false,
+		},
+		{
+			name:      "box crossing the antimeridian - east side",
+			point:     &types.Coordinate{Latitude: 0, Longitude: -179.5},
+			southWest: &types.Coordinate{Latitude: -1, Longitude: 179},
+			northEast: &types.Coordinate{Latitude: 1, Longitude: -179},
+			expected:  true,
+		},
+		{
+			name:      "box crossing the antimeridian - outside",
+			point:     &types.Coordinate{Latitude: 0, Longitude: 0},
+			southWest: &types.Coordinate{Latitude: -1, Longitude: 179},
+			northEast: &types.Coordinate{Latitude: 1, Longitude: -179},
+			expected:  false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := PointInBoundingBox(tt.point, tt.southWest, tt.northEast); result != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,21 @@
+package util
+
+import (
+	"errors"
+	"time"
+)
+
+// ParseRecordedAt parses the RFC 3339 time a location fix was taken.
+// The fix may not be later than now by more than maxSkew, which tolerates clients with slightly fast clocks.
+func ParseRecordedAt(recordedAt string, now time.Time, maxSkew time.Duration) (time.Time, error) {
+	timestamp, err := time.Parse(time.RFC3339, recordedAt)
+	if err != nil {
+		return time.Time{}, errors.New("recordedAt must be an RFC 3339 timestamp")
+	}
+
+	if timestamp.After(now.Add(maxSkew)) {
+		return time.Time{}, errors.New("recordedAt must not be in the future")
+	}
+
+	return timestamp, nil
+}
@@ -0,0 +1,74 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRecordedAt(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		recordedAt  string
+		expected    time.Time
+		expectError bool
+		errorMsg    string
+	}{
+		{
+			name:       "valid timestamp in the past",
+			recordedAt: "2024-05-10T11:55:00Z",
+			expected:   time.Date(2024, 5, 10, 11, 55, 0, 0, time.UTC),
+		},
+		{
+			name:       "valid timestamp with offset and fraction",
+			recordedAt: "2024-05-10T13:59:30.5+02:00",
+			expected:   time.Date(2024, 5, 10, 11, 59, 30, 500000000, time.UTC),
+		},
+		{
+			name:       "slightly in the future within skew",
+			recordedAt: "2024-05-10T12:00:20Z",
+			expected:   time.Date(2024, 5, 10, 12, 0, 20, 0, time.UTC),
+		},
+		{
+			name:        "in the future beyond skew",
+			recordedAt:  "2024-05-10T12:05:00Z",
+			expectError: true,
+			errorMsg:    "recordedAt must not be in the future",
+		},
+		{
+			name:        "missing time zone",
+			recordedAt:  "2024-05-10T11:55:00",
+			expectError: true,
+			errorMsg:    "recordedAt must be an RFC 3339 timestamp",
+		},
+		{
+			name:        "not a timestamp",
+			recordedAt:  "yesterday",
+			expectError: true,
+			errorMsg:    "recordedAt must be an RFC 3339 timestamp",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ParseRecordedAt(tt.recordedAt, now, 30*time.Second)
+
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error but got none")
+				} else if err.Error() != tt.errorMsg {
+					t.Errorf("expected error message '%s', got '%s'", tt.errorMsg, err.Error())
+				}
+				return
+			}
+
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !result.Equal(tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}